@@ -1,24 +1,30 @@
 module github.com/segmentio/ecs-logs
 
-go 1.13
+go 1.22
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/apex/log v0.0.0-20160721172613-2dafa85a923a
 	github.com/aws/aws-sdk-go v1.2.10
+	github.com/coreos/go-systemd/v22 v22.5.0
+	github.com/golang/snappy v0.0.4
+	github.com/jpillora/backoff v0.0.0-20170918002102-8eab2debe79d
+	github.com/klauspost/compress v1.18.0
+	github.com/segmentio/ecs-logs-go v0.0.0-20170303021009-2f43d53e6e42
+	github.com/segmentio/jutil v0.0.0-20160802072905-2da69de91201
+	github.com/statsd/client v0.0.2-0.20140909221056-dd7e89e9895a
+	github.com/statsd/datadog v0.0.0-20160116190012-12a17042af0a
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a
+	gopkg.in/yaml.v2 v2.2.8
+)
+
+require (
 	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869 // indirect
-	github.com/coreos/go-systemd v0.0.0-20160728000419-fa8411dcbcba
-	github.com/coreos/pkg v0.0.0-20160727233714-3ac0863d7acf // indirect
 	github.com/go-ini/ini v1.18.0 // indirect
 	github.com/jmespath/go-jmespath v0.0.0-20160202185014-0b12d6b521d8 // indirect
-	github.com/jpillora/backoff v0.0.0-20170918002102-8eab2debe79d
 	github.com/kr/pretty v0.2.0 // indirect
-	github.com/segmentio/ecs-logs-go v0.0.0-20170303021009-2f43d53e6e42
-	github.com/segmentio/jutil v0.0.0-20160802072905-2da69de91201
 	github.com/smartystreets/goconvey v1.6.4 // indirect
-	github.com/statsd/client v0.0.2-0.20140909221056-dd7e89e9895a
 	github.com/statsd/client-interface v0.0.0-20140909221041-d354afbbdb2b // indirect
-	github.com/statsd/datadog v0.0.0-20160116190012-12a17042af0a
 	github.com/stretchr/testify v1.5.1 // indirect
 	github.com/visionmedia/go-debug v0.0.0-20180109164601-bfacf9d8a444 // indirect
-	golang.org/x/net v0.0.0-20190311183353-d8887717615a
 )
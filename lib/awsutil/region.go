@@ -1,4 +1,4 @@
-package cloudwatchlogs
+package awsutil
 
 import (
 	"encoding/json"
@@ -11,11 +11,14 @@ type document struct {
 	Region string `json:"region"`
 }
 
-func getAwsRegion() (region string, err error) {
+// Region returns the AWS region configured by the AWS_REGION or
+// AWS_DEFAULT_REGION environment variables, or the region of the EC2 instance
+// that the program is running on. The result is cached after the first call.
+func Region() (region string, err error) {
 	var res *http.Response
 	var doc document
 
-	if region = __getAwsRegion(); len(region) != 0 {
+	if region = cachedRegion(); len(region) != 0 {
 		return
 	}
 
@@ -49,7 +52,7 @@ saveRegion:
 	return
 }
 
-func __getAwsRegion() (region string) {
+func cachedRegion() (region string) {
 	regmtx.RLock()
 
 	if len(regvar) != 0 {
@@ -0,0 +1,30 @@
+package awsutil
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// NewSession returns an AWS session for region, which is detected with Region
+// when it's empty. When endpoint is set requests are sent to it instead of the
+// AWS endpoint of the service, this is mostly useful to test against local
+// stand-ins of AWS services.
+func NewSession(region string, endpoint string) (*session.Session, error) {
+	if len(region) == 0 {
+		var err error
+
+		if region, err = Region(); err != nil {
+			return nil, err
+		}
+	}
+
+	config := &aws.Config{
+		Region: aws.String(region),
+	}
+
+	if len(endpoint) != 0 {
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	return session.New(config), nil
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+)
+
+// An OverflowPolicy defines what happens to messages that would make the
+// program exceed its memory budget.
+type OverflowPolicy int
+
+const (
+	// Block stops reading messages until memory is released.
+	Block OverflowPolicy = iota
+
+	// DropOldest removes the oldest buffered messages to make room for the
+	// new one, from the stream of the new message when the stream limit is
+	// exceeded, or from all streams otherwise.
+	DropOldest
+
+	// DropNewest discards the new message.
+	DropNewest
+)
+
+func (p *OverflowPolicy) Set(s string) error {
+	switch s {
+	case "block":
+		*p = Block
+	case "drop-oldest":
+		*p = DropOldest
+	case "drop-newest":
+		*p = DropNewest
+	default:
+		return fmt.Errorf("invalid overflow policy, must be one of 'block', 'drop-oldest' or 'drop-newest': %s", s)
+	}
+	return nil
+}
+
+func (p OverflowPolicy) Get() interface{} {
+	return p
+}
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+type BudgetLimits struct {
+	// The maximum number of bytes of messages held in memory, zero means
+	// there are no limits.
+	MaxBytes int
+
+	// The maximum number of bytes of messages held in memory for a single
+	// stream, zero means there are no limits.
+	MaxStreamBytes int
+
+	Policy OverflowPolicy
+}
+
+// A Budget keeps track of the memory used by messages between the moment
+// they were read and the moment they were written to all destinations.
+//
+// Budgets are safe to use concurrently from multiple goroutines.
+type Budget struct {
+	C      <-chan struct{}
+	signal chan struct{}
+	limits BudgetLimits
+
+	mutex        sync.Mutex
+	bytes        int
+	held         int
+	streams      map[string]int
+	droppedCount int64
+	droppedBytes int64
+}
+
+func NewBudget(limits BudgetLimits) *Budget {
+	c := make(chan struct{}, 1)
+	return &Budget{
+		C:       c,
+		signal:  c,
+		limits:  limits,
+		streams: make(map[string]int, 100),
+	}
+}
+
+func (b *Budget) Policy() OverflowPolicy {
+	return b.limits.Policy
+}
+
+// Fits returns true if msg can be held in memory without exceeding the limits.
+// A message always fits when nothing is being held, so messages larger than
+// the limits do not get stuck forever.
+func (b *Budget) Fits(msg Message) bool {
+	n := msg.ContentLength()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if max := b.limits.MaxBytes; max > 0 && b.bytes != 0 && (b.bytes+b.held+n) > max {
+		return false
+	}
+
+	return b.fitsStream(msg, n)
+}
+
+// FitsStream returns true if msg can be held in memory without exceeding the
+// limit of its stream.
+func (b *Budget) FitsStream(msg Message) bool {
+	n := msg.ContentLength()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.fitsStream(msg, n)
+}
+
+func (b *Budget) fitsStream(msg Message, n int) bool {
+	s := b.streams[budgetKey(msg)]
+	return b.limits.MaxStreamBytes <= 0 || s == 0 || (s+n) <= b.limits.MaxStreamBytes
+}
+
+// Hold records the size of the messages held by the processing stages before
+// they are buffered, it replaces the previous value. These messages count
+// against the total limit only, and notify C when the size decreases.
+func (b *Budget) Hold(bytes int) {
+	b.mutex.Lock()
+	released := bytes < b.held
+	b.held = bytes
+	b.mutex.Unlock()
+
+	if released {
+		b.notify()
+	}
+}
+
+// Acquire records that msg is now held in memory.
+func (b *Budget) Acquire(msg Message) {
+	n := msg.ContentLength()
+	k := budgetKey(msg)
+	b.mutex.Lock()
+	b.bytes += n
+	b.streams[k] += n
+	b.mutex.Unlock()
+}
+
+// Release records that messages in batch are not held in memory anymore and
+// notifies C.
+func (b *Budget) Release(batch MessageBatch) {
+	b.mutex.Lock()
+
+	for _, msg := range batch {
+		n := msg.ContentLength()
+		k := budgetKey(msg)
+		b.bytes -= n
+
+		if b.streams[k] -= n; b.streams[k] <= 0 {
+			delete(b.streams, k)
+		}
+	}
+
+	b.mutex.Unlock()
+	b.notify()
+}
+
+func (b *Budget) notify() {
+	select {
+	default:
+	case b.signal <- struct{}{}:
+	}
+}
+
+// Drop counts messages in batch as dropped, the messages must not be held in
+// memory anymore.
+func (b *Budget) Drop(batch MessageBatch) {
+	var bytes int
+
+	for _, msg := range batch {
+		bytes += msg.ContentLength()
+	}
+
+	b.mutex.Lock()
+	b.droppedCount += int64(len(batch))
+	b.droppedBytes += int64(bytes)
+	b.mutex.Unlock()
+}
+
+// Bytes returns the number of bytes of messages held in memory.
+func (b *Budget) Bytes() (bytes int) {
+	b.mutex.Lock()
+	bytes = b.bytes + b.held
+	b.mutex.Unlock()
+	return
+}
+
+// Dropped returns the number of messages, and their size in bytes, that were
+// dropped because of the budget limits.
+func (b *Budget) Dropped() (count int64, bytes int64) {
+	b.mutex.Lock()
+	count, bytes = b.droppedCount, b.droppedBytes
+	b.mutex.Unlock()
+	return
+}
+
+func budgetKey(msg Message) string {
+	return msg.Group + ":" + msg.Stream
+}
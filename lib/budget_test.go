@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/segmentio/ecs-logs-go"
+)
+
+func TestBudget(t *testing.T) {
+	m1 := Message{Group: "A", Stream: "1", Event: ecslogs.Event{Message: "Hello World!"}}
+	m2 := Message{Group: "A", Stream: "2", Event: ecslogs.Event{Message: "How are you?"}}
+	m3 := Message{Group: "A", Stream: "1", Event: ecslogs.Event{Message: "Well"}}
+
+	b := NewBudget(BudgetLimits{
+		MaxBytes:       m1.ContentLength() + m2.ContentLength(),
+		MaxStreamBytes: m1.ContentLength(),
+	})
+
+	if !b.Fits(m1) {
+		t.Error("the first message should always fit")
+	}
+	b.Acquire(m1)
+
+	if !b.Fits(m2) {
+		t.Error("the second message should fit in the global budget")
+	}
+	b.Acquire(m2)
+
+	if b.Fits(m3) {
+		t.Error("the third message should exceed the budget")
+	}
+
+	b.Release(MessageBatch{m2})
+
+	if b.Fits(m3) {
+		t.Error("the third message should exceed the stream budget")
+	}
+
+	b.Release(MessageBatch{m1})
+
+	if !b.Fits(m3) {
+		t.Error("the third message should fit after the stream was released")
+	}
+
+	if n := b.Bytes(); n != 0 {
+		t.Error("invalid number of bytes held in memory:", n)
+	}
+
+	b.Drop(MessageBatch{m3})
+
+	if count, bytes := b.Dropped(); count != 1 || bytes != int64(m3.ContentLength()) {
+		t.Errorf("invalid dropped counters: %d messages, %d bytes", count, bytes)
+	}
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	for _, s := range []string{"block", "drop-oldest", "drop-newest"} {
+		var p OverflowPolicy
+
+		if err := p.Set(s); err != nil {
+			t.Error(err)
+		}
+
+		if p.String() != s {
+			t.Errorf("invalid overflow policy: %s != %s", p, s)
+		}
+	}
+
+	var p OverflowPolicy
+
+	if err := p.Set("whatever"); err == nil {
+		t.Error("expected an error for an invalid overflow policy")
+	}
+}
+
+func TestBudgetHold(t *testing.T) {
+	m1 := Message{Group: "A", Stream: "1", Event: ecslogs.Event{Message: "Hello World!"}}
+	m2 := Message{Group: "A", Stream: "2", Event: ecslogs.Event{Message: "How are you?"}}
+
+	b := NewBudget(BudgetLimits{MaxBytes: m1.ContentLength() + m2.ContentLength()})
+	b.Acquire(m1)
+	b.Hold(m2.ContentLength())
+
+	if b.Fits(m2) {
+		t.Error("the messages held by the processing stages should count against the budget")
+	}
+
+	if !b.FitsStream(m2) {
+		t.Error("the messages held by the processing stages should not count against the stream budget")
+	}
+
+	b.Hold(0)
+
+	select {
+	case <-b.C:
+	default:
+		t.Error("releasing held messages should notify the budget channel")
+	}
+
+	if !b.Fits(m2) {
+		t.Error("the message should fit once the held messages were released")
+	}
+}
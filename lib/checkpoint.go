@@ -0,0 +1,292 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// CheckpointInterval is the minimum time between two commits of a checkpoint,
+// positions acknowledged in between are coalesced in a single commit so the
+// state isn't written for every message batch.
+var CheckpointInterval = time.Second
+
+// A Checkpoint tracks the delivery of messages produced by a reader and
+// commits the position of the most recent message for which every message
+// read before it was also delivered.
+//
+// Readers call Track for each message they produce and attach the returned
+// position to the message, the program then calls AckBatch once a batch has
+// been handled by all destinations, and FlushCheckpoints before exiting.
+type Checkpoint struct {
+	mutex    sync.Mutex
+	commit   func(string) error
+	next     uint64
+	low      uint64
+	acked    map[uint64]string
+	interval time.Duration
+	last     time.Time
+	value    string
+	dirty    bool
+	timer    *time.Timer
+}
+
+// A Position identifies a message tracked by a checkpoint.
+type Position struct {
+	checkpoint *Checkpoint
+	seq        uint64
+	value      string
+
+	// The number of holds on the position and whether it was acknowledged
+	// while held, guarded by the mutex of the checkpoint.
+	holds int
+	acked bool
+}
+
+func NewCheckpoint(commit func(string) error) *Checkpoint {
+	return NewCheckpointInterval(commit, CheckpointInterval)
+}
+
+// NewCheckpointInterval returns a checkpoint that commits positions at most
+// once per interval, a zero interval commits them as soon as they move.
+func NewCheckpointInterval(commit func(string) error, interval time.Duration) *Checkpoint {
+	return &Checkpoint{
+		commit:   commit,
+		acked:    make(map[uint64]string, 1000),
+		interval: interval,
+	}
+}
+
+func (c *Checkpoint) Track(value string) (pos *Position) {
+	c.mutex.Lock()
+	pos = &Position{
+		checkpoint: c,
+		seq:        c.next,
+		value:      value,
+	}
+	c.next++
+	c.mutex.Unlock()
+	return
+}
+
+func (c *Checkpoint) ack(list []*Position) (err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, pos := range list {
+		if pos.holds != 0 {
+			pos.acked = true
+			continue
+		}
+
+		if pos.seq >= c.low {
+			c.acked[pos.seq] = pos.value
+		}
+	}
+
+	return c.advance()
+}
+
+func (c *Checkpoint) hold(list []*Position) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, pos := range list {
+		pos.holds++
+	}
+}
+
+func (c *Checkpoint) release(list []*Position) (err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, pos := range list {
+		if pos.holds--; pos.holds == 0 && pos.acked && pos.seq >= c.low {
+			c.acked[pos.seq] = pos.value
+		}
+	}
+
+	return c.advance()
+}
+
+// advance moves the low-water mark past the positions that were acknowledged
+// and commits the last one, it must be called while holding the lock.
+func (c *Checkpoint) advance() (err error) {
+	var value string
+	var moved bool
+
+	for {
+		v, ok := c.acked[c.low]
+		if !ok {
+			break
+		}
+		delete(c.acked, c.low)
+		value, moved = v, true
+		c.low++
+	}
+
+	if !moved {
+		return
+	}
+
+	return c.update(value)
+}
+
+// Commit sets the value committed by the checkpoint without tracking
+// positions, it's committed like acknowledged positions, at most once per
+// interval and by FlushCheckpoints.
+func (c *Checkpoint) Commit(value string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.update(value)
+}
+
+// update schedules the commit of value, it must be called while holding the
+// lock.
+func (c *Checkpoint) update(value string) error {
+	c.value, c.dirty = value, true
+	now := time.Now()
+
+	if wait := c.last.Add(c.interval).Sub(now); wait > 0 {
+		if c.timer == nil {
+			c.timer = time.AfterFunc(wait, c.tick)
+			pending.add(c)
+		}
+		return nil
+	}
+
+	return c.flush(now)
+}
+
+// flush commits the last acknowledged position, it must be called while
+// holding the lock so positions are always committed in order.
+func (c *Checkpoint) flush(now time.Time) error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+		pending.remove(c)
+	}
+
+	if !c.dirty {
+		return nil
+	}
+
+	c.dirty, c.last = false, now
+	return c.commit(c.value)
+}
+
+func (c *Checkpoint) tick() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.flush(time.Now()); err != nil {
+		log.WithError(err).Error("failed to commit checkpoint")
+	}
+}
+
+// Flush commits the last acknowledged position if it wasn't already.
+func (c *Checkpoint) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.flush(time.Now())
+}
+
+// checkpoints is the set of checkpoints that have positions waiting to be
+// committed.
+type checkpoints struct {
+	mutex sync.Mutex
+	set   map[*Checkpoint]struct{}
+}
+
+var pending = &checkpoints{set: make(map[*Checkpoint]struct{})}
+
+func (s *checkpoints) add(c *Checkpoint) {
+	s.mutex.Lock()
+	s.set[c] = struct{}{}
+	s.mutex.Unlock()
+}
+
+func (s *checkpoints) remove(c *Checkpoint) {
+	s.mutex.Lock()
+	delete(s.set, c)
+	s.mutex.Unlock()
+}
+
+func (s *checkpoints) list() (list []*Checkpoint) {
+	s.mutex.Lock()
+	for c := range s.set {
+		list = append(list, c)
+	}
+	s.mutex.Unlock()
+	return
+}
+
+// FlushCheckpoints commits the positions that were acknowledged but not yet
+// committed by all checkpoints.
+func FlushCheckpoints() (err error) {
+	for _, c := range pending.list() {
+		if e := c.Flush(); e != nil {
+			err = AppendError(err, e)
+		}
+	}
+	return
+}
+
+// groupPositions returns the positions of the messages in batch grouped by
+// checkpoint.
+func groupPositions(batch MessageBatch) (groups map[*Checkpoint][]*Position) {
+	for _, msg := range batch {
+		if msg.Position == nil {
+			continue
+		}
+
+		if groups == nil {
+			groups = make(map[*Checkpoint][]*Position)
+		}
+
+		c := msg.Position.checkpoint
+		groups[c] = append(groups[c], msg.Position)
+	}
+
+	return
+}
+
+// HoldBatch prevents the positions of the messages in batch from being
+// committed until the returned function is called, even if they are
+// acknowledged. Destinations which still hold messages after their writes
+// returned use it so the messages are read again after a restart if they
+// were never stored.
+//
+// The returned function must be called once, later calls do nothing.
+func HoldBatch(batch MessageBatch) (release func() error) {
+	var once sync.Once
+	groups := groupPositions(batch)
+
+	for c, list := range groups {
+		c.hold(list)
+	}
+
+	return func() (err error) {
+		once.Do(func() {
+			for c, list := range groups {
+				if e := c.release(list); e != nil {
+					err = AppendError(err, e)
+				}
+			}
+		})
+		return
+	}
+}
+
+// AckBatch reports to the checkpoints of messages in batch that they were
+// handled and won't need to be read again.
+func AckBatch(batch MessageBatch) (err error) {
+	for c, list := range groupPositions(batch) {
+		if e := c.ack(list); e != nil {
+			err = AppendError(err, e)
+		}
+	}
+
+	return
+}
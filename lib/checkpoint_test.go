@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckpointAckInOrder(t *testing.T) {
+	var commits []string
+
+	c := NewCheckpointInterval(func(value string) error {
+		commits = append(commits, value)
+		return nil
+	}, 0)
+
+	m1 := Message{Position: c.Track("A")}
+	m2 := Message{Position: c.Track("B")}
+	m3 := Message{Position: c.Track("C")}
+
+	if err := AckBatch(MessageBatch{m1, m2}); err != nil {
+		t.Error(err)
+	}
+
+	if err := AckBatch(MessageBatch{m3}); err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(commits, []string{"B", "C"}) {
+		t.Error("invalid commits:", commits)
+	}
+}
+
+func TestCheckpointHold(t *testing.T) {
+	var commits []string
+
+	c := NewCheckpointInterval(func(value string) error {
+		commits = append(commits, value)
+		return nil
+	}, 0)
+
+	m1 := Message{Position: c.Track("A")}
+	m2 := Message{Position: c.Track("B")}
+	release := HoldBatch(MessageBatch{m2})
+
+	if err := AckBatch(MessageBatch{m1, m2}); err != nil {
+		t.Error(err)
+	}
+
+	// The held position isn't committed until it's released.
+	if !reflect.DeepEqual(commits, []string{"A"}) {
+		t.Error("invalid commits:", commits)
+	}
+
+	if err := release(); err != nil {
+		t.Error(err)
+	}
+
+	release()
+
+	if !reflect.DeepEqual(commits, []string{"A", "B"}) {
+		t.Error("invalid commits:", commits)
+	}
+
+	// Positions released before they are acknowledged wait for it.
+	m3 := Message{Position: c.Track("C")}
+	HoldBatch(MessageBatch{m3})()
+
+	if len(commits) != 2 {
+		t.Error("invalid commits:", commits)
+	}
+
+	AckBatch(MessageBatch{m3})
+
+	if !reflect.DeepEqual(commits, []string{"A", "B", "C"}) {
+		t.Error("invalid commits:", commits)
+	}
+}
+
+func TestCheckpointAckOutOfOrder(t *testing.T) {
+	var commits []string
+
+	c := NewCheckpointInterval(func(value string) error {
+		commits = append(commits, value)
+		return nil
+	}, 0)
+
+	m1 := Message{Position: c.Track("A")}
+	m2 := Message{Position: c.Track("B")}
+	m3 := Message{Position: c.Track("C")}
+
+	// Acknowledging messages that were read after a message which is still
+	// pending must not move the checkpoint.
+	if err := AckBatch(MessageBatch{m3, m2}); err != nil {
+		t.Error(err)
+	}
+
+	if len(commits) != 0 {
+		t.Error("the checkpoint should not have been committed:", commits)
+	}
+
+	if err := AckBatch(MessageBatch{m1}); err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(commits, []string{"C"}) {
+		t.Error("invalid commits:", commits)
+	}
+}
+
+func TestAckBatchWithoutPositions(t *testing.T) {
+	if err := AckBatch(MessageBatch{Message{Group: "A"}, Message{Group: "B"}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckpointCoalesceCommits(t *testing.T) {
+	var commits []string
+
+	c := NewCheckpointInterval(func(value string) error {
+		commits = append(commits, value)
+		return nil
+	}, time.Hour)
+
+	m1 := Message{Position: c.Track("A")}
+	m2 := Message{Position: c.Track("B")}
+	m3 := Message{Position: c.Track("C")}
+
+	for _, m := range []Message{m1, m2, m3} {
+		if err := AckBatch(MessageBatch{m}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Only the first position is committed right away, the others wait for
+	// the interval to expire.
+	if !reflect.DeepEqual(commits, []string{"A"}) {
+		t.Error("invalid commits:", commits)
+	}
+
+	if err := FlushCheckpoints(); err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(commits, []string{"A", "C"}) {
+		t.Error("invalid commits:", commits)
+	}
+
+	if err := FlushCheckpoints(); err != nil {
+		t.Error(err)
+	}
+
+	if len(commits) != 2 {
+		t.Error("the checkpoint should not have been committed again:", commits)
+	}
+}
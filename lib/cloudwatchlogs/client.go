@@ -9,9 +9,19 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/awsutil"
 )
 
+// Config represents the options of cloudwatchlogs destinations declared in
+// configuration files, the region is detected from the environment when it's
+// not set.
+type Config struct {
+	Region string `json:"region"`
+}
+
 type client struct {
+	region string
+
 	cmtx   sync.Mutex
 	client *cloudwatchlogs.CloudWatchLogs
 
@@ -19,8 +29,9 @@ type client struct {
 	writers map[string]*writer
 }
 
-func newClient() *client {
+func newClient(config Config) *client {
 	return &client{
+		region:  config.Region,
 		writers: make(map[string]*writer, 100),
 	}
 }
@@ -88,7 +99,7 @@ func (c *client) getAwsClient() (client *cloudwatchlogs.CloudWatchLogs, err erro
 	defer c.cmtx.Unlock()
 
 	if client = c.client; client == nil {
-		if client, err = openAwsClient(); err != nil {
+		if client, err = openAwsClient(c.region); err != nil {
 			return
 		}
 		c.client = client
@@ -97,16 +108,14 @@ func (c *client) getAwsClient() (client *cloudwatchlogs.CloudWatchLogs, err erro
 	return
 }
 
-func openAwsClient() (client *cloudwatchlogs.CloudWatchLogs, err error) {
-	var region string
+func openAwsClient(region string) (client *cloudwatchlogs.CloudWatchLogs, err error) {
+	var sess *session.Session
 
-	if region, err = getAwsRegion(); err != nil {
+	if sess, err = awsutil.NewSession(region, ""); err != nil {
 		return
 	}
 
-	client = cloudwatchlogs.New(session.New(&aws.Config{
-		Region: aws.String(region),
-	}))
+	client = cloudwatchlogs.New(sess)
 	return
 }
 
@@ -175,3 +184,7 @@ func isAlreadyExists(err error) bool {
 func isThrottled(err error) bool {
 	return isAwsErrorCode(err, "ThrottlingException")
 }
+
+func isInvalidParameter(err error) bool {
+	return isAwsErrorCode(err, "InvalidParameterException")
+}
@@ -3,5 +3,14 @@ package cloudwatchlogs
 import "github.com/segmentio/ecs-logs/lib"
 
 func init() {
-	lib.RegisterDestination("cloudwatchlogs", newClient())
+	lib.RegisterDestination("cloudwatchlogs", newClient(Config{}))
+	lib.RegisterDestinationFactory("cloudwatchlogs", func(opts lib.Options) (lib.Destination, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		return newClient(c), nil
+	})
 }
@@ -93,6 +93,12 @@ func (w *writer) WriteMessageBatch(batch lib.MessageBatch) (err error) {
 		// be created.
 		w.parent.remove(w.group, w.stream)
 		w.parent = nil
+
+		if isInvalidParameter(err) {
+			// The batch was rejected because of its content, sending it again
+			// would fail the same way.
+			err = lib.PermanentError(err)
+		}
 		return
 	}
 
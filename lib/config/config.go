@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/filter"
+	"github.com/segmentio/ecs-logs/lib/multiline"
+	"github.com/segmentio/ecs-logs/lib/parse"
+	"github.com/segmentio/ecs-logs/lib/redact"
+	"github.com/segmentio/ecs-logs/lib/route"
+	"github.com/segmentio/ecs-logs/lib/transform"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of a configuration file, it declares the sources and
+// destinations used by the program. Before messages are buffered, the lines of
+// multiline events are merged, plain-text messages are parsed, transforms
+// modify messages, filters discard them and redaction removes sensitive data.
+// When routes are set messages are only written to the destinations of the
+// routes they match.
+type Config struct {
+	Sources      []Entry          `json:"sources"`
+	Destinations []Entry          `json:"destinations"`
+	Multiline    []multiline.Rule `json:"multiline"`
+	Parsers      []parse.Rule     `json:"parsers"`
+	Transforms   []transform.Rule `json:"transforms"`
+	Filters      []filter.Rule    `json:"filters"`
+	Redact       redact.Config    `json:"redact"`
+	Routes       []route.Rule     `json:"routes"`
+}
+
+// An Entry declares a source or a destination. The name identifies it in the
+// program logs and defaults to the type, which selects the implementation.
+type Entry struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Options lib.Options `json:"options"`
+}
+
+// Load reads the configuration file at path, the format is selected from the
+// file extension and can be one of JSON, YAML or TOML.
+func Load(path string) (config Config, err error) {
+	var b []byte
+
+	if b, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+
+	if config, err = Parse(b, strings.TrimPrefix(filepath.Ext(path), ".")); err != nil {
+		err = fmt.Errorf("%s: %s", path, err)
+	}
+
+	return
+}
+
+// Parse decodes and validates a configuration in the given format.
+func Parse(b []byte, format string) (config Config, err error) {
+	var v interface{}
+
+	switch format {
+	case "json":
+		err = json.Unmarshal(b, &v)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(b, &v)
+	case "toml":
+		var m map[string]interface{}
+		_, err = toml.Decode(string(b), &m)
+		v = m
+	default:
+		err = fmt.Errorf("unsupported configuration format, must be one of 'json', 'yaml' or 'toml': %q", format)
+	}
+
+	if err != nil {
+		return
+	}
+
+	// All formats are converted to JSON so the same rules apply to decoding
+	// the configuration, whatever the format of the file was.
+	if b, err = json.Marshal(normalize(v)); err != nil {
+		return
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+
+	if err = d.Decode(&config); err != nil {
+		return
+	}
+
+	err = config.Validate()
+	return
+}
+
+// Validate checks that all entries have a type and a unique name, the names
+// of entries that have none are set to their type.
+func (config *Config) Validate() error {
+	if err := validate("source", config.Sources); err != nil {
+		return err
+	}
+	return validate("destination", config.Destinations)
+}
+
+func validate(kind string, entries []Entry) error {
+	names := make(map[string]bool, len(entries))
+
+	for i := range entries {
+		e := &entries[i]
+
+		if len(e.Type) == 0 {
+			return fmt.Errorf("missing type in %s #%d", kind, i+1)
+		}
+
+		if len(e.Name) == 0 {
+			e.Name = e.Type
+		}
+
+		if names[e.Name] {
+			return fmt.Errorf("%s %q is declared more than once, entries of the same type must be given different names", kind, e.Name)
+		}
+
+		names[e.Name] = true
+	}
+
+	return nil
+}
+
+// NewSource creates the source declared by e.
+func (e Entry) NewSource() (source lib.Source, err error) {
+	factory := lib.GetSourceFactory(e.Type)
+
+	if factory == nil {
+		return nil, fmt.Errorf("source %q: unknown type %q", e.Name, e.Type)
+	}
+
+	if source, err = factory(e.Options); err != nil {
+		err = fmt.Errorf("source %q: %s", e.Name, err)
+	}
+
+	return
+}
+
+// NewDestination creates the destination declared by e.
+func (e Entry) NewDestination() (destination lib.Destination, err error) {
+	factory := lib.GetDestinationFactory(e.Type)
+
+	if factory == nil {
+		return nil, fmt.Errorf("destination %q: unknown type %q", e.Name, e.Type)
+	}
+
+	if destination, err = factory(e.Options); err != nil {
+		err = fmt.Errorf("destination %q: %s", e.Name, err)
+	}
+
+	return
+}
+
+// normalize converts the maps produced by the YAML decoder, which may have
+// keys of any types, to maps with string keys that can be encoded to JSON.
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			m[fmt.Sprint(k)] = normalize(v)
+		}
+		return m
+
+	case map[string]interface{}:
+		for k, v := range x {
+			x[k] = normalize(v)
+		}
+		return x
+
+	case []interface{}:
+		for i, v := range x {
+			x[i] = normalize(v)
+		}
+		return x
+
+	case []map[string]interface{}:
+		l := make([]interface{}, len(x))
+		for i, v := range x {
+			l[i] = normalize(v)
+		}
+		return l
+
+	default:
+		return v
+	}
+}
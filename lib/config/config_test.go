@@ -0,0 +1,159 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+	"github.com/segmentio/ecs-logs/lib/route"
+)
+
+func TestParse(t *testing.T) {
+	expected := Config{
+		Sources: []Entry{
+			{Name: "journald", Type: "journald", Options: lib.Options{"stream_name": "CONTAINER_ID"}},
+		},
+		Destinations: []Entry{
+			{Name: "syslog-a", Type: "syslog", Options: lib.Options{"url": "tcp://a:514"}},
+			{Name: "syslog-b", Type: "syslog", Options: lib.Options{"url": "tcp://b:514"}},
+		},
+	}
+
+	tests := []struct {
+		format string
+		config string
+	}{
+		{
+			format: "json",
+			config: `{
+  "sources": [{"type": "journald", "options": {"stream_name": "CONTAINER_ID"}}],
+  "destinations": [
+    {"name": "syslog-a", "type": "syslog", "options": {"url": "tcp://a:514"}},
+    {"name": "syslog-b", "type": "syslog", "options": {"url": "tcp://b:514"}}
+  ]
+}`,
+		},
+		{
+			format: "yaml",
+			config: `
+sources:
+  - type: journald
+    options:
+      stream_name: CONTAINER_ID
+destinations:
+  - name: syslog-a
+    type: syslog
+    options:
+      url: tcp://a:514
+  - name: syslog-b
+    type: syslog
+    options:
+      url: tcp://b:514
+`,
+		},
+		{
+			format: "toml",
+			config: `
+[[sources]]
+type = "journald"
+[sources.options]
+stream_name = "CONTAINER_ID"
+
+[[destinations]]
+name = "syslog-a"
+type = "syslog"
+[destinations.options]
+url = "tcp://a:514"
+
+[[destinations]]
+name = "syslog-b"
+type = "syslog"
+[destinations.options]
+url = "tcp://b:514"
+`,
+		},
+	}
+
+	for _, test := range tests {
+		c, err := Parse([]byte(test.config), test.format)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.format, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(c, expected) {
+			t.Errorf("%s: invalid configuration:\n- expected: %#v\n- found:    %#v", test.format, expected, c)
+		}
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	c, err := Parse([]byte(`
+destinations:
+  - type: stdout
+routes:
+  - group: billing-*
+    min_level: ERROR
+    destinations: [stdout]
+`), "yaml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []route.Rule{{
+		Config:       match.Config{Group: "billing-*", MinLevel: "ERROR"},
+		Destinations: []string{"stdout"},
+	}}
+
+	if !reflect.DeepEqual(c.Routes, expected) {
+		t.Errorf("invalid routes:\n- expected: %#v\n- found:    %#v", expected, c.Routes)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		config string
+		error  string
+	}{
+		{
+			config: `{"sources": [{"name": "A"}]}`,
+			error:  "missing type in source #1",
+		},
+		{
+			config: `{"destinations": [{"type": "syslog"}, {"type": "syslog"}]}`,
+			error:  `destination "syslog" is declared more than once`,
+		},
+		{
+			config: `{"destination": []}`,
+			error:  `unknown field "destination"`,
+		},
+	}
+
+	for _, test := range tests {
+		if _, err := Parse([]byte(test.config), "json"); err == nil {
+			t.Errorf("expected an error for %s", test.config)
+		} else if !strings.Contains(err.Error(), test.error) {
+			t.Errorf("invalid error for %s: %s", test.config, err)
+		}
+	}
+}
+
+func TestEntryNewDestination(t *testing.T) {
+	if _, err := (Entry{Name: "A", Type: "stdout"}).NewDestination(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := (Entry{Name: "A", Type: "whatever"}).NewDestination(); err == nil {
+		t.Error("expected an error for an unknown destination type")
+	}
+
+	if _, err := (Entry{Name: "A", Type: "stdout", Options: lib.Options{"url": "A"}}).NewDestination(); err == nil {
+		t.Error("expected an error for options that the destination doesn't support")
+	} else if s := err.Error(); s != `destination "A": invalid options: unknown field "url"` {
+		t.Error("invalid error:", s)
+	}
+}
@@ -12,25 +12,62 @@ import (
 	"github.com/statsd/datadog"
 )
 
-func NewWriter(group string, stream string) (w lib.Writer, err error) {
-	var c statsd.WriterConfig
-	var s string
+// Config represents the options of datadog destinations declared in
+// configuration files.
+type Config struct {
+	URL string `json:"url"`
+}
+
+func (config Config) address() (address string, err error) {
 	var u *url.URL
 
-	if s = os.Getenv("DATADOG_URL"); len(s) != 0 {
-		if u, err = url.Parse(s); err != nil {
-			err = fmt.Errorf("invalid datadog URL: %s", err)
-			return
-		}
+	if len(config.URL) == 0 {
+		return
+	}
+
+	if u, err = url.Parse(config.URL); err != nil {
+		err = fmt.Errorf("invalid datadog URL: %s", err)
+		return
+	}
+
+	if u.Scheme != "udp" {
+		err = fmt.Errorf("invalid datadog URL: only the UDP protocol is supported but %s was found", u.Scheme)
+		return
+	}
+
+	address = u.Host
+	return
+}
+
+func NewWriter(group string, stream string) (w lib.Writer, err error) {
+	var address string
+
+	if address, err = (Config{URL: os.Getenv("DATADOG_URL")}).address(); err != nil {
+		err = lib.PermanentError(err)
+		return
+	}
+
+	return newWriter(address, group, stream)
+}
 
-		if u.Scheme != "udp" {
-			err = fmt.Errorf("invalid datadog URL: only the UDP protocol is supported but %s was found", u.Scheme)
-			return
-		}
+// NewDestination returns a destination which sends metrics to the datadog
+// agent configured by config.
+func NewDestination(config Config) (lib.Destination, error) {
+	address, err := config.address()
 
-		c.Address = u.Host
+	if err != nil {
+		return nil, err
 	}
 
+	return lib.DestinationFunc(func(group string, stream string) (lib.Writer, error) {
+		return newWriter(address, group, stream)
+	}), nil
+}
+
+func newWriter(address string, group string, stream string) (lib.Writer, error) {
+	var c statsd.WriterConfig
+
+	c.Address = address
 	c.Group = group
 	c.Stream = stream
 	c.Dial = dialUdpClient
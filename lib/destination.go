@@ -12,6 +12,20 @@ type Destination interface {
 	Close(group string, stream string)
 }
 
+// A Flusher is a destination which holds messages after its writers returned,
+// Flush writes them and is called before the program exits.
+type Flusher interface {
+	Flush() error
+}
+
+// FlushDestination calls Flush on dest if it's a Flusher.
+func FlushDestination(dest Destination) error {
+	if f, ok := dest.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 type DestinationFunc func(group string, stream string) (Writer, error)
 
 func (f DestinationFunc) Open(group string, stream string) (Writer, error) {
@@ -51,6 +65,23 @@ func GetDestinations(names ...string) (destinations []Destination) {
 	return
 }
 
+// A DestinationFactory creates destinations from the options declared in a
+// configuration file.
+type DestinationFactory func(Options) (Destination, error)
+
+func RegisterDestinationFactory(name string, factory DestinationFactory) {
+	dstmtx.Lock()
+	dstfactories[name] = factory
+	dstmtx.Unlock()
+}
+
+func GetDestinationFactory(name string) (factory DestinationFactory) {
+	dstmtx.RLock()
+	factory = dstfactories[name]
+	dstmtx.RUnlock()
+	return
+}
+
 func DestinationsAvailable() (destinations []string) {
 	dstmtx.RLock()
 	destinations = make([]string, 0, len(dstmap))
@@ -71,4 +102,12 @@ var (
 			return NewMessageEncoder(os.Stdout), nil
 		}),
 	}
+	dstfactories = map[string]DestinationFactory{
+		"stdout": func(opts Options) (Destination, error) {
+			if err := opts.Decode(&struct{}{}); err != nil {
+				return nil, err
+			}
+			return GetDestination("stdout"), nil
+		},
+	}
 )
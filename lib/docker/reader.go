@@ -0,0 +1,238 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/file"
+)
+
+const (
+	DefaultContainersDir = "/var/lib/docker/containers"
+	DefaultPollInterval  = lib.Duration(time.Second)
+
+	// The maximum size of a line reassembled from partial lines, docker
+	// splits lines longer than 16KB.
+	maxLineBytes = 1048576
+)
+
+// Config represents the options of docker sources.
+type Config struct {
+	// The directory where docker stores the containers, it defaults to
+	// /var/lib/docker/containers.
+	ContainersDir string `json:"containers_dir"`
+
+	// The container labels that the group and stream are read from. The
+	// group defaults to the tag of the log lines, which is set by the tag log
+	// option of the container like the CONTAINER_TAG field of the journald
+	// driver, or the container name, and the stream defaults to the container
+	// name.
+	GroupLabel  string `json:"group_label"`
+	StreamLabel string `json:"stream_label"`
+
+	// The path to the file where the positions of the last log lines that
+	// were delivered are saved.
+	StateFile string `json:"state_file"`
+
+	// Where to start reading the logs of containers that exist when the
+	// source is opened and have no saved position, either "end" (the default)
+	// or "beginning". The logs of containers started later are always read
+	// from the beginning.
+	StartAt string `json:"start_at"`
+
+	// How often log files are checked for new lines and new containers.
+	PollInterval lib.Duration `json:"poll_interval"`
+}
+
+func (c Config) validate() error {
+	switch c.StartAt {
+	case "", "end", "beginning":
+	default:
+		return errors.New("start_at must be one of 'end' or 'beginning'")
+	}
+	return nil
+}
+
+func NewReader() (lib.Reader, error) {
+	return OpenReader(Config{
+		ContainersDir: os.Getenv("DOCKER_CONTAINERS_DIR"),
+		GroupLabel:    os.Getenv("DOCKER_GROUP_LABEL"),
+		StreamLabel:   os.Getenv("DOCKER_STREAM_LABEL"),
+		StateFile:     os.Getenv("DOCKER_STATE_FILE"),
+		StartAt:       os.Getenv("DOCKER_START_AT"),
+	})
+}
+
+func OpenReader(config Config) (lib.Reader, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(config.ContainersDir) == 0 {
+		config.ContainersDir = DefaultContainersDir
+	}
+
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+
+	return file.Poll(file.PollConfig{
+		StateFile:    config.StateFile,
+		StartAt:      config.StartAt,
+		PollInterval: config.PollInterval,
+
+		Scan: func() []string {
+			paths, _ := filepath.Glob(filepath.Join(config.ContainersDir, "*", "*-json.log"))
+			return paths
+		},
+
+		Open: func(path string) (file.LineDecoder, error) {
+			c, err := readContainer(filepath.Dir(path))
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid container configuration: %s", err)
+			}
+
+			l := &containerLog{
+				path:       path,
+				container:  c,
+				groupLabel: config.GroupLabel,
+				stream:     c.stream(config.StreamLabel),
+			}
+
+			return l.message, nil
+		},
+	})
+}
+
+// containerLog is the json-file log of a container.
+type containerLog struct {
+	path       string
+	container  container
+	groupLabel string
+	stream     string
+	partial    []byte
+}
+
+// logEntry is the format of the lines written by the json-file log driver.
+type logEntry struct {
+	Log    string            `json:"log"`
+	Stream string            `json:"stream"`
+	Attrs  map[string]string `json:"attrs"`
+	Time   time.Time         `json:"time"`
+}
+
+// message decodes a line of the container log, it returns false if the line
+// is invalid or is the beginning of a line that was split by docker.
+func (l *containerLog) message(line []byte) (msg lib.Message, ok bool) {
+	var e logEntry
+
+	if err := json.Unmarshal(line, &e); err != nil {
+		log.WithFields(log.Fields{
+			"path":  l.path,
+			"error": err,
+		}).Warn("skipping invalid container log line")
+		return
+	}
+
+	// Lines that don't end with a line feed were split by docker, they are
+	// buffered until the last part of the line is read.
+	if !strings.HasSuffix(e.Log, "\n") {
+		if len(l.partial)+len(e.Log) <= maxLineBytes {
+			l.partial = append(l.partial, e.Log...)
+			return
+		}
+	}
+
+	s := e.Log
+
+	if len(l.partial) != 0 {
+		s = string(l.partial) + s
+		l.partial = nil
+	}
+
+	// The tag is rendered by docker from the tag log option of the container
+	// and written in the attributes of each line.
+	msg = lib.Message{
+		Group:  l.container.group(l.groupLabel, e.Attrs["tag"]),
+		Stream: l.stream,
+	}
+
+	msg.Event, msg.PlainText = lib.ParseText(strings.TrimRight(s, "\r\n"))
+
+	if msg.Event.Time.IsZero() {
+		msg.Event.Time = e.Time
+	}
+
+	// The output the line was written to, stdout or stderr, is kept in the
+	// event data unless the event already has a stream value.
+	if len(e.Stream) != 0 {
+		if msg.Event.Data == nil {
+			msg.Event.Data = ecslogs.EventData{}
+		}
+
+		if _, ok := msg.Event.Data["stream"]; !ok {
+			msg.Event.Data["stream"] = e.Stream
+		}
+	}
+
+	ok = true
+	return
+}
+
+// container holds the metadata of a container read from the files that docker
+// stores in the container directory.
+type container struct {
+	Name   string
+	Labels map[string]string
+}
+
+func readContainer(dir string) (c container, err error) {
+	var b []byte
+	var config struct {
+		Name   string
+		Config struct {
+			Labels map[string]string
+		}
+	}
+
+	if b, err = ioutil.ReadFile(filepath.Join(dir, "config.v2.json")); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(b, &config); err != nil {
+		return
+	}
+
+	c = container{
+		Name:   strings.TrimPrefix(config.Name, "/"),
+		Labels: config.Config.Labels,
+	}
+	return
+}
+
+func (c container) group(label string, tag string) string {
+	if v := c.Labels[label]; len(label) != 0 && len(v) != 0 {
+		return v
+	}
+	if len(tag) != 0 {
+		return tag
+	}
+	return c.Name
+}
+
+func (c container) stream(label string) string {
+	if v := c.Labels[label]; len(label) != 0 && len(v) != 0 {
+		return v
+	}
+	return c.Name
+}
@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func TestReader(t *testing.T) {
+	root, err := ioutil.TempDir("", "ecs-logs-docker")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "0123456789")
+	os.Mkdir(dir, 0755)
+
+	ioutil.WriteFile(filepath.Join(dir, "config.v2.json"), []byte(`{
+  "ID": "0123456789",
+  "Name": "/api-1",
+  "Config": {"Labels": {"team": "billing"}}
+}`), 0644)
+
+	path := filepath.Join(dir, "0123456789-json.log")
+	ioutil.WriteFile(path, []byte(`{"log":"skipped\n","stream":"stdout","time":"2017-01-01T00:00:00Z"}`+"\n"), 0644)
+
+	r, err := OpenReader(Config{
+		ContainersDir: root,
+		PollInterval:  lib.Duration(10 * time.Millisecond),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.WriteString(`{"log":"hello ","stream":"stdout","attrs":{"tag":"api"},"time":"2017-01-01T00:00:01Z"}` + "\n")
+	f.WriteString(`{"log":"world\n","stream":"stdout","attrs":{"tag":"api"},"time":"2017-01-01T00:00:02Z"}` + "\n")
+	f.WriteString(`{"log":"{\"level\":\"ERROR\",\"message\":\"failed\"}\n","stream":"stderr","time":"2017-01-01T00:00:03Z"}` + "\n")
+	f.Close()
+
+	msg, err := r.ReadMessage()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Group != "api" || msg.Stream != "api-1" || msg.Event.Message != "hello world" || msg.Event.Data["stream"] != "stdout" {
+		t.Errorf("invalid message: %#v", msg)
+	}
+
+	if !msg.Event.Time.Equal(time.Date(2017, 1, 1, 0, 0, 2, 0, time.UTC)) {
+		t.Error("invalid time:", msg.Event.Time)
+	}
+
+	if msg, err = r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Group != "api-1" || msg.Event.Message != "failed" || msg.Event.Level.String() != "ERROR" || msg.Event.Data["stream"] != "stderr" {
+		t.Errorf("invalid message: %#v", msg)
+	}
+}
+
+func TestContainerGroupAndStream(t *testing.T) {
+	c := container{Name: "api-1", Labels: map[string]string{"team": "billing"}}
+
+	if g := c.group("", ""); g != "api-1" {
+		t.Error("invalid group:", g)
+	}
+
+	if g := c.group("", "api"); g != "api" {
+		t.Error("invalid group:", g)
+	}
+
+	if g := c.group("team", "api"); g != "billing" {
+		t.Error("invalid group:", g)
+	}
+
+	if s := c.stream("missing"); s != "api-1" {
+		t.Error("invalid stream:", s)
+	}
+}
@@ -0,0 +1,95 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// An Index is a template of index names, the {group} and {stream}
+// placeholders are replaced by the group and stream of messages and
+// {date:layout} by the time of the messages in UTC formatted with the time
+// layout, like logs-{group}-{date:2006.01.02}. The rest of the template is
+// copied as is. Index names are lowercased since elasticsearch doesn't accept
+// uppercase letters.
+type Index struct {
+	parts []indexPart
+}
+
+type indexPart struct {
+	text        string
+	layout      string
+	placeholder string
+}
+
+func ParseIndex(s string) (index Index, err error) {
+	if len(s) == 0 {
+		s = DefaultIndex
+	}
+
+	for len(s) != 0 {
+		i := strings.IndexByte(s, '{')
+
+		if i < 0 {
+			index.parts = append(index.parts, indexPart{text: s})
+			break
+		}
+
+		if i != 0 {
+			index.parts = append(index.parts, indexPart{text: s[:i]})
+		}
+
+		j := strings.IndexByte(s[i:], '}')
+
+		if j < 0 {
+			err = fmt.Errorf("invalid index template, missing '}': %s", s)
+			return
+		}
+
+		switch name := s[i+1 : i+j]; {
+		case name == "group", name == "stream":
+			index.parts = append(index.parts, indexPart{placeholder: name})
+		case strings.HasPrefix(name, "date:") && len(name) > len("date:"):
+			index.parts = append(index.parts, indexPart{placeholder: "date", layout: name[len("date:"):]})
+		default:
+			err = fmt.Errorf("invalid index template, unknown placeholder {%s}", name)
+			return
+		}
+
+		s = s[i+j+1:]
+	}
+
+	return
+}
+
+// Name returns the index name for a message of group and stream at time t.
+func (index Index) Name(group string, stream string, t time.Time) string {
+	var b strings.Builder
+	t = t.UTC()
+
+	for _, p := range index.parts {
+		switch p.placeholder {
+		case "group":
+			b.WriteString(sanitize(group))
+		case "stream":
+			b.WriteString(sanitize(stream))
+		case "date":
+			b.WriteString(t.Format(p.layout))
+		default:
+			b.WriteString(p.text)
+		}
+	}
+
+	return strings.ToLower(b.String())
+}
+
+// sanitize replaces the characters that aren't allowed in index names.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\\', '/', '*', '?', '"', '<', '>', '|', ' ', ',', '#', ':':
+			return '_'
+		}
+		return r
+	}, strings.TrimLeft(s, "/"))
+}
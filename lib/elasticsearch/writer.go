@@ -0,0 +1,314 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+const (
+	DefaultIndex   = "logs-{group}-{date:2006.01.02}"
+	DefaultTimeout = lib.Duration(30 * time.Second)
+)
+
+// Config represents the options of elasticsearch destinations, they work with
+// OpenSearch as well.
+type Config struct {
+	// The URL of the cluster, for example https://localhost:9200.
+	URL string `json:"url"`
+
+	// The template of index names, see Index.
+	Index string `json:"index"`
+
+	// Credentials for basic authentication, or an API key which is sent in
+	// the "Authorization: ApiKey <key>" header (it's the base64 encoded value
+	// returned when the key is created).
+	Username string `json:"username"`
+	Password string `json:"password"`
+	APIKey   string `json:"api_key"`
+
+	// Compress request bodies with gzip.
+	Gzip bool `json:"gzip"`
+
+	// The maximum amount of time a bulk request may take.
+	Timeout lib.Duration `json:"timeout"`
+}
+
+func (c Config) validate() error {
+	if len(c.URL) == 0 {
+		return errors.New("missing elasticsearch URL")
+	}
+
+	u, err := url.Parse(c.URL)
+
+	if err != nil {
+		return fmt.Errorf("invalid elasticsearch URL, %s: %s", err, c.URL)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid elasticsearch URL, the scheme must be one of 'http' or 'https': %s", c.URL)
+	}
+
+	if len(c.APIKey) != 0 && len(c.Username) != 0 {
+		return errors.New("username and api_key cannot be used together")
+	}
+
+	_, err = ParseIndex(c.Index)
+	return err
+}
+
+func configFromEnv() Config {
+	return Config{
+		URL:      os.Getenv("ELASTICSEARCH_URL"),
+		Index:    os.Getenv("ELASTICSEARCH_INDEX"),
+		Username: os.Getenv("ELASTICSEARCH_USERNAME"),
+		Password: os.Getenv("ELASTICSEARCH_PASSWORD"),
+		APIKey:   os.Getenv("ELASTICSEARCH_API_KEY"),
+		Gzip:     os.Getenv("ELASTICSEARCH_GZIP") == "true",
+	}
+}
+
+func NewWriter(group string, stream string) (lib.Writer, error) {
+	config := configFromEnv()
+
+	// Configuration errors won't go away by retrying the write operation.
+	if err := config.validate(); err != nil {
+		return nil, lib.PermanentError(err)
+	}
+
+	return newWriter(config, group, stream), nil
+}
+
+// NewDestination returns a destination which writes to the elasticsearch
+// cluster configured by config, errors in the configuration are reported
+// immediately.
+func NewDestination(config Config) (lib.Destination, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return lib.DestinationFunc(func(group string, stream string) (lib.Writer, error) {
+		return newWriter(config, group, stream), nil
+	}), nil
+}
+
+func newWriter(config Config, group string, stream string) *writer {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	index, _ := ParseIndex(config.Index)
+
+	return &writer{
+		config: config,
+		index:  index,
+		group:  group,
+		stream: stream,
+		client: &http.Client{Timeout: time.Duration(config.Timeout)},
+	}
+}
+
+type writer struct {
+	config Config
+	index  Index
+	group  string
+	stream string
+	client *http.Client
+}
+
+// document is the representation of messages in elasticsearch.
+type document struct {
+	Timestamp time.Time         `json:"@timestamp"`
+	Group     string            `json:"group"`
+	Stream    string            `json:"stream"`
+	Level     ecslogs.Level     `json:"level"`
+	Info      ecslogs.EventInfo `json:"info"`
+	Data      ecslogs.EventData `json:"data"`
+	Message   string            `json:"message"`
+}
+
+type action struct {
+	Create struct {
+		Index string `json:"_index"`
+	} `json:"create"`
+}
+
+type bulkResponse struct {
+	Errors bool                    `json:"errors"`
+	Items  []map[string]bulkResult `json:"items"`
+}
+
+type bulkResult struct {
+	Status int `json:"status"`
+	Error  struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+func (w *writer) Close() error {
+	return nil
+}
+
+func (w *writer) WriteMessage(msg lib.Message) error {
+	return w.WriteMessageBatch(lib.MessageBatch{msg})
+}
+
+func (w *writer) WriteMessageBatch(batch lib.MessageBatch) (err error) {
+	var body []byte
+	var res bulkResponse
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if body, err = w.encode(batch); err != nil {
+		return lib.PermanentError(err)
+	}
+
+	if err = w.post(body, &res); err != nil {
+		return
+	}
+
+	if !res.Errors {
+		return
+	}
+
+	if len(res.Items) != len(batch) {
+		return fmt.Errorf("elasticsearch returned %d results for %d documents", len(res.Items), len(batch))
+	}
+
+	var failed lib.MessageBatch
+	var rejected int
+	var reason string
+
+	for i, item := range res.Items {
+		for _, r := range item {
+			if r.Status < 300 {
+				continue
+			}
+
+			if len(reason) == 0 {
+				reason = fmt.Sprintf("%s: %s", r.Error.Type, r.Error.Reason)
+			}
+
+			// Documents rejected because the cluster is overloaded can be
+			// written again later, others (mapping errors for example) would
+			// be rejected again.
+			if r.Status == http.StatusTooManyRequests || r.Status >= 500 {
+				failed = append(failed, batch[i])
+			} else {
+				rejected++
+			}
+		}
+	}
+
+	err = fmt.Errorf("elasticsearch rejected %d and failed to index %d of %d documents (%s)", rejected, len(failed), len(batch), reason)
+
+	if len(failed) == 0 {
+		return lib.PermanentError(err)
+	}
+
+	return lib.PartialError(err, failed)
+}
+
+func (w *writer) encode(batch lib.MessageBatch) ([]byte, error) {
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var z *gzip.Writer
+
+	if w.config.Gzip {
+		z = gzip.NewWriter(&buf)
+		out = z
+	}
+
+	enc := json.NewEncoder(out)
+
+	for _, msg := range batch {
+		var a action
+		a.Create.Index = w.index.Name(msg.Group, msg.Stream, msg.Event.Time)
+
+		if err := enc.Encode(a); err != nil {
+			return nil, err
+		}
+
+		if err := enc.Encode(document{
+			Timestamp: msg.Event.Time,
+			Group:     msg.Group,
+			Stream:    msg.Stream,
+			Level:     msg.Event.Level,
+			Info:      msg.Event.Info,
+			Data:      msg.Event.Data,
+			Message:   msg.Event.Message,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if z != nil {
+		if err := z.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (w *writer) post(body []byte, res *bulkResponse) (err error) {
+	var req *http.Request
+	var r *http.Response
+
+	if req, err = http.NewRequest("POST", strings.TrimRight(w.config.URL, "/")+"/_bulk", bytes.NewReader(body)); err != nil {
+		return lib.PermanentError(err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	if w.config.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	switch {
+	case len(w.config.APIKey) != 0:
+		req.Header.Set("Authorization", "ApiKey "+w.config.APIKey)
+	case len(w.config.Username) != 0:
+		req.SetBasicAuth(w.config.Username, w.config.Password)
+	}
+
+	if r, err = w.client.Do(req); err != nil {
+		return
+	}
+
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+		err = fmt.Errorf("elasticsearch bulk request failed with status %d: %s", r.StatusCode, bytes.TrimSpace(b))
+
+		// Authentication errors or invalid requests won't succeed if they're
+		// retried.
+		if r.StatusCode != http.StatusTooManyRequests && r.StatusCode < 500 {
+			err = lib.PermanentError(err)
+		}
+
+		return
+	}
+
+	if err = json.NewDecoder(r.Body).Decode(res); err != nil {
+		err = fmt.Errorf("invalid elasticsearch bulk response: %s", err)
+	}
+
+	return
+}
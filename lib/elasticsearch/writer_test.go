@@ -0,0 +1,239 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func TestIndexName(t *testing.T) {
+	date := time.Date(2024, 3, 7, 23, 0, 0, 0, time.FixedZone("", -3600))
+
+	tests := []struct {
+		template string
+		group    string
+		stream   string
+		name     string
+	}{
+		{"", "web", "1", "logs-web-2024.03.08"},
+		{"{group}-{stream}", "Web", "a b", "web-a_b"},
+		{"ecs-logs-{group}", "/ecs/web", "1", "ecs-logs-ecs_web"},
+		{"logs-{date:2006.01}", "web", "1", "logs-2024.03"},
+		{"app1-v2-{group}-{date:2006-01-02}", "web", "1", "app1-v2-web-2024-03-08"},
+	}
+
+	for _, test := range tests {
+		index, err := ParseIndex(test.template)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.template, err)
+			continue
+		}
+
+		if name := index.Name(test.group, test.stream, date); name != test.name {
+			t.Errorf("%s: invalid index name: %s != %s", test.template, test.name, name)
+		}
+	}
+
+	for _, template := range []string{"logs-{group", "logs-{host}", "logs-{date:}"} {
+		if _, err := ParseIndex(template); err == nil {
+			t.Errorf("%s: expected an error", template)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{URL: "https://localhost:9200", APIKey: "key"}, true},
+		{Config{URL: "tcp://localhost:9200"}, false},
+		{Config{URL: "http://localhost:9200", Username: "a", APIKey: "key"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.config.validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: unexpected validation result: %v", test.config, err)
+		}
+	}
+}
+
+// bulkServer returns a test server which responds to bulk requests with the
+// item statuses returned by status, and records the documents it receives.
+func bulkServer(t *testing.T, status func(doc document) int, docs *[]document, indexes *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var body io.Reader = req.Body
+
+		if req.URL.Path != "/_bulk" {
+			t.Errorf("invalid request path: %s", req.URL.Path)
+		}
+
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			z, err := gzip.NewReader(req.Body)
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			body = z
+		}
+
+		var items []string
+		var errors bool
+		scanner := bufio.NewScanner(body)
+
+		for scanner.Scan() {
+			var a action
+			var d document
+
+			if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+				t.Error(err)
+			}
+
+			if !scanner.Scan() {
+				t.Error("missing document after the action")
+				break
+			}
+
+			if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+				t.Error(err)
+			}
+
+			*docs = append(*docs, d)
+			*indexes = append(*indexes, a.Create.Index)
+
+			if s := status(d); s < 300 {
+				items = append(items, fmt.Sprintf(`{"create":{"status":%d}}`, s))
+			} else {
+				errors = true
+				items = append(items, fmt.Sprintf(`{"create":{"status":%d,"error":{"type":"error","reason":"failed"}}}`, s))
+			}
+		}
+
+		fmt.Fprintf(res, `{"took":1,"errors":%t,"items":[%s]}`, errors, strings.Join(items, ","))
+	}))
+}
+
+// testBatch returns messages of the Web group, the index names are built from
+// the lowercased group and the date of the events.
+func testBatch(messages ...string) (batch lib.MessageBatch) {
+	for _, m := range messages {
+		batch = append(batch, lib.Message{
+			Group: "Web",
+			Event: ecslogs.Event{Level: ecslogs.INFO, Time: time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC), Message: m},
+		})
+	}
+	return
+}
+
+func TestWriteMessageBatch(t *testing.T) {
+	var docs []document
+	var indexes []string
+	var auth string
+
+	server := bulkServer(t, func(doc document) int { return 201 }, &docs, &indexes)
+	defer server.Close()
+
+	server.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			auth = req.Header.Get("Authorization")
+			h.ServeHTTP(res, req)
+		})
+	}(server.Config.Handler)
+
+	w := newWriter(Config{URL: server.URL, APIKey: "secret", Gzip: true}, "Web", "1")
+	defer w.Close()
+
+	if err := w.WriteMessageBatch(testBatch("1", "2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "ApiKey secret" {
+		t.Errorf("invalid authorization header: %q", auth)
+	}
+
+	if len(docs) != 2 || docs[0].Message != "1" || docs[1].Message != "2" || docs[0].Group != "Web" {
+		t.Errorf("invalid documents: %+v", docs)
+	}
+
+	if len(indexes) != 2 || indexes[0] != "logs-web-2024.03.07" {
+		t.Errorf("invalid indexes: %v", indexes)
+	}
+}
+
+func TestWriteMessageBatchItemErrors(t *testing.T) {
+	var docs []document
+	var indexes []string
+
+	statuses := map[string]int{"1": 201, "2": 429, "3": 400, "4": 503}
+
+	server := bulkServer(t, func(doc document) int { return statuses[doc.Message] }, &docs, &indexes)
+	defer server.Close()
+
+	w := newWriter(Config{URL: server.URL}, "Web", "1")
+	defer w.Close()
+
+	err := w.WriteMessageBatch(testBatch("1", "2", "3", "4"))
+
+	if err == nil || lib.IsPermanentError(err) {
+		t.Fatalf("expected a temporary error: %v", err)
+	}
+
+	failed, ok := lib.FailedMessages(err)
+
+	if !ok || len(failed) != 2 || failed[0].Event.Message != "2" || failed[1].Event.Message != "4" {
+		t.Errorf("only the documents that can be retried should have failed: %v", failed)
+	}
+
+	err = w.WriteMessageBatch(testBatch("1", "3"))
+
+	if !lib.IsPermanentError(err) {
+		t.Errorf("rejected documents should return a permanent error: %v", err)
+	}
+}
+
+func TestWriteMessageBatchRequestErrors(t *testing.T) {
+	// Bulk requests that are throttled or fail on the server are retried,
+	// other failures like invalid credentials are not.
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusUnauthorized, true},
+		{http.StatusTooManyRequests, false},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				t.Error("missing basic authentication")
+			}
+			res.WriteHeader(test.status)
+		}))
+
+		w := newWriter(Config{URL: server.URL, Username: "user", Password: "pass"}, "Web", "1")
+		err := w.WriteMessageBatch(testBatch("1"))
+		server.Close()
+
+		if err == nil {
+			t.Errorf("%d: expected an error", test.status)
+			continue
+		}
+
+		if lib.IsPermanentError(err) != test.permanent {
+			t.Errorf("%d: invalid error kind: %v", test.status, err)
+		}
+	}
+}
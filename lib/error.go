@@ -1,6 +1,9 @@
 package lib
 
-import "strings"
+import (
+	"errors"
+	"strings"
+)
 
 type ErrorList []error
 
@@ -25,3 +28,65 @@ func (err ErrorList) Error() string {
 
 	return strings.Join(s, "\n")
 }
+
+// PermanentError wraps err to indicate that the operation which produced it
+// will keep failing if it's retried.
+func PermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanentError returns true if err or one of the errors it wraps was
+// marked as permanent with PermanentError.
+func IsPermanentError(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (err permanentError) Error() string {
+	return err.err.Error()
+}
+
+func (err permanentError) Unwrap() error {
+	return err.err
+}
+
+// PartialError wraps err to indicate that only the messages in failed weren't
+// written, so retrying the operation only needs to write them again.
+func PartialError(err error, failed MessageBatch) error {
+	if err == nil {
+		return nil
+	}
+	return partialError{err, failed}
+}
+
+// FailedMessages returns the messages that weren't written if err or one of
+// the errors it wraps was created by PartialError.
+func FailedMessages(err error) (failed MessageBatch, ok bool) {
+	var p partialError
+
+	if ok = errors.As(err, &p); ok {
+		failed = p.failed
+	}
+
+	return
+}
+
+type partialError struct {
+	err    error
+	failed MessageBatch
+}
+
+func (err partialError) Error() string {
+	return err.err.Error()
+}
+
+func (err partialError) Unwrap() error {
+	return err.err
+}
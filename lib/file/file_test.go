@@ -0,0 +1,340 @@
+package file
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("/var/log/{group}/{stream}.log")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g := tmpl.Glob(); g != "/var/log/*/*.log" {
+		t.Error("invalid glob:", g)
+	}
+
+	if group, stream, ok := tmpl.Match("/var/log/billing/api.log"); !ok || group != "billing" || stream != "api" {
+		t.Errorf("invalid match: %q %q %t", group, stream, ok)
+	}
+
+	if _, _, ok := tmpl.Match("/var/log/billing/api.txt"); ok {
+		t.Error("the path should not have matched the template")
+	}
+
+	if _, err := ParseTemplate("/var/log/{whatever}.log"); err == nil {
+		t.Error("expected an error for an unknown placeholder")
+	}
+}
+
+func readLines(t *testing.T, tail *Tail) (lines []string) {
+	for {
+		line, _, err := tail.ReadLine()
+
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines = append(lines, string(line))
+	}
+}
+
+func appendFile(t *testing.T, path string, s string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecs-logs-file")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.log")
+	appendFile(t, path, "old\n")
+
+	tail, err := OpenTail(path, Position{Offset: -1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tail.Close()
+	appendFile(t, path, "A\nB")
+
+	if lines := readLines(t, tail); len(lines) != 1 || lines[0] != "A" {
+		t.Errorf("invalid lines: %q", lines)
+	}
+
+	// Rename-style rotation, the lines written to the old file after it was
+	// renamed are returned before the lines of the new one.
+	os.Rename(path, path+".1")
+	appendFile(t, path+".1", "2\nB3")
+	appendFile(t, path, "CCCC\n")
+
+	if rotated, err := tail.Follow(); err != nil || !rotated {
+		t.Fatal("the rotation wasn't detected:", err)
+	}
+
+	if lines := readLines(t, tail); len(lines) != 3 || lines[0] != "B2" || lines[1] != "B3" || lines[2] != "CCCC" {
+		t.Errorf("invalid lines: %q", lines)
+	}
+
+	// Copytruncate-style rotation, detected because the file is smaller than
+	// the offset of the tail.
+	os.Truncate(path, 0)
+	appendFile(t, path, "D\n")
+
+	if rotated, err := tail.Follow(); err != nil || !rotated {
+		t.Fatal("the truncation wasn't detected:", err)
+	}
+
+	if lines := readLines(t, tail); len(lines) != 1 || lines[0] != "D" {
+		t.Errorf("invalid lines: %q", lines)
+	}
+
+	pos := tail.Position()
+	tail.Close()
+	appendFile(t, path, "E\n")
+
+	if tail, err = OpenTail(path, pos); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := readLines(t, tail); len(lines) != 1 || lines[0] != "E" {
+		t.Errorf("invalid lines after reopening the file: %q", lines)
+	}
+}
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecs-logs-file")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.log")
+	appendFile(t, path, "A\n")
+
+	s, err := LoadState(filepath.Join(dir, "state.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Rotate(path, Position{Inode: 1, Offset: 0})
+	s.commit(path, Position{Inode: 1, Offset: 10})
+
+	// The file was truncated, the positions of the lines read before aren't
+	// saved anymore.
+	s.Rotate(path, Position{Inode: 1, Offset: 0, Generation: 1})
+	s.commit(path, Position{Inode: 1, Offset: 20})
+	s.commit(path, Position{Inode: 2, Offset: 20, Generation: 1})
+
+	if pos, _ := s.Get(path); pos != (Position{Inode: 1, Offset: 0, Generation: 1}) {
+		t.Error("invalid position:", pos)
+	}
+
+	s.commit(path, Position{Inode: 1, Offset: 5, Generation: 1})
+
+	if pos, _ := s.Get(path); pos != (Position{Inode: 1, Offset: 5, Generation: 1}) {
+		t.Error("invalid position:", pos)
+	}
+
+	if pos, err := parsePosition(Position{Inode: 1, Offset: 5, Generation: 1}.String()); err != nil || pos.Generation != 1 {
+		t.Error("invalid position:", pos, err)
+	}
+
+	// The positions of removed files are dropped and not saved again.
+	s.Remove(path)
+	s.commit(path, Position{Inode: 1, Offset: 6, Generation: 1})
+
+	if _, ok := s.Get(path); ok {
+		t.Error("the position of the removed file wasn't dropped")
+	}
+
+	s.Rotate(path, Position{Inode: 1})
+	s.Rotate(filepath.Join(dir, "b.log"), Position{Inode: 2})
+
+	if err := s.Prune(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = LoadState(filepath.Join(dir, "state.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Get(filepath.Join(dir, "b.log")); ok {
+		t.Error("the position of the file that doesn't exist wasn't pruned")
+	}
+
+	if _, ok := s.Get(path); !ok {
+		t.Error("the position of the existing file was pruned")
+	}
+}
+
+func TestStateSavedOncePerInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecs-logs-file")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	s, _ := LoadState(path)
+
+	// The first change is saved right away, the next ones wait for the end
+	// of the interval and are saved together.
+	s.Rotate("a.log", Position{Inode: 1})
+	s.Rotate("b.log", Position{Inode: 2})
+	s.commit("a.log", Position{Inode: 1, Offset: 10})
+
+	if saved, _ := LoadState(path); len(saved.positions) != 1 {
+		t.Errorf("invalid saved positions: %v", saved.positions)
+	}
+
+	if err := lib.FlushCheckpoints(); err != nil {
+		t.Fatal(err)
+	}
+
+	if saved, _ := LoadState(path); len(saved.positions) != 2 || saved.positions["a.log"].Offset != 10 {
+		t.Errorf("invalid saved positions: %v", saved.positions)
+	}
+}
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecs-logs-file")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "billing"), 0755)
+	path := filepath.Join(dir, "billing", "api.log")
+	state := filepath.Join(dir, "state.json")
+	appendFile(t, path, "skipped\n")
+
+	config := Config{
+		Paths:        []string{filepath.Join(dir, "{group}", "{stream}.log")},
+		StateFile:    state,
+		PollInterval: lib.Duration(10 * time.Millisecond),
+	}
+
+	r, err := OpenReader(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendFile(t, path, "hello\n{\"level\":\"ERROR\",\"message\":\"world\"}\n")
+
+	var batch lib.MessageBatch
+
+	for i := 0; i != 2; i++ {
+		msg, err := r.ReadMessage()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		batch = append(batch, msg)
+	}
+
+	r.Close()
+
+	if batch[0].Group != "billing" || batch[0].Stream != "api" || batch[0].Event.Message != "hello" {
+		t.Errorf("invalid message: %#v", batch[0])
+	}
+
+	if batch[1].Event.Message != "world" || batch[1].Event.Level.String() != "ERROR" {
+		t.Errorf("invalid message: %#v", batch[1])
+	}
+
+	if err := lib.AckBatch(batch[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state is saved once per interval, or when the program exits.
+	if err := lib.FlushCheckpoints(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader resumes after the last acknowledged line.
+	if r, err = OpenReader(config); err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	if msg, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	} else if msg.Event.Message != "world" {
+		t.Errorf("invalid message: %#v", msg)
+	}
+}
+
+func TestReaderFairness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecs-logs-file")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	appendFile(t, filepath.Join(dir, "a.log"), strings.Repeat("A\n", 3*maxPollLines))
+	appendFile(t, filepath.Join(dir, "b.log"), "B\n")
+
+	r, err := OpenReader(Config{
+		Paths:   []string{filepath.Join(dir, "*.log")},
+		StartAt: "beginning",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	// The lines of the busy file are read in chunks, the other file is read
+	// in between.
+	for i := 0; i <= maxPollLines; i++ {
+		msg, err := r.ReadMessage()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Event.Message == "B" {
+			return
+		}
+	}
+
+	t.Error("the file was starved by the busy file")
+}
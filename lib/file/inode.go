@@ -0,0 +1,15 @@
+// +build !windows
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileInode(s os.FileInfo) uint64 {
+	if st, ok := s.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,281 @@
+package file
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// The maximum number of lines read from a file in one poll, so files that are
+// written to faster than they are read don't starve the others.
+const maxPollLines = 1000
+
+// A LineDecoder turns the lines of a file into messages, it returns false for
+// lines that don't produce a message.
+type LineDecoder func(line []byte) (msg lib.Message, ok bool)
+
+// PollConfig represents the options of readers that poll a set of files for
+// new lines, like the file and docker sources.
+type PollConfig struct {
+	// The path to the file where the positions of the last lines that were
+	// delivered are saved.
+	StateFile string
+
+	// Where to start reading the files that exist when the reader is opened
+	// and have no saved position, either "end" (the default) or "beginning".
+	StartAt string
+
+	// How often files are checked for new lines, rotations and new files.
+	PollInterval lib.Duration
+
+	// Scan returns the paths of the files to read, it's called on each poll
+	// to discover new files.
+	Scan func() []string
+
+	// Open is called for each new file returned by Scan, it returns the
+	// decoder of the lines of the file.
+	Open func(path string) (LineDecoder, error)
+}
+
+// Poll returns a reader which reads the lines of the files returned by
+// config.Scan, following their rotations, until it's closed. Files are read
+// from their saved position, or from the beginning if they were created after
+// the reader was opened.
+func Poll(config PollConfig) (lib.Reader, error) {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+
+	p := &poller{
+		config:   config,
+		tails:    make(map[string]*tail),
+		messages: make(chan lib.Message),
+		errors:   make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+
+	if len(config.StateFile) != 0 {
+		var err error
+
+		if p.state, err = LoadState(config.StateFile); err != nil {
+			return nil, err
+		}
+
+		if err = p.state.Prune(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Files that exist when the reader is opened are read from the position
+	// configured by StartAt.
+	p.scan(config.StartAt != "beginning")
+
+	p.join.Add(1)
+	go p.run()
+	return p, nil
+}
+
+type poller struct {
+	config   PollConfig
+	state    *State
+	tails    map[string]*tail
+	messages chan lib.Message
+	errors   chan error
+	done     chan struct{}
+	once     sync.Once
+	join     sync.WaitGroup
+}
+
+type tail struct {
+	*Tail
+	decode     LineDecoder
+	checkpoint *lib.Checkpoint
+}
+
+func (p *poller) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+func (p *poller) ReadMessage() (msg lib.Message, err error) {
+	select {
+	case msg = <-p.messages:
+	case err = <-p.errors:
+	case <-p.done:
+		p.join.Wait()
+		err = io.EOF
+	}
+	return
+}
+
+func (p *poller) run() {
+	defer p.join.Done()
+	defer p.closeTails()
+
+	ticker := time.NewTicker(time.Duration(p.config.PollInterval))
+	defer ticker.Stop()
+
+	for {
+		more, ok := p.poll()
+
+		if !ok {
+			return
+		}
+
+		// Files that still have lines to read are polled again right away.
+		if !more {
+			select {
+			case <-ticker.C:
+			case <-p.done:
+				return
+			}
+		}
+
+		p.scan(false)
+	}
+}
+
+// scan opens the files returned by the Scan function that aren't being read
+// yet.
+func (p *poller) scan(atEnd bool) {
+	for _, path := range p.config.Scan() {
+		if p.tails[path] == nil {
+			p.open(path, atEnd)
+		}
+	}
+}
+
+func (p *poller) open(path string, atEnd bool) {
+	var pos Position
+	var saved bool
+
+	decode, err := p.config.Open(path)
+
+	if err == nil {
+		if p.state != nil {
+			pos, saved = p.state.Get(path)
+		}
+
+		if !saved && atEnd {
+			pos.Offset = -1
+		}
+
+		t := &tail{decode: decode}
+
+		if t.Tail, err = OpenTail(path, pos); err == nil {
+			if p.state != nil {
+				t.checkpoint = p.state.Checkpoint(path)
+				p.rotate(t)
+			}
+
+			p.tails[path] = t
+			return
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"path":  path,
+		"error": err,
+	}).Error("failed to open file")
+}
+
+// poll reads the new lines of all files, up to maxPollLines per file. It
+// returns true if some files have more lines to read, and false if the reader
+// was closed.
+func (p *poller) poll() (more bool, ok bool) {
+	for _, t := range p.tails {
+		for n := 0; ; n++ {
+			if n == maxPollLines {
+				more = true
+				break
+			}
+
+			line, pos, err := t.ReadLine()
+
+			if err == io.EOF {
+				rotated, err := t.Follow()
+
+				if err != nil {
+					log.WithFields(log.Fields{
+						"path":  t.Path(),
+						"error": err,
+					}).Error("failed to follow file")
+					break
+				}
+
+				if !rotated {
+					if _, err := os.Stat(t.Path()); os.IsNotExist(err) {
+						// The file was removed and all its lines were read.
+						t.Close()
+						delete(p.tails, t.Path())
+
+						if p.state != nil {
+							p.remove(t)
+						}
+					}
+					break
+				}
+
+				if p.state != nil {
+					p.rotate(t)
+				}
+
+				continue
+			}
+
+			if err != nil {
+				select {
+				case p.errors <- err:
+				default:
+				}
+				return false, false
+			}
+
+			msg, ok := t.decode(line)
+
+			if !ok {
+				continue
+			}
+
+			if t.checkpoint != nil {
+				msg.Position = t.checkpoint.Track(pos.String())
+			}
+
+			select {
+			case p.messages <- msg:
+			case <-p.done:
+				return false, false
+			}
+		}
+	}
+
+	return more, true
+}
+
+func (p *poller) rotate(t *tail) {
+	if err := p.state.Rotate(t.Path(), t.Position()); err != nil {
+		log.WithFields(log.Fields{
+			"path":  t.Path(),
+			"error": err,
+		}).Error("failed to save the file position")
+	}
+}
+
+func (p *poller) remove(t *tail) {
+	if err := p.state.Remove(t.Path()); err != nil {
+		log.WithFields(log.Fields{
+			"path":  t.Path(),
+			"error": err,
+		}).Error("failed to remove the file position")
+	}
+}
+
+func (p *poller) closeTails() {
+	for _, t := range p.tails {
+		t.Close()
+	}
+}
@@ -0,0 +1,147 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+const DefaultPollInterval = lib.Duration(time.Second)
+
+// Config represents the options of file sources.
+type Config struct {
+	// Path templates of the files to read, see Template.
+	Paths []string `json:"paths"`
+
+	// The group and stream of messages when the path templates don't have the
+	// {group} or {stream} placeholders, they default to the name of the
+	// directory and the name of the file.
+	Group  string `json:"group"`
+	Stream string `json:"stream"`
+
+	// The path to the file where the positions of the last lines that were
+	// delivered are saved.
+	StateFile string `json:"state_file"`
+
+	// Where to start reading the files that exist when the source is opened
+	// and have no saved position, either "end" (the default) or "beginning".
+	// Files created later are always read from the beginning.
+	StartAt string `json:"start_at"`
+
+	// How often files are checked for new lines, rotations and new files.
+	PollInterval lib.Duration `json:"poll_interval"`
+}
+
+func (c Config) validate() error {
+	if len(c.Paths) == 0 {
+		return errors.New("no paths to read from")
+	}
+
+	switch c.StartAt {
+	case "", "end", "beginning":
+	default:
+		return errors.New("start_at must be one of 'end' or 'beginning'")
+	}
+
+	for _, p := range c.Paths {
+		if _, err := ParseTemplate(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func NewReader() (lib.Reader, error) {
+	var paths []string
+
+	for _, p := range strings.Split(os.Getenv("FILE_PATHS"), ",") {
+		if p = strings.TrimSpace(p); len(p) != 0 {
+			paths = append(paths, p)
+		}
+	}
+
+	return OpenReader(Config{
+		Paths:     paths,
+		Group:     os.Getenv("FILE_GROUP"),
+		Stream:    os.Getenv("FILE_STREAM"),
+		StateFile: os.Getenv("FILE_STATE_FILE"),
+		StartAt:   os.Getenv("FILE_START_AT"),
+	})
+}
+
+func OpenReader(config Config) (lib.Reader, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	var templates []Template
+
+	for _, p := range config.Paths {
+		t, _ := ParseTemplate(p)
+		templates = append(templates, t)
+	}
+
+	return Poll(PollConfig{
+		StateFile:    config.StateFile,
+		StartAt:      config.StartAt,
+		PollInterval: config.PollInterval,
+
+		Scan: func() (paths []string) {
+			for _, t := range templates {
+				matches, _ := filepath.Glob(t.Glob())
+
+				for _, path := range matches {
+					if _, _, ok := t.Match(path); ok {
+						paths = append(paths, path)
+					}
+				}
+			}
+			return
+		},
+
+		Open: func(path string) (LineDecoder, error) {
+			return decoder(config, templates, path), nil
+		},
+	})
+}
+
+// decoder returns the decoder of the lines of the file at path, which sets the
+// group and stream extracted from the path by the first template it matches.
+func decoder(config Config, templates []Template, path string) LineDecoder {
+	var group, stream string
+
+	for _, t := range templates {
+		var ok bool
+
+		if group, stream, ok = t.Match(path); ok {
+			break
+		}
+	}
+
+	if len(group) == 0 {
+		if group = config.Group; len(group) == 0 {
+			group = filepath.Base(filepath.Dir(path))
+		}
+	}
+
+	if len(stream) == 0 {
+		if stream = config.Stream; len(stream) == 0 {
+			stream = filepath.Base(path)
+		}
+	}
+
+	return func(line []byte) (msg lib.Message, ok bool) {
+		msg = lib.Message{
+			Group:  group,
+			Stream: stream,
+		}
+		msg.Event, msg.PlainText = lib.ParseText(string(line))
+		ok = true
+		return
+	}
+}
@@ -0,0 +1,183 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// State saves the positions of the lines that were delivered for each file, so
+// tails can resume from there after a restart. The positions of all files are
+// saved together, at most once per lib.CheckpointInterval.
+//
+// States are safe to use concurrently from multiple goroutines.
+type State struct {
+	mutex     sync.Mutex
+	path      string
+	positions map[string]Position
+
+	// Schedules the saves of the state, changes made in between are written
+	// by the same save.
+	saver *lib.Checkpoint
+}
+
+// LoadState reads the state saved at path, the state is empty if the file
+// doesn't exist.
+func LoadState(path string) (s *State, err error) {
+	var b []byte
+
+	s = &State{
+		path:      path,
+		positions: make(map[string]Position),
+	}
+
+	s.saver = lib.NewCheckpoint(func(string) error {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.save()
+	})
+
+	if b, err = ioutil.ReadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	if err = json.Unmarshal(b, &s.positions); err != nil {
+		s = nil
+	}
+
+	return
+}
+
+// Get returns the saved position of the file at path.
+func (s *State) Get(path string) (pos Position, ok bool) {
+	s.mutex.Lock()
+	pos, ok = s.positions[path]
+	s.mutex.Unlock()
+	return
+}
+
+// Checkpoint returns a checkpoint that saves the positions of the lines of the
+// file at path when they are acknowledged.
+func (s *State) Checkpoint(path string) *lib.Checkpoint {
+	// The positions are only recorded in memory, saving the state is
+	// scheduled by the state itself.
+	return lib.NewCheckpointInterval(func(value string) error {
+		pos, err := parsePosition(value)
+
+		if err != nil {
+			return err
+		}
+
+		return s.commit(path, pos)
+	}, 0)
+}
+
+// Rotate records that the file at path was replaced by a new file or truncated,
+// the lines of the previous file or generation that are acknowledged later
+// don't change its position.
+func (s *State) Rotate(path string, pos Position) error {
+	s.mutex.Lock()
+	s.positions[path] = pos
+	s.mutex.Unlock()
+	return s.saver.Commit("")
+}
+
+// Remove deletes the position of the file at path, it must be called when the
+// file was removed.
+func (s *State) Remove(path string) error {
+	s.mutex.Lock()
+	_, ok := s.positions[path]
+	delete(s.positions, path)
+	s.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return s.saver.Commit("")
+}
+
+// Prune removes the positions of the files that don't exist anymore, the state
+// is saved right away.
+func (s *State) Prune() error {
+	s.mutex.Lock()
+	n := len(s.positions)
+
+	for path := range s.positions {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(s.positions, path)
+		}
+	}
+
+	pruned := len(s.positions) != n
+	s.mutex.Unlock()
+
+	if !pruned {
+		return nil
+	}
+
+	if err := s.saver.Commit(""); err != nil {
+		return err
+	}
+
+	return s.saver.Flush()
+}
+
+func (s *State) commit(path string, pos Position) error {
+	s.mutex.Lock()
+	last, ok := s.positions[path]
+
+	// Positions of files that were removed, rotated or truncated since the
+	// lines were read are ignored.
+	if !ok || last.Inode != pos.Inode || last.Generation != pos.Generation {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	s.positions[path] = pos
+	s.mutex.Unlock()
+	return s.saver.Commit("")
+}
+
+// save writes the state to a temporary file which is then renamed, this way a
+// crash never leaves a partially written state file behind. It must be called
+// while holding the lock.
+func (s *State) save() (err error) {
+	var b []byte
+	var f *os.File
+
+	if b, err = json.Marshal(s.positions); err != nil {
+		return
+	}
+
+	if f, err = ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp"); err != nil {
+		return
+	}
+
+	tmp := f.Name()
+
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+
+	if e := f.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return
+}
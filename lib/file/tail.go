@@ -0,0 +1,219 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Position identifies a byte offset in a specific file, the inode tells apart
+// the files that were rotated under the same path and the generation is
+// incremented on each rotation, which tells apart the contents of a file that
+// was truncated in place.
+type Position struct {
+	Inode      uint64 `json:"inode"`
+	Offset     int64  `json:"offset"`
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d:%d", p.Inode, p.Offset, p.Generation)
+}
+
+func parsePosition(s string) (p Position, err error) {
+	parts := strings.Split(s, ":")
+
+	if len(parts) != 2 && len(parts) != 3 {
+		err = fmt.Errorf("invalid file position: %q", s)
+		return
+	}
+
+	if p.Inode, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return
+	}
+
+	if p.Offset, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return
+	}
+
+	if len(parts) == 3 {
+		p.Generation, err = strconv.ParseUint(parts[2], 10, 64)
+	}
+
+	return
+}
+
+// A Tail reads the lines appended to a file, following the rotations that
+// rename the file or truncate it in place.
+//
+// Tails are not safe to use concurrently from multiple goroutines.
+type Tail struct {
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	pos     Position
+	partial []byte
+
+	// The file that was renamed, its remaining lines are read before the
+	// lines of the new file.
+	prev *Tail
+}
+
+// OpenTail opens the file at path and positions it at pos if pos refers to the
+// same file, otherwise the tail starts at the beginning of the file. A negative
+// offset positions the tail at the end of the file.
+func OpenTail(path string, pos Position) (t *Tail, err error) {
+	t = &Tail{path: path}
+
+	if err = t.open(pos); err != nil {
+		t = nil
+	}
+
+	return
+}
+
+func (t *Tail) open(pos Position) (err error) {
+	var f *os.File
+	var s os.FileInfo
+
+	if f, err = os.Open(t.path); err != nil {
+		return
+	}
+
+	if s, err = f.Stat(); err != nil {
+		f.Close()
+		return
+	}
+
+	offset := int64(0)
+	inode := fileInode(s)
+
+	switch {
+	case pos.Offset < 0:
+		offset = s.Size()
+	case pos.Inode == inode && pos.Offset <= s.Size():
+		offset = pos.Offset
+	}
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return
+	}
+
+	t.file = f
+	t.reader = bufio.NewReader(f)
+	t.pos = Position{Inode: inode, Offset: offset, Generation: pos.Generation}
+	t.partial = nil
+	return
+}
+
+// Path returns the path of the file that t reads from.
+func (t *Tail) Path() string {
+	return t.path
+}
+
+// Position returns the position of the next line that ReadLine returns.
+func (t *Tail) Position() Position {
+	pos := t.pos
+	pos.Offset -= int64(len(t.partial))
+	return pos
+}
+
+// Close closes the file.
+func (t *Tail) Close() error {
+	if t.prev != nil {
+		t.prev.Close()
+		t.prev = nil
+	}
+	return t.file.Close()
+}
+
+// ReadLine returns the next complete line of the file, without the line
+// terminator, and the position of the end of the line. It returns io.EOF when
+// no complete lines are available.
+func (t *Tail) ReadLine() (line []byte, pos Position, err error) {
+	if p := t.prev; p != nil {
+		if line, pos, err = p.ReadLine(); err != io.EOF {
+			return
+		}
+
+		// Nothing is appended to the renamed file anymore once it was read to
+		// its end, the partial line left there is complete.
+		if len(p.partial) != 0 {
+			line, pos, err = bytes.TrimRight(p.partial, "\r\n"), p.pos, nil
+			p.partial = nil
+			return
+		}
+
+		p.Close()
+		t.prev = nil
+	}
+
+	var b []byte
+	b, err = t.reader.ReadBytes('\n')
+	t.pos.Offset += int64(len(b))
+
+	if err != nil {
+		t.partial = append(t.partial, b...)
+		return
+	}
+
+	if len(t.partial) != 0 {
+		b = append(t.partial, b...)
+		t.partial = nil
+	}
+
+	line = bytes.TrimRight(b, "\r\n")
+	pos = t.pos
+	return
+}
+
+// Follow checks whether the file was rotated since it was opened, in which case
+// the new file at path is opened and true is returned. It should be called when
+// ReadLine returned io.EOF.
+//
+// The lines written to a renamed file after ReadLine returned io.EOF, and the
+// partial line left at its end, are returned by the next calls to ReadLine,
+// while the partial line of a truncated file is lost.
+func (t *Tail) Follow() (rotated bool, err error) {
+	var s os.FileInfo
+
+	if s, err = os.Stat(t.path); err != nil {
+		if os.IsNotExist(err) {
+			// The file was removed and not created again (yet), keep waiting
+			// for new data in case it's still being written to.
+			err = nil
+		}
+		return
+	}
+
+	prev := *t
+
+	switch {
+	case fileInode(s) != t.pos.Inode:
+		// The program writing to the file may not have reopened it yet, the
+		// old file is kept open and read to the end so no lines are lost.
+		if err = t.open(Position{Generation: t.pos.Generation + 1}); err != nil {
+			return
+		}
+
+		t.prev = &prev
+
+	case s.Size() < t.pos.Offset:
+		if err = t.open(Position{Generation: t.pos.Generation + 1}); err != nil {
+			return
+		}
+
+		prev.file.Close()
+
+	default:
+		return
+	}
+
+	rotated = true
+	return
+}
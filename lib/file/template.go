@@ -0,0 +1,85 @@
+package file
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A Template is a glob pattern where the {group} and {stream} placeholders
+// match a single path element each, like /var/log/{group}/{stream}.log.
+type Template struct {
+	glob   string
+	re     *regexp.Regexp
+	group  int
+	stream int
+}
+
+var placeholder = regexp.MustCompile(`\{[^}]*\}`)
+
+func ParseTemplate(s string) (t Template, err error) {
+	var expr []string
+	var index = 1
+
+	for len(s) != 0 {
+		loc := placeholder.FindStringIndex(s)
+
+		if loc == nil {
+			t.glob += s
+			expr = append(expr, globToRegexp(s))
+			break
+		}
+
+		t.glob += s[:loc[0]] + "*"
+		expr = append(expr, globToRegexp(s[:loc[0]]), `([^/]*)`)
+
+		switch name := s[loc[0]+1 : loc[1]-1]; name {
+		case "group":
+			t.group = index
+		case "stream":
+			t.stream = index
+		default:
+			err = fmt.Errorf("invalid path template, unknown placeholder {%s}", name)
+			return
+		}
+
+		index++
+		s = s[loc[1]:]
+	}
+
+	t.re, err = regexp.Compile("^" + strings.Join(expr, "") + "$")
+	return
+}
+
+// Glob returns the glob pattern matching the paths of the template.
+func (t Template) Glob() string {
+	return t.glob
+}
+
+// Match returns the values of the {group} and {stream} placeholders in path,
+// which are empty if the template doesn't have them.
+func (t Template) Match(path string) (group string, stream string, ok bool) {
+	m := t.re.FindStringSubmatch(path)
+
+	if m == nil {
+		return
+	}
+
+	if t.group != 0 {
+		group = m[t.group]
+	}
+
+	if t.stream != 0 {
+		stream = m[t.stream]
+	}
+
+	ok = true
+	return
+}
+
+func globToRegexp(glob string) string {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.Replace(expr, `\*`, `[^/]*`, -1)
+	expr = strings.Replace(expr, `\?`, `[^/]`, -1)
+	return expr
+}
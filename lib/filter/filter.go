@@ -0,0 +1,246 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+)
+
+// A Rule selects messages with its match conditions and decides which of them
+// are kept. Exactly one of the actions must be set on each rule.
+type Rule struct {
+	match.Config
+
+	// The name of the rule in the statistics, defaults to its position in the
+	// list of rules.
+	Name string `json:"name"`
+
+	// Drop discards all matching messages.
+	Drop bool `json:"drop"`
+
+	// DropBelow discards the matching messages that are less severe than the
+	// level, messages without a level are kept.
+	DropBelow string `json:"drop_below"`
+
+	// SampleEvery keeps one in every N matching messages of each stream.
+	SampleEvery int `json:"sample_every"`
+
+	// SampleRate keeps matching messages with a probability between 0 and 1.
+	SampleRate float64 `json:"sample_rate"`
+
+	// RateLimit is the maximum number of matching messages per second kept
+	// for each stream, Burst is how many messages may be kept at once and
+	// defaults to the rate limit.
+	RateLimit float64 `json:"rate_limit"`
+	Burst     int     `json:"burst"`
+}
+
+// A Filter applies a list of rules to messages before they are buffered, the
+// rules are applied in order and a message is discarded by the first rule that
+// doesn't keep it.
+//
+// Filters are safe to use concurrently from multiple goroutines.
+type Filter struct {
+	mutex   sync.Mutex
+	rules   []rule
+	streams map[streamKey]*streamState
+	rand    *rand.Rand
+}
+
+type rule struct {
+	Rule
+	matcher   *match.Matcher
+	dropBelow ecslogs.Level
+	filtered  int64
+}
+
+type streamKey struct {
+	rule   int
+	group  string
+	stream string
+}
+
+type streamState struct {
+	count  int
+	tokens float64
+	time   time.Time
+}
+
+// New compiles rules into a filter.
+func New(rules []Rule) (*Filter, error) {
+	f := &Filter{
+		rules:   make([]rule, 0, len(rules)),
+		streams: make(map[streamKey]*streamState),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for i, r := range rules {
+		if len(r.Name) == 0 {
+			r.Name = fmt.Sprintf("#%d", i+1)
+		}
+
+		c, err := compile(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: %s", r.Name, err)
+		}
+
+		f.rules = append(f.rules, c)
+	}
+
+	return f, nil
+}
+
+func compile(r Rule) (c rule, err error) {
+	c.Rule = r
+	actions := 0
+
+	if c.matcher, err = match.New(r.Config); err != nil {
+		return
+	}
+
+	if r.Drop {
+		actions++
+	}
+
+	if len(r.DropBelow) != 0 {
+		if c.dropBelow, err = ecslogs.ParseLevel(r.DropBelow); err != nil {
+			return
+		}
+		actions++
+	}
+
+	if r.SampleEvery != 0 {
+		if r.SampleEvery < 0 {
+			err = errors.New("sample_every must be a positive number")
+			return
+		}
+		actions++
+	}
+
+	if r.SampleRate != 0 {
+		if r.SampleRate < 0 || r.SampleRate > 1 {
+			err = errors.New("sample_rate must be between 0 and 1")
+			return
+		}
+		actions++
+	}
+
+	if r.RateLimit != 0 {
+		if r.RateLimit < 0 || r.Burst < 0 {
+			err = errors.New("rate_limit and burst must be positive numbers")
+			return
+		}
+		if c.Burst == 0 {
+			c.Burst = int(math.Max(1, math.Ceil(r.RateLimit)))
+		}
+		actions++
+	}
+
+	switch actions {
+	case 0:
+		err = errors.New("no action, one of drop, drop_below, sample_every, sample_rate or rate_limit must be set")
+	case 1:
+	default:
+		err = errors.New("too many actions, only one of drop, drop_below, sample_every, sample_rate or rate_limit can be set")
+	}
+
+	return
+}
+
+// Keep returns true if msg passes all the rules of the filter.
+func (f *Filter) Keep(msg lib.Message, now time.Time) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i := range f.rules {
+		r := &f.rules[i]
+
+		if !r.matcher.Match(msg) {
+			continue
+		}
+
+		if !f.keep(i, r, msg, now) {
+			r.filtered++
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *Filter) keep(i int, r *rule, msg lib.Message, now time.Time) bool {
+	switch {
+	case r.Drop:
+		return false
+
+	case r.dropBelow != ecslogs.NONE:
+		return msg.Event.Level == ecslogs.NONE || msg.Event.Level <= r.dropBelow
+
+	case r.SampleRate != 0:
+		return f.rand.Float64() < r.SampleRate
+	}
+
+	s := f.state(i, msg, now)
+
+	if r.SampleEvery != 0 {
+		keep := (s.count % r.SampleEvery) == 0
+		s.count++
+		return keep
+	}
+
+	s.tokens = math.Min(float64(r.Burst), s.tokens+now.Sub(s.time).Seconds()*r.RateLimit)
+	s.time = now
+
+	if s.tokens < 1 {
+		return false
+	}
+
+	s.tokens--
+	return true
+}
+
+func (f *Filter) state(i int, msg lib.Message, now time.Time) *streamState {
+	k := streamKey{rule: i, group: msg.Group, stream: msg.Stream}
+	s := f.streams[k]
+
+	if s == nil {
+		s = &streamState{tokens: float64(f.rules[i].Burst), time: now}
+		f.streams[k] = s
+	}
+
+	return s
+}
+
+// Forget clears the sampling and rate limiting state of a stream, it must be
+// called when streams expire so the filter doesn't grow forever.
+func (f *Filter) Forget(group string, stream string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i := range f.rules {
+		delete(f.streams, streamKey{rule: i, group: group, stream: stream})
+	}
+}
+
+// Stats returns the number of messages discarded by each rule, indexed by the
+// rule names.
+func (f *Filter) Stats() map[string]int64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	stats := make(map[string]int64, len(f.rules))
+
+	for _, r := range f.rules {
+		stats[r.Name] += r.filtered
+	}
+
+	return stats
+}
@@ -0,0 +1,119 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+)
+
+func TestFilterDrop(t *testing.T) {
+	f, err := New([]Rule{
+		{Config: match.Config{Group: "billing"}, DropBelow: "INFO"},
+		{Config: match.Config{Message: "/^health check/"}, Drop: true},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		msg  lib.Message
+		keep bool
+	}{
+		{lib.Message{Group: "billing", Event: ecslogs.Event{Level: ecslogs.DEBUG}}, false},
+		{lib.Message{Group: "billing", Event: ecslogs.Event{Level: ecslogs.INFO}}, true},
+		{lib.Message{Group: "billing", Event: ecslogs.Event{Level: ecslogs.ERROR}}, true},
+		{lib.Message{Group: "billing"}, true},
+		{lib.Message{Group: "search", Event: ecslogs.Event{Level: ecslogs.DEBUG}}, true},
+		{lib.Message{Group: "search", Event: ecslogs.Event{Message: "health check OK"}}, false},
+	}
+
+	for _, test := range tests {
+		if keep := f.Keep(test.msg, now); keep != test.keep {
+			t.Errorf("%#v: expected %t but found %t", test.msg, test.keep, keep)
+		}
+	}
+
+	if stats := f.Stats(); !reflect.DeepEqual(stats, map[string]int64{"#1": 1, "#2": 1}) {
+		t.Error("invalid statistics:", stats)
+	}
+}
+
+func TestFilterSampleEvery(t *testing.T) {
+	f, err := New([]Rule{{Name: "sample", SampleEvery: 3}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	kept := map[string]int{}
+
+	for i := 0; i != 9; i++ {
+		for _, stream := range []string{"A", "B"} {
+			if f.Keep(lib.Message{Group: "G", Stream: stream}, now) {
+				kept[stream]++
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(kept, map[string]int{"A": 3, "B": 3}) {
+		t.Error("invalid number of messages kept:", kept)
+	}
+
+	if stats := f.Stats(); stats["sample"] != 12 {
+		t.Error("invalid statistics:", stats)
+	}
+}
+
+func TestFilterRateLimit(t *testing.T) {
+	f, err := New([]Rule{{RateLimit: 2}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	msg := lib.Message{Group: "G", Stream: "S"}
+	kept := 0
+
+	for i := 0; i != 10; i++ {
+		if f.Keep(msg, now) {
+			kept++
+		}
+	}
+
+	if kept != 2 {
+		t.Error("the burst should have kept 2 messages but", kept, "were kept")
+	}
+
+	if !f.Keep(msg, now.Add(500*time.Millisecond)) {
+		t.Error("a message should have been kept after the bucket was refilled")
+	}
+
+	if f.Keep(msg, now.Add(500*time.Millisecond)) {
+		t.Error("the bucket should have been empty")
+	}
+}
+
+func TestNewError(t *testing.T) {
+	tests := []Rule{
+		{},
+		{Drop: true, SampleEvery: 2},
+		{SampleRate: 2},
+		{DropBelow: "whatever"},
+		{RateLimit: -1},
+	}
+
+	for _, rule := range tests {
+		if _, err := New([]Rule{rule}); err == nil {
+			t.Errorf("%#v: expected an error", rule)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package fluentd
+
+import "github.com/segmentio/ecs-logs/lib"
+
+func init() {
+	lib.RegisterSource("fluentd", lib.SourceFunc(NewReader))
+	lib.RegisterSourceFactory("fluentd", func(opts lib.Options) (lib.Source, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		if _, _, err := c.listenAddress(); err != nil {
+			return nil, err
+		}
+
+		return lib.SourceFunc(func() (lib.Reader, error) {
+			return OpenReader(c)
+		}), nil
+	})
+}
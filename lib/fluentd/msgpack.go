@@ -0,0 +1,282 @@
+package fluentd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// Limits on the values decoded from msgpack, they protect against lengths
+	// that would make the decoder allocate unbounded amounts of memory.
+	maxBytes = 64 * 1024 * 1024
+	maxDepth = 100
+)
+
+var (
+	errTooLarge = errors.New("msgpack value is too large")
+	errTooDeep  = errors.New("msgpack value is nested too deeply")
+)
+
+// decoder reads msgpack values, strings and binaries are decoded as string,
+// integers as int64 (or uint64 when they don't fit), maps as
+// map[string]interface{}, and the EventTime and timestamp extensions as
+// time.Time. Other extensions are decoded as []byte.
+type decoder struct {
+	r io.Reader
+	b [8]byte
+}
+
+func newDecoder(r io.Reader) *decoder {
+	return &decoder{r: r}
+}
+
+func (d *decoder) decode() (interface{}, error) {
+	return d.value(0)
+}
+
+func (d *decoder) value(depth int) (v interface{}, err error) {
+	var c byte
+
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+
+	if c, err = d.byte(); err != nil {
+		return
+	}
+
+	// The input may only end before the first byte of a value.
+	defer func() { err = eof(err) }()
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return d.mapValue(int(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return d.arrayValue(int(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		return d.str(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		return d.strN(1)
+	case 0xc5, 0xda:
+		return d.strN(2)
+	case 0xc6, 0xdb:
+		return d.strN(4)
+	case 0xc7:
+		return d.extN(1)
+	case 0xc8:
+		return d.extN(2)
+	case 0xc9:
+		return d.extN(4)
+	case 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (c - 0xcc))
+		if u > math.MaxInt64 {
+			return u, err
+		}
+		return int64(u), err
+	case 0xd0:
+		u, err := d.uint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := d.uint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := d.uint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := d.uint(8)
+		return int64(u), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(n, depth)
+	}
+
+	return nil, fmt.Errorf("invalid msgpack type: 0x%02x", c)
+}
+
+func (d *decoder) byte() (byte, error) {
+	_, err := io.ReadFull(d.r, d.b[:1])
+	return d.b[0], err
+}
+
+func (d *decoder) uint(size int) (u uint64, err error) {
+	if _, err = io.ReadFull(d.r, d.b[:size]); err != nil {
+		return
+	}
+
+	for _, c := range d.b[:size] {
+		u = u<<8 | uint64(c)
+	}
+
+	return
+}
+
+func (d *decoder) length(size int) (int, error) {
+	u, err := d.uint(size)
+
+	if err == nil && u > maxBytes {
+		err = errTooLarge
+	}
+
+	return int(u), err
+}
+
+func (d *decoder) bytes(n int) (b []byte, err error) {
+	b = make([]byte, n)
+	_, err = io.ReadFull(d.r, b)
+	return
+}
+
+func (d *decoder) str(n int) (interface{}, error) {
+	b, err := d.bytes(n)
+	return string(b), err
+}
+
+func (d *decoder) strN(size int) (interface{}, error) {
+	n, err := d.length(size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.str(n)
+}
+
+func (d *decoder) arrayValue(n int, depth int) (interface{}, error) {
+	// The capacity is bounded so a large length doesn't allocate memory before
+	// the values are actually read.
+	a := make([]interface{}, 0, min(n, 1024))
+
+	for i := 0; i != n; i++ {
+		v, err := d.value(depth + 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		a = append(a, v)
+	}
+
+	return a, nil
+}
+
+func (d *decoder) mapValue(n int, depth int) (interface{}, error) {
+	m := make(map[string]interface{}, min(n, 1024))
+
+	for i := 0; i != n; i++ {
+		k, err := d.value(depth + 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := d.value(depth + 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if s, ok := k.(string); ok {
+			m[s] = v
+		} else {
+			m[fmt.Sprint(k)] = v
+		}
+	}
+
+	return m, nil
+}
+
+func (d *decoder) extN(size int) (interface{}, error) {
+	n, err := d.length(size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.ext(n)
+}
+
+func (d *decoder) ext(n int) (interface{}, error) {
+	t, err := d.byte()
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := d.bytes(n)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case int8(t) == 0 && n == 8:
+		// The EventTime extension of the forward protocol.
+		sec := binary.BigEndian.Uint32(b[:4])
+		nsec := binary.BigEndian.Uint32(b[4:])
+		return time.Unix(int64(sec), int64(nsec)), nil
+
+	case int8(t) == -1 && n == 4:
+		// The timestamp extension of the msgpack specification.
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0), nil
+
+	case int8(t) == -1 && n == 8:
+		u := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(u&0x3ffffffff), int64(u>>34)), nil
+
+	case int8(t) == -1 && n == 12:
+		nsec := binary.BigEndian.Uint32(b[:4])
+		sec := binary.BigEndian.Uint64(b[4:])
+		return time.Unix(int64(sec), int64(nsec)), nil
+	}
+
+	return b, nil
+}
+
+// eof converts io.EOF to io.ErrUnexpectedEOF.
+func eof(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,352 @@
+package fluentd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+const DefaultListenURL = "tcp://:24224"
+
+// Config represents the options of fluentd sources.
+type Config struct {
+	// The address to listen on, the scheme is one of tcp or unix, for example
+	// unix:///var/run/fluentd.sock. It defaults to tcp://:24224.
+	URL string `json:"url"`
+}
+
+func (c Config) listenAddress() (network string, address string, err error) {
+	var u *url.URL
+
+	if len(c.URL) == 0 {
+		c.URL = DefaultListenURL
+	}
+
+	if u, err = url.Parse(c.URL); err != nil {
+		err = fmt.Errorf("invalid fluentd URL: %s", err)
+		return
+	}
+
+	switch network = u.Scheme; network {
+	case "tcp", "tcp4", "tcp6":
+		address = u.Host
+	case "unix":
+		address = u.Path
+	default:
+		err = fmt.Errorf("invalid fluentd URL, the scheme must be one of tcp or unix: %s", c.URL)
+	}
+
+	return
+}
+
+func NewReader() (lib.Reader, error) {
+	return OpenReader(Config{
+		URL: os.Getenv("FLUENTD_LISTEN_URL"),
+	})
+}
+
+func OpenReader(config Config) (lib.Reader, error) {
+	network, address, err := config.listenAddress()
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reader{Listener: lib.NewListener("fluentd")}
+
+	if err = r.Listen(network, address, nil, r.serve); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+type reader struct {
+	*lib.Listener
+}
+
+func (r *reader) serve(conn net.Conn, from string) {
+	c := &connection{conn: conn, from: from}
+	// Acknowledgements are cheap to send and clients wait for them, so they
+	// are not delayed.
+	c.checkpoint = lib.NewCheckpointInterval(c.commit, 0)
+	d := newDecoder(bufio.NewReader(conn))
+
+	for {
+		v, err := d.decode()
+
+		if err != nil {
+			if err != io.EOF && !r.Closed() {
+				log.WithFields(log.Fields{
+					"from":  c.from,
+					"error": err,
+				}).Error("closing fluentd connection")
+			}
+			return
+		}
+
+		msgs, chunk, err := decodeEntries(v)
+
+		if err != nil {
+			// The stream can't be trusted after an invalid entry, the client
+			// will retry the chunks that weren't acknowledged.
+			log.WithFields(log.Fields{
+				"from":  c.from,
+				"error": err,
+			}).Error("closing fluentd connection")
+			return
+		}
+
+		if len(chunk) != 0 {
+			c.track(msgs, chunk)
+		}
+
+		for _, msg := range msgs {
+			if len(msg.Stream) == 0 {
+				msg.Stream = c.from
+			}
+
+			if !r.Send(msg) {
+				return
+			}
+		}
+	}
+}
+
+// connection acknowledges the chunks received on a fluentd connection once all
+// their messages and the messages received before them were delivered.
+type connection struct {
+	conn       net.Conn
+	from       string
+	checkpoint *lib.Checkpoint
+	mutex      sync.Mutex
+	chunks     []chunk
+	next       int
+}
+
+type chunk struct {
+	id    string
+	index int
+	empty bool
+}
+
+// track attaches positions to the messages of a chunk, the value of each
+// position is the index of the last chunk that is fully delivered when the
+// checkpoint reaches it.
+func (c *connection) track(msgs []lib.Message, id string) {
+	c.mutex.Lock()
+	index := c.next
+	c.next++
+	c.chunks = append(c.chunks, chunk{id: id, index: index, empty: len(msgs) == 0})
+	c.mutex.Unlock()
+
+	if len(msgs) == 0 {
+		// Chunks without messages are acknowledged as soon as the chunks
+		// received before them are, committing -1 only acknowledges empty
+		// chunks at the front of the list.
+		c.commit("-1")
+		return
+	}
+
+	for i := range msgs {
+		v := index - 1
+
+		if i == len(msgs)-1 {
+			v = index
+		}
+
+		msgs[i].Position = c.checkpoint.Track(strconv.Itoa(v))
+	}
+}
+
+func (c *connection) commit(value string) error {
+	index, _ := strconv.Atoi(value)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for len(c.chunks) != 0 && (c.chunks[0].index <= index || c.chunks[0].empty) {
+		if _, err := c.conn.Write(ackResponse(c.chunks[0].id)); err != nil {
+			return err
+		}
+		c.chunks = c.chunks[1:]
+	}
+
+	return nil
+}
+
+// ackResponse encodes the {"ack": chunk} response of the forward protocol.
+func ackResponse(chunk string) []byte {
+	b := []byte{0x81, 0xa3, 'a', 'c', 'k'}
+
+	switch n := len(chunk); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 256:
+		b = append(b, 0xd9, byte(n))
+	default:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	}
+
+	return append(b, chunk...)
+}
+
+// decodeEntries decodes the messages of an entry received in any of the
+// Message, Forward, PackedForward or CompressedPackedForward modes, and the
+// chunk option which requests an acknowledgement.
+func decodeEntries(v interface{}) (msgs []lib.Message, chunk string, err error) {
+	a, ok := v.([]interface{})
+
+	if !ok || len(a) < 2 {
+		err = errors.New("invalid fluentd entry, expected an array of at least 2 elements")
+		return
+	}
+
+	tag, ok := a[0].(string)
+
+	if !ok {
+		err = errors.New("invalid fluentd entry, the tag must be a string")
+		return
+	}
+
+	var option map[string]interface{}
+
+	switch x := a[1].(type) {
+	case []interface{}:
+		// Forward mode: [tag, [[time, record], ...], option]
+		if len(a) > 2 {
+			option, _ = a[2].(map[string]interface{})
+		}
+
+		for _, e := range x {
+			if p, ok := e.([]interface{}); ok && len(p) >= 2 {
+				if msg, ok := makeMessage(tag, p[0], p[1]); ok {
+					msgs = append(msgs, msg)
+				}
+			}
+		}
+
+	case string:
+		// PackedForward and CompressedPackedForward modes:
+		// [tag, msgpack-stream, option]
+		if len(a) > 2 {
+			option, _ = a[2].(map[string]interface{})
+		}
+
+		var r io.Reader = strings.NewReader(x)
+
+		if option["compressed"] == "gzip" {
+			if r, err = gzip.NewReader(r); err != nil {
+				return
+			}
+		}
+
+		if msgs, err = decodePackedEntries(tag, r); err != nil {
+			return
+		}
+
+	default:
+		// Message mode: [tag, time, record, option]
+		if len(a) < 3 {
+			err = errors.New("invalid fluentd entry, missing record")
+			return
+		}
+
+		if len(a) > 3 {
+			option, _ = a[3].(map[string]interface{})
+		}
+
+		if msg, ok := makeMessage(tag, a[1], a[2]); ok {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	chunk, _ = option["chunk"].(string)
+	return
+}
+
+func decodePackedEntries(tag string, r io.Reader) (msgs []lib.Message, err error) {
+	d := newDecoder(r)
+
+	for {
+		var v interface{}
+
+		if v, err = d.decode(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+
+		if p, ok := v.([]interface{}); ok && len(p) >= 2 {
+			if msg, ok := makeMessage(tag, p[0], p[1]); ok {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+}
+
+// makeMessage converts a fluentd event to a message. The group is the tag and
+// the stream is the container name set by the docker fluentd log driver. The
+// log field written by docker is parsed like in the docker source, other
+// records have their message field as message and the rest as data.
+func makeMessage(tag string, t interface{}, v interface{}) (msg lib.Message, ok bool) {
+	var record map[string]interface{}
+
+	if record, ok = v.(map[string]interface{}); !ok {
+		return
+	}
+
+	msg.Group = tag
+
+	if name, _ := record["container_name"].(string); len(name) != 0 {
+		msg.Stream = strings.TrimPrefix(name, "/")
+	} else if id, _ := record["container_id"].(string); len(id) != 0 {
+		msg.Stream = id
+	}
+
+	if s, isString := record["log"].(string); isString {
+		msg.Event, msg.PlainText = lib.ParseText(strings.TrimRight(s, "\r\n"))
+	} else {
+		msg.Event.Data = ecslogs.EventData{}
+
+		for k, x := range record {
+			switch k {
+			case "message", "msg":
+				if s, isString := x.(string); isString && len(msg.Event.Message) == 0 {
+					msg.Event.Message = s
+					continue
+				}
+			}
+			msg.Event.Data[k] = x
+		}
+	}
+
+	if msg.Event.Time.IsZero() {
+		switch x := t.(type) {
+		case time.Time:
+			msg.Event.Time = x
+		case int64:
+			msg.Event.Time = time.Unix(x, 0)
+		case uint64:
+			msg.Event.Time = time.Unix(int64(x), 0)
+		case float64:
+			msg.Event.Time = time.Unix(0, int64(x*1e9))
+		}
+	}
+
+	return
+}
@@ -0,0 +1,246 @@
+package fluentd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// encode is a minimal msgpack encoder used to produce the test inputs.
+func encode(b []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if x {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case int:
+		return encode(b, int64(x))
+	case int64:
+		b = append(b, 0xd3)
+		return appendUint(b, uint64(x), 8)
+	case uint64:
+		b = append(b, 0xcf)
+		return appendUint(b, x, 8)
+	case float64:
+		b = append(b, 0xcb)
+		return appendUint(b, math.Float64bits(x), 8)
+	case string:
+		b = append(b, 0xdb)
+		b = appendUint(b, uint64(len(x)), 4)
+		return append(b, x...)
+	case []byte:
+		b = append(b, 0xc6)
+		b = appendUint(b, uint64(len(x)), 4)
+		return append(b, x...)
+	case time.Time:
+		b = append(b, 0xd7, 0x00)
+		b = appendUint(b, uint64(x.Unix()), 4)
+		return appendUint(b, uint64(x.Nanosecond()), 4)
+	case []interface{}:
+		b = append(b, 0xdd)
+		b = appendUint(b, uint64(len(x)), 4)
+		for _, e := range x {
+			b = encode(b, e)
+		}
+		return b
+	case map[string]interface{}:
+		b = append(b, 0xdf)
+		b = appendUint(b, uint64(len(x)), 4)
+		for k, e := range x {
+			b = encode(b, k)
+			b = encode(b, e)
+		}
+		return b
+	}
+	panic("cannot encode value")
+}
+
+func appendUint(b []byte, u uint64, size int) []byte {
+	var x [8]byte
+	binary.BigEndian.PutUint64(x[:], u)
+	return append(b, x[8-size:]...)
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		input []byte
+		value interface{}
+	}{
+		{[]byte{0x2a}, int64(42)},
+		{[]byte{0xff}, int64(-1)},
+		{[]byte{0xd0, 0x80}, int64(-128)},
+		{[]byte{0xcd, 0x01, 0x00}, int64(256)},
+		{[]byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(math.MaxUint64)},
+		{[]byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, 1.5},
+		{[]byte{0xa3, 'a', 'b', 'c'}, "abc"},
+		{[]byte{0xc4, 0x02, 'h', 'i'}, "hi"},
+		{[]byte{0x92, 0xc3, 0xc0}, []interface{}{true, nil}},
+		{[]byte{0x81, 0x01, 0xa1, 'x'}, map[string]interface{}{"1": "x"}},
+		{[]byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x10}, time.Unix(16, 0)},
+		{encode(nil, time.Unix(1500000000, 42)), time.Unix(1500000000, 42)},
+	}
+
+	for _, test := range tests {
+		v, err := newDecoder(bytes.NewReader(test.input)).decode()
+
+		if err != nil {
+			t.Errorf("%x: %s", test.input, err)
+		} else if !reflect.DeepEqual(v, test.value) {
+			t.Errorf("%x: expected %#v but found %#v", test.input, test.value, v)
+		}
+	}
+
+	for _, input := range [][]byte{
+		{0xc1},
+		{0x92, 0xc3},
+		{0xdb, 0x00, 0x00},
+		{0xdb, 0xff, 0xff, 0xff, 0xff},
+		bytes.Repeat([]byte{0x91}, 200),
+	} {
+		if _, err := newDecoder(bytes.NewReader(input)).decode(); err == nil || err == io.EOF {
+			t.Errorf("%x: expected an error but found %v", input, err)
+		}
+	}
+}
+
+func TestDecodeEntries(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	docker := map[string]interface{}{
+		"container_name": "/web",
+		"container_id":   "abc123",
+		"source":         "stdout",
+		"log":            `{"level":"WARN","message":"hello"}`,
+	}
+	other := map[string]interface{}{"message": "world", "count": 1}
+
+	var packed []byte
+	packed = encode(packed, []interface{}{now, docker})
+	packed = encode(packed, []interface{}{now, other})
+
+	var compressed bytes.Buffer
+	z := gzip.NewWriter(&compressed)
+	z.Write(packed)
+	z.Close()
+
+	tests := []struct {
+		name  string
+		entry []interface{}
+		count int
+		chunk string
+	}{
+		{"message", []interface{}{"tag", now, docker}, 1, ""},
+		{"message+option", []interface{}{"tag", int64(1500000000), docker, map[string]interface{}{"chunk": "c1"}}, 1, "c1"},
+		{"forward", []interface{}{"tag", []interface{}{[]interface{}{now, docker}, []interface{}{now, other}}}, 2, ""},
+		{"packed", []interface{}{"tag", packed, map[string]interface{}{"chunk": "c2"}}, 2, "c2"},
+		{"compressed", []interface{}{"tag", compressed.Bytes(), map[string]interface{}{"compressed": "gzip"}}, 2, ""},
+	}
+
+	for _, test := range tests {
+		v, err := newDecoder(bytes.NewReader(encode(nil, test.entry))).decode()
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		msgs, chunk, err := decodeEntries(v)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if len(msgs) != test.count || chunk != test.chunk {
+			t.Errorf("%s: expected %d messages and chunk %q but found %d and %q", test.name, test.count, test.chunk, len(msgs), chunk)
+			continue
+		}
+
+		msg := msgs[0]
+
+		if msg.Group != "tag" || msg.Stream != "web" || msg.Event.Level != ecslogs.WARN || msg.Event.Message != "hello" || !msg.Event.Time.Equal(now) {
+			t.Errorf("%s: invalid message: %#v", test.name, msg)
+		}
+
+		if len(msgs) > 1 {
+			msg = msgs[1]
+
+			if msg.Stream != "" || msg.Event.Message != "world" || msg.Event.Data["count"] != int64(1) {
+				t.Errorf("%s: invalid message: %#v", test.name, msg)
+			}
+		}
+	}
+}
+
+func TestReaderAck(t *testing.T) {
+	r, err := OpenReader(Config{URL: "tcp://127.0.0.1:0"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	conn, err := net.Dial("tcp", r.(*reader).Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	record := map[string]interface{}{"log": "hello\n"}
+	entries := []interface{}{[]interface{}{int64(1), record}, []interface{}{int64(2), record}}
+	conn.Write(encode(nil, []interface{}{"tag", entries, map[string]interface{}{"chunk": "first"}}))
+	conn.Write(encode(nil, []interface{}{"tag", []interface{}{}, map[string]interface{}{"chunk": "empty"}}))
+
+	var batch lib.MessageBatch
+
+	for i := 0; i != 2; i++ {
+		msg, err := r.ReadMessage()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Group != "tag" || msg.Stream != "127.0.0.1" || msg.Event.Message != "hello" {
+			t.Errorf("invalid message: %#v", msg)
+		}
+
+		batch = append(batch, msg)
+	}
+
+	// Nothing is acknowledged until the messages are delivered.
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	if n, _ := conn.Read(make([]byte, 1)); n != 0 {
+		t.Error("received an acknowledgement before the messages were delivered")
+	}
+
+	if err := lib.AckBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append(ackResponse("first"), ackResponse("empty")...)
+	found := make([]byte, len(expected))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	if _, err := io.ReadFull(conn, found); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(found, expected) {
+		t.Errorf("invalid acknowledgements: %q", found)
+	}
+}
@@ -0,0 +1,150 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+var errTooLarge = errors.New("GELF message is too large")
+
+// decompress returns the payload of a GELF message, which may be compressed
+// with gzip or zlib.
+func decompress(b []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+
+	switch {
+	case len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case len(b) >= 2 && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	default:
+		return b, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	if b, err = ioutil.ReadAll(io.LimitReader(r, maxMessageBytes+1)); err != nil {
+		return nil, err
+	}
+
+	if len(b) > maxMessageBytes {
+		return nil, errTooLarge
+	}
+
+	return b, nil
+}
+
+// Fields are the fields of a GELF message.
+type Fields map[string]interface{}
+
+func parseFields(b []byte) (f Fields, err error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	if err = d.Decode(&f); err != nil {
+		err = fmt.Errorf("invalid GELF message: %s", err)
+	}
+
+	return
+}
+
+func (f Fields) string(name string) string {
+	s, _ := f[name].(string)
+	return s
+}
+
+func (f Fields) number(name string) (float64, bool) {
+	n, ok := f[name].(json.Number)
+
+	if !ok {
+		return 0, false
+	}
+
+	v, err := n.Float64()
+	return v, err == nil
+}
+
+// Group returns the group of the message, which is the _tag field set by the
+// docker gelf log driver or the container name when there is no tag.
+func (f Fields) Group() string {
+	if s := f.string("_tag"); len(s) != 0 {
+		return s
+	}
+	return f.string("_container_name")
+}
+
+// Stream returns the stream of the message, which is the container name set by
+// the docker gelf log driver.
+func (f Fields) Stream() string {
+	return f.string("_container_name")
+}
+
+// Event converts the fields to an event. The full_message (or short_message)
+// field is parsed like the messages of other sources, plain is true if it
+// wasn't a JSON event. Additional fields are set in the event data without
+// their '_' prefix.
+func (f Fields) Event() (e ecslogs.Event, plain bool, err error) {
+	text := f.string("full_message")
+
+	if len(text) == 0 {
+		text = f.string("short_message")
+	}
+
+	if len(text) == 0 {
+		err = errors.New("invalid GELF message, short_message is missing")
+		return
+	}
+
+	e, plain = lib.ParseText(strings.TrimRight(text, "\r\n"))
+
+	if level, ok := f.number("level"); ok && e.Level == ecslogs.NONE && level >= 0 && level <= 7 {
+		e.Level = ecslogs.MakeLevel(int(level))
+	}
+
+	if ts, ok := f.number("timestamp"); ok && e.Time.IsZero() {
+		sec, frac := math.Modf(ts)
+		e.Time = time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3)
+	}
+
+	if host := f.string("host"); len(host) != 0 && len(e.Info.Host) == 0 {
+		e.Info.Host = host
+	}
+
+	if file := f.string("file"); len(file) != 0 && len(e.Info.Source) == 0 {
+		if line, ok := f.number("line"); ok {
+			file = fmt.Sprintf("%s:%d", file, int(line))
+		}
+		e.Info.Source = file
+	}
+
+	for k, v := range f {
+		if !strings.HasPrefix(k, "_") || k == "_id" {
+			continue
+		}
+
+		if e.Data == nil {
+			e.Data = ecslogs.EventData{}
+		}
+
+		e.Data[k[1:]] = v
+	}
+
+	return
+}
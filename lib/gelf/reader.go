@@ -0,0 +1,286 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+const (
+	DefaultListenURL = "udp://:12201"
+
+	// The maximum size of messages once reassembled and decompressed.
+	maxMessageBytes = 1048576
+
+	// Chunked messages are discarded if all their chunks weren't received
+	// within this delay, and can't have more than maxChunks chunks.
+	chunkTimeout = 5 * time.Second
+	maxChunks    = 128
+
+	// The maximum number of chunked messages being reassembled at once.
+	maxPending = 1000
+)
+
+// Config represents the options of gelf sources.
+type Config struct {
+	// The address to listen on, the scheme is one of udp or tcp. It defaults
+	// to udp://:12201.
+	URL string `json:"url"`
+
+	// The group and stream of messages that don't have the _tag or
+	// _container_name fields set by docker, they default to "gelf" and the
+	// host field of the message.
+	Group  string `json:"group"`
+	Stream string `json:"stream"`
+}
+
+func (c Config) listenAddress() (network string, address string, err error) {
+	var u *url.URL
+
+	if len(c.URL) == 0 {
+		c.URL = DefaultListenURL
+	}
+
+	if u, err = url.Parse(c.URL); err != nil {
+		err = fmt.Errorf("invalid gelf URL: %s", err)
+		return
+	}
+
+	switch network = u.Scheme; network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+		address = u.Host
+	default:
+		err = fmt.Errorf("invalid gelf URL, the scheme must be one of udp or tcp: %s", c.URL)
+	}
+
+	return
+}
+
+func NewReader() (lib.Reader, error) {
+	return OpenReader(Config{
+		URL: os.Getenv("GELF_LISTEN_URL"),
+	})
+}
+
+func OpenReader(config Config) (lib.Reader, error) {
+	network, address, err := config.listenAddress()
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reader{
+		Listener: lib.NewListener("GELF"),
+		config:   config,
+	}
+
+	switch network {
+	case "udp", "udp4", "udp6":
+		c := newChunkBuffer()
+		err = r.ListenPacket(network, address, func(b []byte, from string) bool {
+			return r.handlePacket(c, b, from)
+		})
+
+	default:
+		err = r.Listen(network, address, nil, r.serve)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+type reader struct {
+	*lib.Listener
+	config Config
+}
+
+// handlePacket reassembles the chunked messages received over UDP, it returns
+// false if the reader was closed.
+func (r *reader) handlePacket(c *chunkBuffer, b []byte, from string) bool {
+	packet, err := c.add(b, time.Now())
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"from":  from,
+			"error": err,
+		}).Warn("dropping invalid GELF chunk")
+		return true
+	}
+
+	return packet == nil || r.handle(packet, from)
+}
+
+// serve reads the messages of a TCP connection, which are separated by null
+// bytes and can't be compressed.
+func (r *reader) serve(conn net.Conn, from string) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxMessageBytes+1)
+	scanner.Split(splitNull)
+
+	for scanner.Scan() {
+		if b := bytes.TrimSpace(scanner.Bytes()); len(b) != 0 && !r.handle(b, from) {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !r.Closed() {
+		log.WithFields(log.Fields{
+			"from":  from,
+			"error": err,
+		}).Error("closing GELF connection")
+	}
+}
+
+func splitNull(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF && len(data) != 0 {
+		return len(data), data, nil
+	}
+
+	return
+}
+
+// handle decodes b and sends the message to the reader, it returns false if
+// the reader was closed.
+func (r *reader) handle(b []byte, from string) bool {
+	msg, err := r.message(b, from)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"from":  from,
+			"error": err,
+		}).Warn("dropping invalid GELF message")
+		return true
+	}
+
+	return r.Send(msg)
+}
+
+func (r *reader) message(b []byte, from string) (msg lib.Message, err error) {
+	var f Fields
+
+	if b, err = decompress(b); err != nil {
+		return
+	}
+
+	if f, err = parseFields(b); err != nil {
+		return
+	}
+
+	if msg.Event, msg.PlainText, err = f.Event(); err != nil {
+		return
+	}
+
+	if msg.Group = f.Group(); len(msg.Group) == 0 {
+		if msg.Group = r.config.Group; len(msg.Group) == 0 {
+			msg.Group = "gelf"
+		}
+	}
+
+	if msg.Stream = f.Stream(); len(msg.Stream) == 0 {
+		if msg.Stream = r.config.Stream; len(msg.Stream) == 0 {
+			if msg.Stream = f.string("host"); len(msg.Stream) == 0 {
+				msg.Stream = from
+			}
+		}
+	}
+
+	return
+}
+
+// chunkBuffer reassembles the chunked messages received over UDP.
+type chunkBuffer struct {
+	messages map[string]*chunkedMessage
+	expired  time.Time
+}
+
+type chunkedMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	first    time.Time
+}
+
+func newChunkBuffer() *chunkBuffer {
+	return &chunkBuffer{messages: make(map[string]*chunkedMessage)}
+}
+
+// add adds a packet to the buffer, it returns the reassembled message once all
+// the chunks were received, or the packet itself if it isn't chunked.
+func (c *chunkBuffer) add(b []byte, now time.Time) ([]byte, error) {
+	if now.Sub(c.expired) >= time.Second {
+		c.expire(now)
+	}
+
+	if len(b) < 2 || b[0] != 0x1e || b[1] != 0x0f {
+		return b, nil
+	}
+
+	if len(b) < 12 {
+		return nil, errors.New("GELF chunk header is too short")
+	}
+
+	id := string(b[2:10])
+	seq := int(b[10])
+	count := int(b[11])
+
+	if count == 0 || count > maxChunks || seq >= count {
+		return nil, fmt.Errorf("invalid GELF chunk sequence: %d/%d", seq, count)
+	}
+
+	m := c.messages[id]
+
+	if m == nil {
+		if len(c.messages) >= maxPending {
+			return nil, errors.New("too many GELF messages are being reassembled")
+		}
+		m = &chunkedMessage{chunks: make([][]byte, count), first: now}
+		c.messages[id] = m
+	}
+
+	if len(m.chunks) != count {
+		delete(c.messages, id)
+		return nil, errors.New("GELF chunks have different sequence counts")
+	}
+
+	if m.chunks[seq] != nil {
+		return nil, nil
+	}
+
+	if m.size += len(b) - 12; m.size > maxMessageBytes {
+		delete(c.messages, id)
+		return nil, errTooLarge
+	}
+
+	m.chunks[seq] = append([]byte(nil), b[12:]...)
+
+	if m.received++; m.received != count {
+		return nil, nil
+	}
+
+	delete(c.messages, id)
+	return bytes.Join(m.chunks, nil), nil
+}
+
+func (c *chunkBuffer) expire(now time.Time) {
+	for id, m := range c.messages {
+		if now.Sub(m.first) > chunkTimeout {
+			delete(c.messages, id)
+		}
+	}
+	c.expired = now
+}
@@ -0,0 +1,207 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/segmentio/ecs-logs-go"
+)
+
+const dockerMessage = `{
+  "version": "1.1",
+  "host": "ip-10-0-0-1",
+  "short_message": "{\"level\":\"WARN\",\"message\":\"hello\"}",
+  "timestamp": 1500000000.25,
+  "level": 6,
+  "_container_name": "web-1",
+  "_container_id": "abc123",
+  "_tag": "web",
+  "_id": "ignored"
+}`
+
+func TestFieldsEvent(t *testing.T) {
+	f, err := parseFields([]byte(dockerMessage))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, plain, err := f.Event()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plain {
+		t.Error("the message should have been decoded from JSON")
+	}
+
+	if f.Group() != "web" || f.Stream() != "web-1" {
+		t.Errorf("invalid group and stream: %s/%s", f.Group(), f.Stream())
+	}
+
+	if e.Level != ecslogs.WARN || e.Message != "hello" || e.Info.Host != "ip-10-0-0-1" {
+		t.Errorf("invalid event: %#v", e)
+	}
+
+	if !e.Time.Equal(time.Unix(1500000000, 250000000)) {
+		t.Error("invalid time:", e.Time)
+	}
+
+	if e.Data["container_id"] != "abc123" || e.Data["tag"] != "web" || e.Data["id"] != nil || len(e.Data) != 3 {
+		t.Errorf("invalid data: %#v", e.Data)
+	}
+
+	f, _ = parseFields([]byte(`{"short_message":"short","full_message":"full\ntrace\n","level":3,"file":"main.go","line":42}`))
+	e, plain, _ = f.Event()
+
+	if e.Level != ecslogs.ERROR || e.Message != "full\ntrace" || e.Info.Source != "main.go:42" || !plain {
+		t.Errorf("invalid event: %#v", e)
+	}
+
+	f, _ = parseFields([]byte(`{"version":"1.1","host":"h"}`))
+
+	if _, _, err := f.Event(); err == nil {
+		t.Error("expected an error for a message without short_message")
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write([]byte(dockerMessage))
+	w.Close()
+
+	var g bytes.Buffer
+	gw := gzip.NewWriter(&g)
+	gw.Write([]byte(dockerMessage))
+	gw.Close()
+
+	for _, input := range [][]byte{[]byte(dockerMessage), z.Bytes(), g.Bytes()} {
+		b, err := decompress(input)
+
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != dockerMessage {
+			t.Errorf("invalid payload: %q", b)
+		}
+	}
+}
+
+func chunk(id string, seq byte, count byte, payload []byte) []byte {
+	return append(append([]byte{0x1e, 0x0f}, append([]byte(id), seq, count)...), payload...)
+}
+
+func TestChunkBuffer(t *testing.T) {
+	now := time.Now()
+	c := newChunkBuffer()
+
+	if b, _ := c.add([]byte("plain"), now); string(b) != "plain" {
+		t.Errorf("invalid plain packet: %q", b)
+	}
+
+	// Chunks received out of order, interleaved with another message.
+	if b, err := c.add(chunk("AAAAAAAA", 1, 3, []byte("BB")), now); b != nil || err != nil {
+		t.Error("unexpected result:", b, err)
+	}
+	c.add(chunk("XXXXXXXX", 0, 2, []byte("x")), now)
+	c.add(chunk("AAAAAAAA", 2, 3, []byte("C")), now)
+	c.add(chunk("AAAAAAAA", 2, 3, []byte("C")), now)
+
+	if b, err := c.add(chunk("AAAAAAAA", 0, 3, []byte("A")), now); string(b) != "ABBC" || err != nil {
+		t.Errorf("invalid reassembled message: %q (%v)", b, err)
+	}
+
+	// The incomplete message expires.
+	c.add([]byte("plain"), now.Add(2*chunkTimeout))
+
+	if len(c.messages) != 0 {
+		t.Error("the incomplete message wasn't expired")
+	}
+
+	if _, err := c.add(chunk("BBBBBBBB", 3, 2, nil), now); err == nil {
+		t.Error("expected an error for an invalid sequence number")
+	}
+
+	if _, err := c.add(chunk("BBBBBBBB", 0, 200, nil), now); err == nil {
+		t.Error("expected an error for too many chunks")
+	}
+}
+
+func TestReaderUDP(t *testing.T) {
+	r, err := OpenReader(Config{URL: "udp://127.0.0.1:0"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	conn, err := net.Dial("udp", r.(*reader).Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	var z bytes.Buffer
+	w := gzip.NewWriter(&z)
+	w.Write([]byte(dockerMessage))
+	w.Close()
+
+	b := z.Bytes()
+	half := len(b) / 2
+	conn.Write(chunk("12345678", 1, 2, b[half:]))
+	conn.Write(chunk("12345678", 0, 2, b[:half]))
+
+	msg, err := r.ReadMessage()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Group != "web" || msg.Stream != "web-1" || msg.Event.Message != "hello" {
+		t.Errorf("invalid message: %#v", msg)
+	}
+}
+
+func TestReaderTCP(t *testing.T) {
+	log.SetHandler(discard.New())
+
+	r, err := OpenReader(Config{URL: "tcp://127.0.0.1:0", Group: "G"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	conn, err := net.Dial("tcp", r.(*reader).Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	conn.Write([]byte(`{"short_message":"invalid"` + "\x00"))
+	conn.Write([]byte(`{"host":"h1","short_message":"one"}` + "\x00" + `{"host":"h2","short_message":"two"}` + "\x00"))
+
+	for _, expected := range []string{"one", "two"} {
+		msg, err := r.ReadMessage()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Group != "G" || msg.Event.Message != expected || msg.Stream != msg.Event.Info.Host {
+			t.Errorf("invalid message: %#v", msg)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package http
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+const (
+	DefaultAddress     = ":8080"
+	DefaultPath        = "/"
+	DefaultMaxBodySize = 5 * 1024 * 1024
+	DefaultBufferSize  = 10000
+
+	// How long closing the source waits for pending requests to complete.
+	shutdownTimeout = 5 * time.Second
+)
+
+// Config represents the options of http sources.
+type Config struct {
+	// The address and path that events are posted to, they default to :8080
+	// and /.
+	Address string `json:"address"`
+	Path    string `json:"path"`
+
+	// The certificate and key to serve https instead of http.
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// When set, requests must have an "Authorization: Bearer <token>" header.
+	Token string `json:"token"`
+
+	// The maximum size of request bodies after decompression, larger requests
+	// are rejected with 413.
+	MaxBodySize int64 `json:"max_body_size"`
+
+	// The number of messages that can be buffered before they're read from the
+	// source, requests are rejected with 429 when the buffer is full.
+	BufferSize int `json:"buffer_size"`
+}
+
+func (c Config) validate() error {
+	if (len(c.CertFile) == 0) != (len(c.KeyFile) == 0) {
+		return errors.New("both cert_file and key_file must be set to serve https")
+	}
+
+	if c.MaxBodySize < 0 {
+		return errors.New("max_body_size cannot be negative")
+	}
+
+	if c.BufferSize < 0 {
+		return errors.New("buffer_size cannot be negative")
+	}
+
+	if len(c.Path) != 0 && !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("path must start with a '/': %s", c.Path)
+	}
+
+	return nil
+}
+
+func NewReader() (lib.Reader, error) {
+	config := Config{
+		Address:  os.Getenv("HTTP_LISTEN_ADDRESS"),
+		Path:     os.Getenv("HTTP_PATH"),
+		CertFile: os.Getenv("HTTP_TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("HTTP_TLS_KEY_FILE"),
+		Token:    os.Getenv("HTTP_TOKEN"),
+	}
+
+	if s := os.Getenv("HTTP_MAX_BODY_SIZE"); len(s) != 0 {
+		n, err := strconv.ParseInt(s, 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP_MAX_BODY_SIZE: %s", err)
+		}
+
+		config.MaxBodySize = n
+	}
+
+	if s := os.Getenv("HTTP_BUFFER_SIZE"); len(s) != 0 {
+		n, err := strconv.Atoi(s)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP_BUFFER_SIZE: %s", err)
+		}
+
+		config.BufferSize = n
+	}
+
+	return OpenReader(config)
+}
+
+func OpenReader(config Config) (lib.Reader, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if len(config.Path) == 0 {
+		config.Path = DefaultPath
+	}
+
+	if config.MaxBodySize == 0 {
+		config.MaxBodySize = DefaultMaxBodySize
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	listener, err := net.Listen("tcp", config.Address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reader{
+		config:   config,
+		listener: listener,
+		messages: make(chan lib.Message, config.BufferSize),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(config.Path, r)
+	r.server = &http.Server{Handler: mux}
+
+	r.join.Add(1)
+	go r.serve()
+	return r, nil
+}
+
+type reader struct {
+	config   Config
+	listener net.Listener
+	server   *http.Server
+	messages chan lib.Message
+	once     sync.Once
+	join     sync.WaitGroup
+	mutex    sync.Mutex
+	closed   bool
+}
+
+func (r *reader) Close() error {
+	r.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		// Shutdown waits for the requests being served, the messages that
+		// were accepted are still returned by ReadMessage until the buffer is
+		// empty.
+		r.server.Shutdown(ctx)
+		r.join.Wait()
+
+		r.mutex.Lock()
+		r.closed = true
+		close(r.messages)
+		r.mutex.Unlock()
+	})
+	return nil
+}
+
+func (r *reader) ReadMessage() (msg lib.Message, err error) {
+	var ok bool
+
+	if msg, ok = <-r.messages; !ok {
+		err = io.EOF
+	}
+
+	return
+}
+
+func (r *reader) serve() {
+	defer r.join.Done()
+	var err error
+
+	if len(r.config.CertFile) != 0 {
+		err = r.server.ServeTLS(r.listener, r.config.CertFile, r.config.KeyFile)
+	} else {
+		err = r.server.Serve(r.listener)
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		log.WithError(err).Error("the http source stopped serving requests")
+	}
+}
+
+func (r *reader) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		res.Header().Set("Allow", "POST")
+		http.Error(res, "only POST requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !r.authorized(req) {
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(res, "missing or invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	// The body is read through a limitedReader rather than the reader of
+	// http.MaxBytesReader so errors caused by the size limit can be told
+	// apart from invalid payloads.
+	var body io.Reader = &limitedReader{R: req.Body, N: r.config.MaxBodySize}
+
+	switch enc := req.Header.Get("Content-Encoding"); enc {
+	case "", "identity":
+	case "gzip":
+		z, err := gzip.NewReader(body)
+
+		if err == errBodyTooLarge {
+			http.Error(res, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err != nil {
+			http.Error(res, "invalid gzip body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		defer z.Close()
+
+		// The decompressed body is limited too so small payloads can't expand
+		// to an unbounded amount of memory.
+		body = &limitedReader{R: z, N: r.config.MaxBodySize}
+
+	default:
+		http.Error(res, "unsupported content encoding: "+enc, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	batch, err := decodeMessages(body)
+
+	if err != nil {
+		if err == errBodyTooLarge {
+			http.Error(res, "request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	if len(batch) > r.config.BufferSize {
+		http.Error(res, "too many messages in a single request", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !r.push(batch) {
+		res.Header().Set("Retry-After", "1")
+		http.Error(res, "the message buffer is full", http.StatusTooManyRequests)
+		return
+	}
+
+	res.WriteHeader(http.StatusAccepted)
+}
+
+func (r *reader) authorized(req *http.Request) bool {
+	if len(r.config.Token) == 0 {
+		return true
+	}
+
+	const prefix = "Bearer "
+	auth := req.Header.Get("Authorization")
+
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(r.config.Token)) == 1
+}
+
+// push adds all messages of the batch to the buffer, or none of them if there
+// isn't enough space left so clients can safely retry the whole request.
+func (r *reader) push(batch lib.MessageBatch) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed || len(r.messages)+len(batch) > cap(r.messages) {
+		return false
+	}
+
+	for _, msg := range batch {
+		r.messages <- msg
+	}
+
+	return true
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+type limitedReader struct {
+	R io.Reader
+	N int64
+}
+
+func (l *limitedReader) Read(b []byte) (n int, err error) {
+	if l.N < 0 {
+		return 0, errBodyTooLarge
+	}
+
+	// One byte more than the limit is read to tell whether the reader ends
+	// right at the limit or goes past it.
+	if int64(len(b)) > l.N+1 {
+		b = b[:l.N+1]
+	}
+
+	n, err = l.R.Read(b)
+
+	if l.N -= int64(n); l.N < 0 {
+		n, err = n-1, errBodyTooLarge
+	}
+
+	return
+}
+
+// decodeMessages decodes the messages of a request body, which is either a
+// single message, an array of messages, or a sequence of messages separated by
+// new lines.
+func decodeMessages(r io.Reader) (batch lib.MessageBatch, err error) {
+	var c byte
+	b := bufio.NewReader(r)
+
+	if c, err = peek(b); err != nil {
+		if err == io.EOF {
+			err = errors.New("the request body is empty")
+		}
+		return
+	}
+
+	d := json.NewDecoder(b)
+	d.UseNumber()
+
+	if c == '[' {
+		var extra json.RawMessage
+
+		if err = d.Decode(&batch); err != nil {
+			return
+		}
+
+		if d.Decode(&extra) != io.EOF {
+			return nil, errors.New("unexpected data after the array of messages")
+		}
+	} else {
+		for {
+			var msg lib.Message
+
+			if err = d.Decode(&msg); err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return
+			}
+
+			batch = append(batch, msg)
+		}
+	}
+
+	for i, msg := range batch {
+		if len(msg.Group) == 0 || len(msg.Stream) == 0 {
+			return nil, fmt.Errorf("message %d is missing a group or a stream", i)
+		}
+	}
+
+	return batch, nil
+}
+
+// peek returns the first byte of r that isn't a white space.
+func peek(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+
+		if err != nil {
+			return 0, err
+		}
+
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func newTestReader(config Config) *reader {
+	return &reader{
+		config:   config,
+		messages: make(chan lib.Message, config.BufferSize),
+	}
+}
+
+func post(r *reader, body []byte, headers map[string]string) int {
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	return res.Code
+}
+
+func TestDecodeMessages(t *testing.T) {
+	tests := []struct {
+		body  string
+		count int
+	}{
+		{`{"group":"a","stream":"b","event":{"message":"1"}}`, 1},
+		{`[{"group":"a","stream":"b"},{"group":"a","stream":"c"}]`, 2},
+		{" [ ] ", 0},
+		{"{\"group\":\"a\",\"stream\":\"b\"}\n{\"group\":\"a\",\"stream\":\"c\"}\n{\"group\":\"a\",\"stream\":\"d\"}\n", 3},
+	}
+
+	for _, test := range tests {
+		batch, err := decodeMessages(strings.NewReader(test.body))
+
+		if err != nil {
+			t.Errorf("%q: %s", test.body, err)
+		} else if len(batch) != test.count {
+			t.Errorf("%q: expected %d messages but found %d", test.body, test.count, len(batch))
+		}
+	}
+
+	for _, body := range []string{
+		``,
+		`{"group":"a"}`,
+		`{"group":"a","stream":"b"`,
+		`[{"group":"a","stream":"b"}] {}`,
+		`hello`,
+	} {
+		if _, err := decodeMessages(strings.NewReader(body)); err == nil {
+			t.Errorf("%q: expected an error", body)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := newTestReader(Config{Token: "secret", MaxBodySize: 100, BufferSize: 3})
+	auth := map[string]string{"Authorization": "Bearer secret"}
+	msg := []byte(`{"group":"a","stream":"b"}` + "\n")
+
+	if code := post(r, msg, nil); code != http.StatusUnauthorized {
+		t.Error("missing token: expected 401 but found", code)
+	}
+
+	if code := post(r, msg, map[string]string{"Authorization": "Bearer nope"}); code != http.StatusUnauthorized {
+		t.Error("invalid token: expected 401 but found", code)
+	}
+
+	if code := post(r, bytes.Repeat(msg, 4), auth); code != http.StatusRequestEntityTooLarge {
+		t.Error("large body: expected 413 but found", code)
+	}
+
+	if code := post(r, bytes.Repeat(msg, 2), auth); code != http.StatusAccepted {
+		t.Error("valid body: expected 202 but found", code)
+	}
+
+	var z bytes.Buffer
+	w := gzip.NewWriter(&z)
+	w.Write(msg)
+	w.Close()
+
+	if code := post(r, z.Bytes(), map[string]string{"Authorization": "Bearer secret", "Content-Encoding": "gzip"}); code != http.StatusAccepted {
+		t.Error("gzip body: expected 202 but found", code)
+	}
+
+	if code := post(r, msg, auth); code != http.StatusTooManyRequests {
+		t.Error("full buffer: expected 429 but found", code)
+	}
+
+	if len(r.messages) != 3 {
+		t.Error("expected 3 buffered messages but found", len(r.messages))
+	}
+}
+
+func TestGzipBodyLimit(t *testing.T) {
+	r := newTestReader(Config{MaxBodySize: 1000, BufferSize: 100})
+	msg := []byte(`{"group":"a","stream":"b"}` + "\n")
+
+	var z bytes.Buffer
+	w := gzip.NewWriter(&z)
+	w.Write(bytes.Repeat(msg, 50))
+	w.Close()
+
+	if z.Len() > 1000 {
+		t.Fatal("the compressed body is too large for the test:", z.Len())
+	}
+
+	if code := post(r, z.Bytes(), map[string]string{"Content-Encoding": "gzip"}); code != http.StatusRequestEntityTooLarge {
+		t.Error("expected 413 but found", code)
+	}
+}
+
+func TestReaderClose(t *testing.T) {
+	r, err := OpenReader(Config{Address: "127.0.0.1:0"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "http://" + r.(*reader).listener.Addr().String() + "/"
+	res, err := http.Post(url, "application/x-ndjson", strings.NewReader(`{"group":"a","stream":"b"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		t.Error("expected 202 but found", res.StatusCode)
+	}
+
+	r.Close()
+
+	if msg, err := r.ReadMessage(); err != nil || msg.Group != "a" || msg.Stream != "b" {
+		t.Errorf("invalid message: %#v (%v)", msg, err)
+	}
+
+	if _, err := r.ReadMessage(); err == nil {
+		t.Error("expected io.EOF after the buffered messages were read")
+	}
+}
@@ -0,0 +1,76 @@
+// +build linux
+
+package journald
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/sdjournal"
+)
+
+// seekCursor positions the journal so the next call to Next returns the entry
+// that follows the one identified by cursor. If that entry doesn't exist
+// anymore (because the journal was rotated for example) the journal is left
+// on the closest entry, which wasn't read yet.
+func seekCursor(j *sdjournal.Journal, cursor string) (err error) {
+	if err = j.SeekCursor(cursor); err != nil {
+		return
+	}
+
+	if _, err = j.Next(); err != nil {
+		return
+	}
+
+	if j.TestCursor(cursor) != nil {
+		_, err = j.Previous()
+	}
+
+	return
+}
+
+func readCursor(path string) (cursor string, err error) {
+	var b []byte
+
+	if b, err = ioutil.ReadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	cursor = strings.TrimSpace(string(b))
+	return
+}
+
+// writeCursor saves the cursor to a temporary file which is then renamed to
+// path, this way a crash never leaves a partially written state file behind.
+func writeCursor(path string, cursor string) (err error) {
+	var f *os.File
+
+	if f, err = ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp"); err != nil {
+		return
+	}
+
+	tmp := f.Name()
+
+	if _, err = f.WriteString(cursor + "\n"); err == nil {
+		err = f.Sync()
+	}
+
+	if e := f.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return
+}
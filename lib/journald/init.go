@@ -6,4 +6,19 @@ import "github.com/segmentio/ecs-logs/lib"
 
 func init() {
 	lib.RegisterSource("journald", lib.SourceFunc(NewReader))
+	lib.RegisterSourceFactory("journald", func(opts lib.Options) (lib.Source, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+
+		return lib.SourceFunc(func() (lib.Reader, error) {
+			return OpenReader(c)
+		}), nil
+	})
 }
@@ -0,0 +1,116 @@
+// +build linux
+
+package journald
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/coreos/go-systemd/v22/sdjournal"
+)
+
+// The directories where journald stores the persistent and the volatile
+// journals.
+var journalDirectories = []string{"/var/log/journal", "/run/log/journal"}
+
+// openJournal opens the journal selected by the configuration, which is the
+// local journal by default, and adds the configured matches. The list of files
+// that were opened is returned when the journal is read from files rather
+// than from a directory.
+func openJournal(config Config) (j *sdjournal.Journal, files []string, err error) {
+	if files, err = journalFiles(config); err != nil {
+		return
+	}
+
+	switch {
+	case len(files) != 0:
+		j, err = sdjournal.NewJournalFromFiles(files...)
+	case len(config.Directory) != 0:
+		j, err = sdjournal.NewJournalFromDir(config.Directory)
+	default:
+		j, err = sdjournal.NewJournal()
+	}
+
+	if err != nil {
+		return
+	}
+
+	for _, m := range config.Matches {
+		if m == "+" {
+			err = j.AddDisjunction()
+		} else {
+			err = j.AddMatch(m)
+		}
+
+		if err != nil {
+			j.Close()
+			j = nil
+			return
+		}
+	}
+
+	return
+}
+
+// journalFiles returns the journal files selected by the configuration, or
+// nil when the journal is read from a directory, in which case sd-journal
+// picks up the files created by journald itself.
+func journalFiles(config Config) ([]string, error) {
+	switch {
+	case len(config.Files) != 0:
+		return expandFiles(config.Files)
+	case len(config.Namespace) != 0:
+		return namespaceFiles(config.Directory, config.Namespace)
+	default:
+		return nil, nil
+	}
+}
+
+// expandFiles returns the journal files matching the list of glob patterns.
+func expandFiles(patterns []string) (files []string, err error) {
+	for _, p := range patterns {
+		var matches []string
+
+		if matches, err = filepath.Glob(p); err != nil {
+			return
+		}
+
+		if len(matches) == 0 {
+			err = fmt.Errorf("no journal files match %s", p)
+			return
+		}
+
+		files = append(files, matches...)
+	}
+
+	return
+}
+
+// namespaceFiles returns the journal files of a journal namespace, journald
+// stores them next to the default journal in directories named after the
+// machine ID and the namespace. The directories are looked up in root, or in
+// both the persistent and volatile journal directories when root is empty.
+func namespaceFiles(root string, namespace string) (files []string, err error) {
+	roots := journalDirectories
+
+	if len(root) != 0 {
+		roots = []string{root}
+	}
+
+	for _, r := range roots {
+		var matches []string
+
+		if matches, err = filepath.Glob(filepath.Join(r, "*."+namespace, "*.journal")); err != nil {
+			return
+		}
+
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		err = errors.New("no journal found for namespace " + namespace)
+	}
+
+	return
+}
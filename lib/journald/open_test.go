@@ -0,0 +1,81 @@
+// +build linux
+
+package journald
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecs-logs-journald")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	machine := filepath.Join(dir, "0123456789abcdef")
+	namespace := machine + ".apps"
+	os.Mkdir(machine, 0755)
+	os.Mkdir(namespace, 0755)
+	ioutil.WriteFile(filepath.Join(machine, "system.journal"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(machine, "user-1000.journal"), nil, 0644)
+
+	ioutil.WriteFile(filepath.Join(namespace, "system.journal"), nil, 0644)
+
+	if files, err := namespaceFiles(dir, "apps"); err != nil || len(files) != 1 || files[0] != filepath.Join(namespace, "system.journal") {
+		t.Errorf("invalid namespace files: %v (%v)", files, err)
+	}
+
+	if _, err := namespaceFiles(dir, "other"); err == nil {
+		t.Error("expected an error for a namespace that doesn't exist")
+	}
+
+	// Namespaces are looked up in the persistent and volatile journals.
+	volatile := filepath.Join(dir, "run", "0123456789abcdef.apps")
+	os.MkdirAll(volatile, 0755)
+	ioutil.WriteFile(filepath.Join(volatile, "system.journal"), nil, 0644)
+
+	defer func(dirs []string) { journalDirectories = dirs }(journalDirectories)
+	journalDirectories = []string{dir, filepath.Join(dir, "run")}
+
+	if files, err := namespaceFiles("", "apps"); err != nil || len(files) != 2 {
+		t.Errorf("invalid namespace files: %v (%v)", files, err)
+	}
+
+	files, err := expandFiles([]string{filepath.Join(machine, "*.journal")})
+
+	if err != nil || len(files) != 2 {
+		t.Errorf("invalid journal files: %v (%v)", files, err)
+	}
+
+	if _, err := expandFiles([]string{filepath.Join(dir, "*.journal")}); err == nil {
+		t.Error("expected an error for a pattern that matches no files")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{}, true},
+		{Config{Directory: "/host/journal", Namespace: "apps", StartAt: "beginning"}, true},
+		{Config{Files: []string{"a.journal"}, Matches: []string{"A=1", "+", "B=2"}}, true},
+		{Config{Files: []string{"a.journal"}, Directory: "/host/journal"}, false},
+		{Config{Namespace: "../x"}, false},
+		{Config{StartAt: "middle"}, false},
+		{Config{Matches: []string{"A"}}, false},
+		{Config{StreamName: "A", Stream: "{A}"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.config.validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%t but found %v", test.config, test.valid, err)
+		}
+	}
+}
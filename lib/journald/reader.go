@@ -4,6 +4,7 @@ package journald
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -12,34 +13,210 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/coreos/go-systemd/sdjournal"
+	"github.com/coreos/go-systemd/v22/sdjournal"
 	"github.com/segmentio/ecs-logs-go"
 	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/transform"
 )
 
-func NewReader() (r lib.Reader, err error) {
-	var j *sdjournal.Journal
+// Config represents the options of journald sources.
+type Config struct {
+	// The journal field that the stream names are read from, CONTAINER_NAME
+	// is used by default.
+	StreamName string `json:"stream_name"`
+
+	// The path to the file where the cursor of the last journal entry that was
+	// delivered is saved, if empty the reader starts where StartAt says.
+	StateFile string `json:"state_file"`
+
+	// Journal fields copied to the event data, mapped to the keys they are
+	// copied to (nested objects are separated by dots).
+	Fields map[string]string `json:"fields"`
+
+	// Matches restricting the entries that are read, written FIELD=value like
+	// with journalctl. Matches on the same field are alternatives, matches on
+	// different fields must all be satisfied, and "+" separates sets of
+	// matches of which at least one must be satisfied.
+	Matches []string `json:"matches"`
+
+	// Templates building the group and stream of messages from journal
+	// fields, see Template. Entries that don't have the fields are skipped,
+	// the group defaults to {CONTAINER_TAG} and the stream to the field set
+	// by StreamName.
+	Group  string `json:"group"`
+	Stream string `json:"stream"`
+
+	// The journal to read, by default the local journal which includes the
+	// persistent journal in /var/log/journal and the volatile journal in
+	// /run/log/journal. Directory reads the journal files of a directory,
+	// for example where the journal of the host is mounted in a container,
+	// and Files reads a list of journal files (glob patterns are expanded).
+	// Namespace reads a journal namespace, which is looked up in Directory
+	// when it's set.
+	Directory string   `json:"directory"`
+	Files     []string `json:"files"`
+	Namespace string   `json:"namespace"`
+
+	// Where to start reading when there is no saved cursor, either "end" (the
+	// default) or "beginning".
+	StartAt string `json:"start_at"`
+}
 
-	if j, err = sdjournal.NewJournal(); err != nil {
-		return
+func (c Config) validate() error {
+	for _, m := range c.Matches {
+		if m != "+" && strings.IndexByte(m, '=') <= 0 {
+			return fmt.Errorf("invalid journal match, expected FIELD=value or '+': %s", m)
+		}
 	}
 
-	if err = j.SeekTail(); err != nil {
-		j.Close()
+	if len(c.Files) != 0 && (len(c.Directory) != 0 || len(c.Namespace) != 0) {
+		return errors.New("files cannot be used with directory or namespace")
+	}
+
+	if len(c.Namespace) != 0 && strings.ContainsAny(c.Namespace, "/*?[") {
+		return fmt.Errorf("invalid journal namespace: %s", c.Namespace)
+	}
+
+	switch c.StartAt {
+	case "", "end", "beginning":
+	default:
+		return errors.New("start_at must be one of 'end' or 'beginning'")
+	}
+
+	if len(c.Stream) != 0 && len(c.StreamName) != 0 {
+		return errors.New("stream_name and stream cannot be used together")
+	}
+
+	for _, t := range []string{c.Group, c.Stream} {
+		if _, err := ParseTemplate(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func NewReader() (lib.Reader, error) {
+	return OpenReader(Config{
+		StreamName: os.Getenv("JOURNALD_STREAM_NAME"),
+		StateFile:  os.Getenv("JOURNALD_STATE_FILE"),
+		Fields:     parseFields(os.Getenv("JOURNALD_FIELDS")),
+		Matches:    parseList(os.Getenv("JOURNALD_MATCHES")),
+		Group:      os.Getenv("JOURNALD_GROUP"),
+		Stream:     os.Getenv("JOURNALD_STREAM"),
+		Directory:  os.Getenv("JOURNALD_DIRECTORY"),
+		Files:      parseList(os.Getenv("JOURNALD_FILES")),
+		Namespace:  os.Getenv("JOURNALD_NAMESPACE"),
+		StartAt:    os.Getenv("JOURNALD_START_AT"),
+	})
+}
+
+// parseFields parses a comma separated list of journal fields, each field may
+// be followed by '=' and the key it's copied to, the key defaults to the field.
+func parseFields(s string) map[string]string {
+	fields := make(map[string]string)
+
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); len(f) == 0 {
+			continue
+		}
+
+		if i := strings.IndexByte(f, '='); i >= 0 {
+			fields[f[:i]] = f[i+1:]
+		} else {
+			fields[f] = f
+		}
+	}
+
+	return fields
+}
+
+// parseList parses a comma separated list.
+func parseList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+func OpenReader(config Config) (r lib.Reader, err error) {
+	var j *sdjournal.Journal
+	var cursor string
+	var checkpoint *lib.Checkpoint
+
+	if err = config.validate(); err != nil {
 		return
 	}
 
 	var streamName string
-	if streamName = os.Getenv("JOURNALD_STREAM_NAME"); len(streamName) == 0 {
+	if streamName = config.StreamName; len(streamName) == 0 {
 		streamName = "CONTAINER_NAME"
 	}
 
-	r = &reader{Journal: j, streamName: streamName}
+	group, stream := config.Group, config.Stream
+
+	if len(group) == 0 {
+		group = "{CONTAINER_TAG}"
+	}
+
+	if len(stream) == 0 {
+		stream = "{" + streamName + "|CONTAINER_NAME}"
+	}
+
+	rd := &reader{config: config, fields: config.Fields, docker: len(config.Group) == 0 && len(config.Stream) == 0}
+	rd.group, _ = ParseTemplate(group)
+	rd.stream, _ = ParseTemplate(stream)
+
+	if j, rd.files, err = openJournal(config); err != nil {
+		return
+	}
+
+	stateFile := config.StateFile
+
+	if len(stateFile) != 0 {
+		if cursor, err = readCursor(stateFile); err != nil {
+			j.Close()
+			return
+		}
+
+		checkpoint = lib.NewCheckpoint(func(cursor string) error {
+			return writeCursor(stateFile, cursor)
+		})
+	}
+
+	if err = seek(j, cursor, config.StartAt); err != nil {
+		j.Close()
+		return
+	}
+
+	rd.Journal, rd.checkpoint = j, checkpoint
+	r = rd
 	return
 }
 
+// seek positions the journal after cursor, or where startAt says when the
+// cursor is empty.
+func seek(j *sdjournal.Journal, cursor string, startAt string) error {
+	switch {
+	case len(cursor) != 0:
+		return seekCursor(j, cursor)
+	case startAt == "beginning":
+		return j.SeekHead()
+	default:
+		return j.SeekTail()
+	}
+}
+
 type reader struct {
-	streamName string
+	config     Config
+	files      []string
+	group      Template
+	stream     Template
+	docker     bool
+	fields     map[string]string
+	checkpoint *lib.Checkpoint
 	stopped    int32
 	*sdjournal.Journal
 }
@@ -51,7 +228,7 @@ func (r *reader) Close() (err error) {
 
 func (r *reader) ReadMessage() (msg lib.Message, err error) {
 	for atomic.LoadInt32(&r.stopped) == 0 {
-		var cur int
+		var cur uint64
 		var ok bool
 
 		if cur, err = r.Next(); err != nil {
@@ -59,13 +236,33 @@ func (r *reader) ReadMessage() (msg lib.Message, err error) {
 		}
 
 		if cur == 0 {
+			if err = r.reopen(); err != nil {
+				return
+			}
+
 			r.Wait(1 * time.Second)
 			continue
 		}
 
-		if msg, ok, err = r.getMessage(); ok || err != nil {
+		if msg, ok, err = r.getMessage(); err != nil {
 			return
 		}
+
+		if !ok {
+			continue
+		}
+
+		if r.checkpoint != nil {
+			var cursor string
+
+			if cursor, err = r.GetCursor(); err != nil {
+				return
+			}
+
+			msg.Position = r.checkpoint.Track(cursor)
+		}
+
+		return
 	}
 
 	r.Journal.Close()
@@ -73,26 +270,74 @@ func (r *reader) ReadMessage() (msg lib.Message, err error) {
 	return
 }
 
-func (r *reader) getMessage() (msg lib.Message, ok bool, err error) {
-	if msg.Group, err = r.GetDataValue("CONTAINER_TAG"); len(msg.Group) == 0 {
-		// No CONTAINER_TAG, this must be a journal message from a process that
-		// isn't running in a docker container.
+// reopen opens the journal again when it was read from files and the list of
+// files changed, sd-journal doesn't pick up the files that journald creates
+// when it rotates them in this case. The new journal resumes after the last
+// entry that was read.
+func (r *reader) reopen() (err error) {
+	var j *sdjournal.Journal
+	var files []string
+	var cursor string
+
+	if len(r.files) == 0 {
+		return
+	}
+
+	if files, err = journalFiles(r.config); err != nil || sameFiles(files, r.files) {
+		// The files may be missing for a moment while journald rotates
+		// them, the current journal is kept until the next attempt.
 		err = nil
 		return
 	}
 
-	if msg.Stream, err = r.GetDataValue(r.streamName); err != nil {
-		// Fallback to CONTAINER_NAME
-		if msg.Stream, err = r.GetDataValue("CONTAINER_NAME"); err != nil {
+	cursor, _ = r.GetCursor()
 
-			// There's a CONTAINER_TAG but no CONTAINER_NAME, something is seriously
-			// wrong here, the log docker log driver is misbehaving.
-			err = fmt.Errorf("missing CONTAINER_NAME in message with CONTAINER_TAG=%s", msg.Group)
+	if j, files, err = openJournal(r.config); err != nil {
+		return
+	}
 
-			return
+	if err = seek(j, cursor, r.config.StartAt); err != nil {
+		j.Close()
+		return
+	}
+
+	r.Journal.Close()
+	r.Journal, r.files = j, files
+	return
+}
+
+func sameFiles(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
 	}
 
+	return true
+}
+
+func (r *reader) getMessage() (msg lib.Message, ok bool, err error) {
+	if msg.Group, ok = r.group.Expand(r.getString); !ok {
+		// With the default template there's no CONTAINER_TAG, this must be a
+		// journal message from a process that isn't running in a docker
+		// container.
+		return
+	}
+
+	if msg.Stream, ok = r.stream.Expand(r.getString); !ok {
+		if r.docker {
+			// There's a CONTAINER_TAG but no CONTAINER_NAME, something is
+			// seriously wrong here, the log docker log driver is misbehaving.
+			err = fmt.Errorf("missing CONTAINER_NAME in message with CONTAINER_TAG=%s", msg.Group)
+		}
+
+		return
+	}
+
 	msg.Stream = sanitizeStreamName(msg.Stream)
 
 	if s := r.getString("MESSAGE"); len(s) != 0 {
@@ -101,6 +346,7 @@ func (r *reader) getMessage() (msg lib.Message, ok bool, err error) {
 
 		if d.Decode(&msg.Event) != nil {
 			msg.Event.Message = s
+			msg.PlainText = true
 		}
 	}
 
@@ -140,6 +386,15 @@ func (r *reader) getMessage() (msg lib.Message, ok bool, err error) {
 		msg.Event.Time = r.getTime()
 	}
 
+	for field, key := range r.fields {
+		if v, e := r.GetDataValue(field); e == nil {
+			if msg.Event.Data == nil {
+				msg.Event.Data = ecslogs.EventData{}
+			}
+			transform.Set(msg.Event.Data, key, v)
+		}
+	}
+
 	ok = true
 	return
 }
@@ -0,0 +1,84 @@
+package journald
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Template builds a group or stream name from journal fields, fields are
+// written between braces and may list alternatives separated by '|', the first
+// field that is set is used. For example "systemd/{UNIT|_SYSTEMD_UNIT}".
+type Template struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	text   string
+	fields []string
+}
+
+func ParseTemplate(s string) (t Template, err error) {
+	for len(s) != 0 {
+		i := strings.IndexByte(s, '{')
+
+		if i < 0 {
+			t.parts = append(t.parts, templatePart{text: s})
+			break
+		}
+
+		if i != 0 {
+			t.parts = append(t.parts, templatePart{text: s[:i]})
+		}
+
+		j := strings.IndexByte(s[i:], '}')
+
+		if j < 0 {
+			err = fmt.Errorf("invalid template, missing '}': %s", s)
+			return
+		}
+
+		var fields []string
+
+		for _, f := range strings.Split(s[i+1:i+j], "|") {
+			if f = strings.TrimSpace(f); len(f) == 0 {
+				err = fmt.Errorf("invalid template, empty field name: %s", s)
+				return
+			}
+			fields = append(fields, f)
+		}
+
+		t.parts = append(t.parts, templatePart{fields: fields})
+		s = s[i+j+1:]
+	}
+
+	return
+}
+
+// Expand returns the value of the template for the fields returned by get, it
+// returns false if none of the alternatives of a placeholder are set.
+func (t Template) Expand(get func(string) string) (string, bool) {
+	var b strings.Builder
+
+	for _, p := range t.parts {
+		if len(p.fields) == 0 {
+			b.WriteString(p.text)
+			continue
+		}
+
+		var v string
+
+		for _, f := range p.fields {
+			if v = get(f); len(v) != 0 {
+				break
+			}
+		}
+
+		if len(v) == 0 {
+			return "", false
+		}
+
+		b.WriteString(v)
+	}
+
+	return b.String(), true
+}
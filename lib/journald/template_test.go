@@ -0,0 +1,46 @@
+package journald
+
+import "testing"
+
+func TestTemplate(t *testing.T) {
+	fields := map[string]string{
+		"CONTAINER_TAG":     "web",
+		"_SYSTEMD_UNIT":     "docker.service",
+		"SYSLOG_IDENTIFIER": "kernel",
+	}
+
+	get := func(f string) string { return fields[f] }
+
+	tests := []struct {
+		template string
+		value    string
+		ok       bool
+	}{
+		{"{CONTAINER_TAG}", "web", true},
+		{"systemd/{_SYSTEMD_UNIT}", "systemd/docker.service", true},
+		{"{CONTAINER_NAME|_SYSTEMD_UNIT|SYSLOG_IDENTIFIER}", "docker.service", true},
+		{"{ CONTAINER_NAME | SYSLOG_IDENTIFIER }-{CONTAINER_TAG}", "kernel-web", true},
+		{"host", "host", true},
+		{"{CONTAINER_NAME}", "", false},
+		{"a/{CONTAINER_TAG}/{UNIT}", "", false},
+	}
+
+	for _, test := range tests {
+		tpl, err := ParseTemplate(test.template)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.template, err)
+			continue
+		}
+
+		if value, ok := tpl.Expand(get); value != test.value || ok != test.ok {
+			t.Errorf("%s: expected %q (%t) but found %q (%t)", test.template, test.value, test.ok, value, ok)
+		}
+	}
+
+	for _, s := range []string{"{CONTAINER_TAG", "{}", "{A||B}"} {
+		if _, err := ParseTemplate(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package kinesis
+
+import "github.com/segmentio/ecs-logs/lib"
+
+func init() {
+	lib.RegisterDestination("kinesis", &envDestination{load: func() (lib.Destination, error) {
+		return NewKinesisDestination(kinesisConfigFromEnv())
+	}})
+	lib.RegisterDestinationFactory("kinesis", func(opts lib.Options) (lib.Destination, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		return NewKinesisDestination(c)
+	})
+
+	lib.RegisterDestination("firehose", &envDestination{load: func() (lib.Destination, error) {
+		return NewFirehoseDestination(firehoseConfigFromEnv())
+	}})
+	lib.RegisterDestinationFactory("firehose", func(opts lib.Options) (lib.Destination, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		return NewFirehoseDestination(c)
+	})
+}
@@ -0,0 +1,96 @@
+package kinesis
+
+import (
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// limits are the constraints of the batch APIs of a service.
+type limits struct {
+	// The maximum size of a record, including its partition key.
+	recordSize int
+
+	// The maximum number of records and size of a batch request.
+	batchRecords int
+	batchSize    int
+}
+
+var (
+	kinesisLimits = limits{
+		recordSize:   1024 * 1024,
+		batchRecords: 500,
+		batchSize:    5 * 1024 * 1024,
+	}
+
+	firehoseLimits = limits{
+		recordSize:   1000 * 1024,
+		batchRecords: 500,
+		batchSize:    4 * 1024 * 1024,
+	}
+)
+
+// A record holds one message, or newline separated messages that have the same
+// partition key when records are aggregated.
+type record struct {
+	key   string
+	data  []byte
+	batch lib.MessageBatch
+}
+
+func (r *record) size() int {
+	return len(r.key) + len(r.data)
+}
+
+// makeRecords returns the records of the messages in batch, messages that are
+// too large to fit in a record are returned in rejected.
+func makeRecords(batch lib.MessageBatch, key func(lib.Message) string, aggregate bool, l limits) (records []*record, rejected lib.MessageBatch) {
+	open := make(map[string]*record)
+
+	for _, msg := range batch {
+		k := key(msg)
+		b := append(msg.Bytes(), '\n')
+
+		if len(k)+len(b) > l.recordSize {
+			rejected = append(rejected, msg)
+			continue
+		}
+
+		if r := open[k]; aggregate && r != nil && r.size()+len(b) <= l.recordSize {
+			r.data = append(r.data, b...)
+			r.batch = append(r.batch, msg)
+			continue
+		}
+
+		r := &record{key: k, data: b, batch: lib.MessageBatch{msg}}
+		records = append(records, r)
+		open[k] = r
+	}
+
+	return
+}
+
+// splitRecords splits records in groups that fit in a single batch request.
+func splitRecords(records []*record, l limits) (groups [][]*record) {
+	var size int
+	var i int
+
+	for j, r := range records {
+		if j != i && (j-i == l.batchRecords || size+r.size() > l.batchSize) {
+			groups = append(groups, records[i:j])
+			i, size = j, 0
+		}
+		size += r.size()
+	}
+
+	if i != len(records) {
+		groups = append(groups, records[i:])
+	}
+
+	return
+}
+
+func messages(records []*record) (batch lib.MessageBatch) {
+	for _, r := range records {
+		batch = append(batch, r.batch...)
+	}
+	return
+}
@@ -0,0 +1,339 @@
+package kinesis
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/awsutil"
+)
+
+const (
+	DefaultPartitionKey = "{group}/{stream}"
+
+	// Kinesis only accepts partition keys of 1 to 256 unicode characters,
+	// empty keys are replaced by emptyPartitionKey.
+	maxPartitionKeyLength = 256
+	emptyPartitionKey     = "-"
+)
+
+// Config represents the options of kinesis and firehose destinations.
+type Config struct {
+	// The name of the kinesis stream, or of the firehose delivery stream.
+	Stream string `json:"stream"`
+
+	// The region of the stream, it's detected from the environment when it's
+	// not set, and the URL of a compatible service to use instead of AWS.
+	Region   string `json:"region"`
+	Endpoint string `json:"endpoint"`
+
+	// The partition key of kinesis records where {group} and {stream} are
+	// replaced by the group and stream of messages.
+	PartitionKey string `json:"partition_key"`
+
+	// Put messages that have the same partition key in the same records,
+	// separated by newlines, instead of sending one record per message.
+	Aggregate bool `json:"aggregate"`
+}
+
+func (c Config) validate() error {
+	if len(c.Stream) == 0 {
+		return errors.New("missing stream name")
+	}
+	return nil
+}
+
+func kinesisConfigFromEnv() Config {
+	return Config{
+		Stream:       os.Getenv("KINESIS_STREAM"),
+		Endpoint:     os.Getenv("KINESIS_ENDPOINT"),
+		PartitionKey: os.Getenv("KINESIS_PARTITION_KEY"),
+		Aggregate:    os.Getenv("KINESIS_AGGREGATE") == "true",
+	}
+}
+
+func firehoseConfigFromEnv() Config {
+	return Config{
+		Stream:    os.Getenv("FIREHOSE_DELIVERY_STREAM"),
+		Endpoint:  os.Getenv("FIREHOSE_ENDPOINT"),
+		Aggregate: os.Getenv("FIREHOSE_AGGREGATE") == "true",
+	}
+}
+
+// NewKinesisDestination returns a destination which puts messages to the
+// kinesis data stream configured by config, errors in the configuration are
+// reported immediately.
+func NewKinesisDestination(config Config) (lib.Destination, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(config.PartitionKey) == 0 {
+		config.PartitionKey = DefaultPartitionKey
+	}
+
+	return &destination{
+		config: config,
+		limits: kinesisLimits,
+		key: func(msg lib.Message) string {
+			return partitionKey(config.PartitionKey, msg.Group, msg.Stream)
+		},
+		open: func(sess *session.Session) service {
+			return kinesisService{client: kinesis.New(sess), stream: config.Stream}
+		},
+	}, nil
+}
+
+// NewFirehoseDestination returns a destination which puts messages to the
+// firehose delivery stream configured by config, errors in the configuration
+// are reported immediately.
+func NewFirehoseDestination(config Config) (lib.Destination, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &destination{
+		config: config,
+		limits: firehoseLimits,
+		key:    func(lib.Message) string { return "" },
+		open: func(sess *session.Session) service {
+			return firehoseService{client: firehose.New(sess), stream: config.Stream}
+		},
+	}, nil
+}
+
+// partitionKey expands the partition key template, kinesis requires keys of 1
+// to 256 unicode characters so longer keys are truncated and empty keys are
+// replaced by emptyPartitionKey.
+func partitionKey(template string, group string, stream string) string {
+	key := strings.NewReplacer("{group}", group, "{stream}", stream).Replace(template)
+
+	if len(key) == 0 {
+		return emptyPartitionKey
+	}
+
+	n := 0
+
+	for i := range key {
+		if n == maxPartitionKeyLength {
+			return key[:i]
+		}
+		n++
+	}
+
+	return key
+}
+
+// envDestination lazily creates the destination configured by the environment
+// so the configuration is only validated when it's used.
+type envDestination struct {
+	once sync.Once
+	load func() (lib.Destination, error)
+	dest lib.Destination
+	err  error
+}
+
+func (d *envDestination) Open(group string, stream string) (lib.Writer, error) {
+	d.once.Do(func() {
+		// Configuration errors won't go away by retrying the write operation.
+		if d.dest, d.err = d.load(); d.err != nil {
+			d.err = lib.PermanentError(d.err)
+		}
+	})
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return d.dest.Open(group, stream)
+}
+
+func (d *envDestination) Close(group string, stream string) {}
+
+// service abstracts the batch APIs of kinesis and firehose, put returns the
+// records that failed and the reason of the first failure.
+type service interface {
+	put(records []*record) (failed []*record, reason string, err error)
+}
+
+type destination struct {
+	config Config
+	limits limits
+	key    func(lib.Message) string
+	open   func(*session.Session) service
+
+	mutex   sync.Mutex
+	service service
+}
+
+func (d *destination) Open(group string, stream string) (lib.Writer, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.service == nil {
+		sess, err := awsutil.NewSession(d.config.Region, d.config.Endpoint)
+
+		if err != nil {
+			return nil, err
+		}
+
+		d.service = d.open(sess)
+	}
+
+	return &writer{dest: d, service: d.service}, nil
+}
+
+func (d *destination) Close(group string, stream string) {}
+
+type writer struct {
+	dest    *destination
+	service service
+}
+
+func (w *writer) Close() error {
+	return nil
+}
+
+func (w *writer) WriteMessage(msg lib.Message) error {
+	return w.WriteMessageBatch(lib.MessageBatch{msg})
+}
+
+func (w *writer) WriteMessageBatch(batch lib.MessageBatch) (err error) {
+	var failed []*record
+	var reason string
+
+	records, rejected := makeRecords(batch, w.dest.key, w.dest.config.Aggregate, w.dest.limits)
+	groups := splitRecords(records, w.dest.limits)
+
+	for i, group := range groups {
+		f, r, e := w.service.put(group)
+
+		if e != nil {
+			if isPermanent(e) {
+				e = lib.PermanentError(e)
+			}
+			// The records of the requests that weren't made have failed as
+			// well.
+			for _, g := range groups[i:] {
+				failed = append(failed, g...)
+			}
+
+			if len(rejected) != 0 {
+				e = fmt.Errorf("%w, and %s", e, tooLarge(rejected, w.dest.limits))
+			}
+
+			return lib.PartialError(e, messages(failed))
+		}
+
+		if len(reason) == 0 {
+			reason = r
+		}
+
+		failed = append(failed, f...)
+	}
+
+	switch {
+	case len(failed) != 0:
+		err = fmt.Errorf("%d of %d records failed (%s)", len(failed), len(records), reason)
+
+		if len(rejected) != 0 {
+			err = fmt.Errorf("%w, and %s", err, tooLarge(rejected, w.dest.limits))
+		}
+
+		err = lib.PartialError(err, messages(failed))
+
+	case len(rejected) != 0:
+		err = lib.PermanentError(errors.New(tooLarge(rejected, w.dest.limits)))
+	}
+
+	return
+}
+
+func tooLarge(rejected lib.MessageBatch, l limits) string {
+	return fmt.Sprintf("%d messages are larger than the maximum record size of %d bytes", len(rejected), l.recordSize)
+}
+
+type kinesisService struct {
+	client *kinesis.Kinesis
+	stream string
+}
+
+func (s kinesisService) put(records []*record) (failed []*record, reason string, err error) {
+	var res *kinesis.PutRecordsOutput
+	var entries = make([]*kinesis.PutRecordsRequestEntry, len(records))
+
+	for i, r := range records {
+		entries[i] = &kinesis.PutRecordsRequestEntry{
+			Data:         r.data,
+			PartitionKey: aws.String(r.key),
+		}
+	}
+
+	if res, err = s.client.PutRecords(&kinesis.PutRecordsInput{
+		Records:    entries,
+		StreamName: aws.String(s.stream),
+	}); err != nil {
+		return
+	}
+
+	for i, e := range res.Records {
+		if e.ErrorCode != nil && i < len(records) {
+			if failed = append(failed, records[i]); len(reason) == 0 {
+				reason = aws.StringValue(e.ErrorCode) + ": " + aws.StringValue(e.ErrorMessage)
+			}
+		}
+	}
+
+	return
+}
+
+type firehoseService struct {
+	client *firehose.Firehose
+	stream string
+}
+
+func (s firehoseService) put(records []*record) (failed []*record, reason string, err error) {
+	var res *firehose.PutRecordBatchOutput
+	var entries = make([]*firehose.Record, len(records))
+
+	for i, r := range records {
+		entries[i] = &firehose.Record{Data: r.data}
+	}
+
+	if res, err = s.client.PutRecordBatch(&firehose.PutRecordBatchInput{
+		DeliveryStreamName: aws.String(s.stream),
+		Records:            entries,
+	}); err != nil {
+		return
+	}
+
+	for i, e := range res.RequestResponses {
+		if e.ErrorCode != nil && i < len(records) {
+			if failed = append(failed, records[i]); len(reason) == 0 {
+				reason = aws.StringValue(e.ErrorCode) + ": " + aws.StringValue(e.ErrorMessage)
+			}
+		}
+	}
+
+	return
+}
+
+// isPermanent returns true if err is an AWS error that won't go away when the
+// request is retried.
+func isPermanent(err error) bool {
+	if err, ok := err.(awserr.Error); ok {
+		switch err.Code() {
+		case "ResourceNotFoundException", "InvalidArgumentException", "AccessDeniedException", "ValidationException":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func init() {
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+}
+
+func testBatch(group string, messages ...string) (batch lib.MessageBatch) {
+	for _, m := range messages {
+		batch = append(batch, lib.Message{
+			Group:  group,
+			Stream: "1",
+			Event:  ecslogs.Event{Level: ecslogs.INFO, Message: m},
+		})
+	}
+	return
+}
+
+func TestMakeRecords(t *testing.T) {
+	key := func(msg lib.Message) string { return msg.Group }
+	size := len(testBatch("a", "1")[0].Bytes()) + 1
+	l := limits{recordSize: 1 + 2*size}
+
+	batch := append(testBatch("a", "1", "2", "3"), testBatch("b", "4")...)
+	batch = append(batch, testBatch("a", strings.Repeat("5", 2*size))...)
+
+	records, rejected := makeRecords(batch, key, true, l)
+
+	if len(rejected) != 1 || rejected[0].Event.Message[0] != '5' {
+		t.Errorf("invalid rejected messages: %v", rejected)
+	}
+
+	// The first record of a is full after two messages.
+	counts := []int{2, 1, 1}
+	keys := []string{"a", "a", "b"}
+
+	if len(records) != len(counts) {
+		t.Fatalf("invalid number of records: %d", len(records))
+	}
+
+	for i, r := range records {
+		if r.key != keys[i] || len(r.batch) != counts[i] || strings.Count(string(r.data), "\n") != counts[i] {
+			t.Errorf("invalid record %d: %s %q", i, r.key, r.data)
+		}
+	}
+
+	if records, _ = makeRecords(batch, key, false, l); len(records) != 4 {
+		t.Errorf("messages should not be aggregated: %d records", len(records))
+	}
+}
+
+func TestPartitionKey(t *testing.T) {
+	tests := []struct {
+		template string
+		group    string
+		key      string
+	}{
+		{"{group}/{stream}", "web", "web/1"},
+		{"{group}", "", "-"},
+		{"{group}", strings.Repeat("é", 300), strings.Repeat("é", 256)},
+	}
+
+	for _, test := range tests {
+		if key := partitionKey(test.template, test.group, "1"); key != test.key {
+			t.Errorf("%s: invalid partition key: %q", test.template, key)
+		}
+	}
+}
+
+func TestSplitRecords(t *testing.T) {
+	var records []*record
+
+	for i := 0; i != 7; i++ {
+		records = append(records, &record{key: "k", data: []byte("123456789")})
+	}
+
+	tests := []struct {
+		limits limits
+		sizes  []int
+	}{
+		{limits{batchRecords: 3, batchSize: 1000}, []int{3, 3, 1}},
+		{limits{batchRecords: 500, batchSize: 20}, []int{2, 2, 2, 1}},
+		{limits{batchRecords: 500, batchSize: 5}, []int{1, 1, 1, 1, 1, 1, 1}},
+	}
+
+	for _, test := range tests {
+		groups := splitRecords(records, test.limits)
+		sizes := make([]int, len(groups))
+
+		for i, g := range groups {
+			sizes[i] = len(g)
+		}
+
+		if fmt.Sprint(sizes) != fmt.Sprint(test.sizes) {
+			t.Errorf("%+v: invalid groups: %v", test.limits, sizes)
+		}
+	}
+}
+
+// server is a stand-in for the kinesis and firehose APIs, the records which
+// data contains "fail" are rejected.
+type server struct {
+	t       *testing.T
+	target  string
+	calls   int
+	records []string
+	status  int
+}
+
+func (s *server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	var body struct {
+		StreamName         string
+		DeliveryStreamName string
+		Records            []struct {
+			Data         []byte
+			PartitionKey string
+		}
+	}
+
+	s.calls++
+	target := req.Header.Get("X-Amz-Target")
+
+	if target != s.target {
+		s.t.Errorf("invalid target: %s", target)
+	}
+
+	if s.status != 0 {
+		res.WriteHeader(s.status)
+		fmt.Fprint(res, `{"__type":"ResourceNotFoundException","message":"stream not found"}`)
+		return
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		s.t.Error(err)
+		return
+	}
+
+	var results []string
+	var failed int
+
+	for _, r := range body.Records {
+		if strings.Contains(string(r.Data), "fail") {
+			failed++
+			results = append(results, `{"ErrorCode":"ProvisionedThroughputExceededException","ErrorMessage":"slow down"}`)
+			continue
+		}
+
+		s.records = append(s.records, r.PartitionKey+" "+strings.TrimSpace(string(r.Data)))
+
+		if len(body.StreamName) != 0 {
+			results = append(results, `{"SequenceNumber":"1","ShardId":"shardId-000000000000"}`)
+		} else {
+			results = append(results, `{"RecordId":"1"}`)
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	if len(body.StreamName) != 0 {
+		fmt.Fprintf(res, `{"FailedRecordCount":%d,"Records":[%s]}`, failed, strings.Join(results, ","))
+	} else {
+		fmt.Fprintf(res, `{"FailedPutCount":%d,"RequestResponses":[%s]}`, failed, strings.Join(results, ","))
+	}
+}
+
+func TestKinesisWriteMessageBatch(t *testing.T) {
+	s := &server{t: t, target: "Kinesis_20131202.PutRecords"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	dest, _ := NewKinesisDestination(Config{Stream: "logs", Region: "us-east-1", Endpoint: srv.URL})
+	w, err := dest.Open("web", "1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.WriteMessageBatch(testBatch("web", "1", "fail", "3"))
+
+	if failed, ok := lib.FailedMessages(err); !ok || len(failed) != 1 || failed[0].Event.Message != "fail" {
+		t.Errorf("only the failed record should be written again: %v", err)
+	}
+
+	if lib.IsPermanentError(err) {
+		t.Errorf("throttled records should be retried: %v", err)
+	}
+
+	if len(s.records) != 2 || !strings.HasPrefix(s.records[0], "web/1 ") {
+		t.Errorf("invalid records: %v", s.records)
+	}
+}
+
+func TestFirehoseWriteMessageBatch(t *testing.T) {
+	s := &server{t: t, target: "Firehose_20150804.PutRecordBatch"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	dest, _ := NewFirehoseDestination(Config{Stream: "logs", Region: "us-east-1", Endpoint: srv.URL, Aggregate: true})
+	w, _ := dest.Open("web", "1")
+
+	if err := w.WriteMessageBatch(testBatch("web", "1", "2", "3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.calls != 1 || len(s.records) != 1 || strings.Count(s.records[0], "\n") != 2 {
+		t.Errorf("the messages should have been aggregated in a single record: %q", s.records)
+	}
+}
+
+func TestKinesisWriteMessageBatchMissingStream(t *testing.T) {
+	s := &server{t: t, target: "Kinesis_20131202.PutRecords", status: http.StatusBadRequest}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	dest, _ := NewKinesisDestination(Config{Stream: "logs", Region: "us-east-1", Endpoint: srv.URL})
+	w, _ := dest.Open("web", "1")
+
+	if err := w.WriteMessageBatch(testBatch("web", "1")); !lib.IsPermanentError(err) {
+		t.Errorf("a missing stream should be a permanent error: %v", err)
+	}
+}
+
+func TestKinesisWriteMessageBatchTooLarge(t *testing.T) {
+	s := &server{t: t, target: "Kinesis_20131202.PutRecords"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	dest, _ := NewKinesisDestination(Config{Stream: "logs", Region: "us-east-1", Endpoint: srv.URL})
+	w, _ := dest.Open("web", "1")
+
+	large := strings.Repeat("A", kinesisLimits.recordSize)
+	err := w.WriteMessageBatch(testBatch("web", large))
+
+	if !lib.IsPermanentError(err) {
+		t.Errorf("messages larger than a record should be a permanent error: %v", err)
+	}
+
+	// The rejected messages are reported along with the records that failed.
+	err = w.WriteMessageBatch(testBatch("web", "fail", large))
+
+	if failed, ok := lib.FailedMessages(err); !ok || len(failed) != 1 || failed[0].Event.Message != "fail" {
+		t.Errorf("only the failed record should be written again: %v", err)
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "1 messages are larger") {
+		t.Errorf("the rejected messages should be mentioned in the error: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package lib
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/apex/log"
+)
+
+// The size of the buffer that packets are read into, which fits the largest
+// UDP datagrams.
+const maxPacketBytes = 65536
+
+// A Listener implements the Reader interface for sources that receive messages
+// from network clients. It accepts the connections or reads the packets sent
+// to its address, and closes the connections that are still open when it's
+// closed.
+//
+// Listeners are safe to use concurrently from multiple goroutines.
+type Listener struct {
+	name       string
+	packetConn net.PacketConn
+	listener   net.Listener
+	messages   chan Message
+	done       chan struct{}
+	once       sync.Once
+	join       sync.WaitGroup
+	mutex      sync.Mutex
+	conns      map[net.Conn]struct{}
+}
+
+// NewListener returns a listener for the protocol called name, which is used
+// in the log messages.
+func NewListener(name string) *Listener {
+	return &Listener{
+		name:     name,
+		messages: make(chan Message, 100),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen starts accepting connections on address, serve is called in a new
+// goroutine for each connection with the host of the client, the connection is
+// closed when it returns. Connections use TLS when config isn't nil.
+func (l *Listener) Listen(network string, address string, config *tls.Config, serve func(conn net.Conn, from string)) (err error) {
+	if network == "unix" {
+		removeSocket(address)
+	}
+
+	if config != nil {
+		l.listener, err = tls.Listen(network, address, config)
+	} else {
+		l.listener, err = net.Listen(network, address)
+	}
+
+	if err != nil {
+		return
+	}
+
+	l.join.Add(1)
+	go l.accept(serve)
+	return
+}
+
+// ListenPacket starts reading the packets sent to address, handle is called
+// with each packet and the host of the client and returns false to stop
+// reading.
+func (l *Listener) ListenPacket(network string, address string, handle func(b []byte, from string) bool) (err error) {
+	if network == "unixgram" {
+		removeSocket(address)
+	}
+
+	if l.packetConn, err = net.ListenPacket(network, address); err != nil {
+		return
+	}
+
+	l.join.Add(1)
+	go l.readPackets(handle)
+	return
+}
+
+// removeSocket removes a unix socket left behind by a previous process, which
+// would prevent from listening on the same path.
+func removeSocket(path string) {
+	if s, err := os.Stat(path); err == nil && (s.Mode()&os.ModeSocket) != 0 {
+		os.Remove(path)
+	}
+}
+
+// Addr returns the address that the listener receives messages on.
+func (l *Listener) Addr() net.Addr {
+	if l.listener != nil {
+		return l.listener.Addr()
+	}
+	return l.packetConn.LocalAddr()
+}
+
+func (l *Listener) Close() (err error) {
+	l.once.Do(func() {
+		close(l.done)
+
+		if l.packetConn != nil {
+			err = l.packetConn.Close()
+		}
+
+		if l.listener != nil {
+			err = l.listener.Close()
+		}
+
+		l.mutex.Lock()
+		for conn := range l.conns {
+			conn.Close()
+		}
+		l.mutex.Unlock()
+	})
+	return
+}
+
+func (l *Listener) ReadMessage() (msg Message, err error) {
+	select {
+	case msg = <-l.messages:
+	case <-l.done:
+		l.join.Wait()
+		err = io.EOF
+	}
+	return
+}
+
+// Send passes msg to ReadMessage, it returns false if the listener was closed.
+func (l *Listener) Send(msg Message) bool {
+	select {
+	case l.messages <- msg:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// Closed returns true if the listener was closed, errors reported by the
+// connections after that are expected.
+func (l *Listener) Closed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *Listener) readPackets(handle func(b []byte, from string) bool) {
+	defer l.join.Done()
+	b := make([]byte, maxPacketBytes)
+
+	for {
+		n, addr, err := l.packetConn.ReadFrom(b)
+
+		if err != nil {
+			if !l.Closed() {
+				log.WithError(err).Error("failed to read " + l.name + " message")
+			}
+			return
+		}
+
+		if !handle(b[:n], SenderHost(addr)) {
+			return
+		}
+	}
+}
+
+func (l *Listener) accept(serve func(conn net.Conn, from string)) {
+	defer l.join.Done()
+
+	for {
+		conn, err := l.listener.Accept()
+
+		if err != nil {
+			if !l.Closed() {
+				log.WithError(err).Error("failed to accept " + l.name + " connection")
+			}
+			return
+		}
+
+		l.mutex.Lock()
+
+		// Connections accepted while the listener was being closed wouldn't
+		// be closed with the others.
+		if l.Closed() {
+			l.mutex.Unlock()
+			conn.Close()
+			return
+		}
+
+		l.conns[conn] = struct{}{}
+		l.mutex.Unlock()
+
+		l.join.Add(1)
+		go l.serve(conn, serve)
+	}
+}
+
+func (l *Listener) serve(conn net.Conn, serve func(conn net.Conn, from string)) {
+	defer l.join.Done()
+	defer func() {
+		conn.Close()
+		l.mutex.Lock()
+		delete(l.conns, conn)
+		l.mutex.Unlock()
+	}()
+
+	serve(conn, SenderHost(conn.RemoteAddr()))
+}
+
+// SenderHost returns the host of a client address, or "local" for unix
+// sockets.
+func SenderHost(addr net.Addr) string {
+	if addr == nil || len(addr.String()) == 0 || addr.Network() == "unix" || addr.Network() == "unixgram" {
+		return "local"
+	}
+
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+
+	return addr.String()
+}
@@ -2,6 +2,7 @@ package logdna
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
@@ -13,7 +14,59 @@ import (
 	"github.com/segmentio/ecs-logs/lib/syslog"
 )
 
+// Config represents the options of logdna destinations, when the URL is not
+// set the default endpoint is used with the token.
+type Config struct {
+	URL        string `json:"url"`
+	Token      string `json:"token"`
+	Template   string `json:"template"`
+	TimeFormat string `json:"time_format"`
+	SocksProxy string `json:"socks_proxy"`
+}
+
+func configFromEnv() Config {
+	return Config{
+		URL:        os.Getenv("LOGDNA_URL"),
+		Token:      os.Getenv("LOGDNA_TOKEN"),
+		Template:   os.Getenv("LOGDNA_TEMPLATE"),
+		TimeFormat: os.Getenv("LOGDNA_TIME_FORMAT"),
+		SocksProxy: os.Getenv("SOCKS_PROXY"),
+	}
+}
+
 func NewWriter(group string, stream string) (w lib.Writer, err error) {
+	return newWriter(configFromEnv(), group, stream)
+}
+
+// NewDestination returns a destination which writes to the logdna endpoint
+// configured by config, errors in the configuration are reported immediately.
+func NewDestination(config Config) (lib.Destination, error) {
+	endpoint, err := getEndpoint(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, _, _, err = parseEndpoint(endpoint, "", ""); err != nil {
+		return nil, err
+	}
+
+	if err = syslog.ValidateTemplate(config.Template); err != nil {
+		return nil, err
+	}
+
+	if len(config.SocksProxy) != 0 {
+		if _, _, err = net.SplitHostPort(config.SocksProxy); err != nil {
+			return nil, fmt.Errorf("invalid socks proxy, %s: %s", err, config.SocksProxy)
+		}
+	}
+
+	return lib.DestinationFunc(func(group string, stream string) (lib.Writer, error) {
+		return newWriter(config, group, stream)
+	}), nil
+}
+
+func newWriter(config Config, group string, stream string) (w lib.Writer, err error) {
 	var endpoint string
 	var protocol string
 	var address string
@@ -23,29 +76,32 @@ func NewWriter(group string, stream string) (w lib.Writer, err error) {
 	var timeFormat string
 	var socksProxy string
 
-	if endpoint, err = getEndpoint(); err != nil {
+	// Configuration errors won't go away by retrying the write operation.
+	if endpoint, err = getEndpoint(config); err != nil {
+		err = lib.PermanentError(err)
 		return
 	}
 
 	if protocol, address, token, tags, err = parseEndpoint(endpoint, group, stream); err != nil {
+		err = lib.PermanentError(err)
 		return
 	}
 
-	if template = os.Getenv("LOGDNA_TEMPLATE"); len(template) == 0 {
+	if template = config.Template; len(template) == 0 {
 		template = "<{{.PRIVAL}}>1 {{.TIMESTAMP}} {{.HOSTNAME}} {{.GROUP}} {{.STREAM}} {{.MSGID}} [{{.TAG}}] {{.MSG}}"
 		if len(token) != 0 {
 			template = "<key:" + token + "> " + template
 		}
 	}
 
-	if timeFormat = os.Getenv("LOGDNA_TIME_FORMAT"); len(timeFormat) == 0 {
+	if timeFormat = config.TimeFormat; len(timeFormat) == 0 {
 		timeFormat = "2016-02-10T09:28:01.982-08:00"
 	}
 
-	if socksProxy = os.Getenv("SOCKS_PROXY"); len(socksProxy) > 0 {
+	if socksProxy = config.SocksProxy; len(socksProxy) > 0 {
 		if _, _, err = net.SplitHostPort(socksProxy); err != nil {
 			log.WithFields(log.Fields{
-				"SOCKS_PROXY": socksProxy,
+				"socks_proxy": socksProxy,
 			}).Warn("bad format, proxy setting will be ignored")
 			socksProxy = ""
 		}
@@ -64,19 +120,17 @@ func NewWriter(group string, stream string) (w lib.Writer, err error) {
 	})
 }
 
-func getEndpoint() (endpoint string, err error) {
-	var token string
-
-	if endpoint = os.Getenv("LOGDNA_URL"); len(endpoint) != 0 {
+func getEndpoint(config Config) (endpoint string, err error) {
+	if endpoint = config.URL; len(endpoint) != 0 {
 		return
 	}
 
-	if token = os.Getenv("LOGDNA_TOKEN"); len(token) != 0 {
-		endpoint = "tls://syslog-a.logdna.com:6514"
+	if token := config.Token; len(token) != 0 {
+		endpoint = (&url.URL{Scheme: "tls", User: url.User(token), Host: "syslog-a.logdna.com:6514"}).String()
 		return
 	}
 
-	err = fmt.Errorf("missing LOGDNA_URL or LOGDNA_TOKEN environment variable")
+	err = errors.New("missing logdna url or token")
 	return
 }
 
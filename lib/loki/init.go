@@ -0,0 +1,16 @@
+package loki
+
+import "github.com/segmentio/ecs-logs/lib"
+
+func init() {
+	lib.RegisterDestination("loki", lib.DestinationFunc(NewWriter))
+	lib.RegisterDestinationFactory("loki", func(opts lib.Options) (lib.Destination, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		return NewDestination(c)
+	})
+}
@@ -0,0 +1,137 @@
+package loki
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A stream is a set of entries that share the same labels, entries must be
+// sorted by time.
+type stream struct {
+	labels  map[string]string
+	entries []entry
+}
+
+type entry struct {
+	time time.Time
+	line string
+}
+
+// key returns the labels of the stream in the format expected by the
+// protobuf push API, like {group="web", stream="1"}.
+func (s *stream) key() string {
+	return formatLabels(s.labels)
+}
+
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+
+	for i, name := range names {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+
+	b.WriteByte('}')
+	return b.String()
+}
+
+// encodeProtobuf encodes streams as a logproto.PushRequest message:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//	message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+func encodeProtobuf(streams []*stream) []byte {
+	var req []byte
+
+	for _, s := range streams {
+		var msg []byte
+		msg = appendString(msg, 1, s.key())
+
+		for _, e := range s.entries {
+			var ts []byte
+			var ent []byte
+
+			if sec := e.time.Unix(); sec != 0 {
+				ts = appendVarint(appendTag(ts, 1, 0), uint64(sec))
+			}
+
+			if nsec := e.time.Nanosecond(); nsec != 0 {
+				ts = appendVarint(appendTag(ts, 2, 0), uint64(nsec))
+			}
+
+			ent = appendBytes(ent, 1, ts)
+			ent = appendString(ent, 2, e.line)
+			msg = appendBytes(msg, 2, ent)
+		}
+
+		req = appendBytes(req, 1, msg)
+	}
+
+	return req
+}
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return appendVarint(b, uint64(field<<3|wireType))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendVarint(appendTag(b, field, 2), uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	b = appendVarint(appendTag(b, field, 2), uint64(len(v)))
+	return append(b, v...)
+}
+
+// encodeJSON encodes streams in the format of the JSON push API, which is used
+// when the server doesn't accept protobuf.
+func encodeJSON(streams []*stream) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	req := struct {
+		Streams []jsonStream `json:"streams"`
+	}{
+		Streams: make([]jsonStream, 0, len(streams)),
+	}
+
+	for _, s := range streams {
+		js := jsonStream{Stream: s.labels, Values: make([][2]string, 0, len(s.entries))}
+
+		for _, e := range s.entries {
+			js.Values = append(js.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+		}
+
+		req.Streams = append(req.Streams, js)
+	}
+
+	return json.Marshal(req)
+}
@@ -0,0 +1,344 @@
+package loki
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+const (
+	DefaultTimeout = lib.Duration(30 * time.Second)
+)
+
+// Config represents the options of loki destinations.
+type Config struct {
+	// The URL of the server, for example http://localhost:3100.
+	URL string `json:"url"`
+
+	// Fields of the event data that are added to the group, stream and level
+	// labels of log entries. They should have few distinct values since each
+	// combination of labels creates a new stream in loki.
+	Labels []string `json:"labels"`
+
+	// The tenant sent in the X-Scope-OrgID header of requests.
+	TenantID string `json:"tenant_id"`
+
+	// Credentials for basic authentication.
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// Encoding of push requests, "protobuf" (default) or "json". The
+	// destination switches to json when a server doesn't support protobuf.
+	Format string `json:"format"`
+
+	// The maximum amount of time a push request may take.
+	Timeout lib.Duration `json:"timeout"`
+}
+
+func (c Config) validate() error {
+	if len(c.URL) == 0 {
+		return errors.New("missing loki URL")
+	}
+
+	u, err := url.Parse(c.URL)
+
+	if err != nil {
+		return fmt.Errorf("invalid loki URL, %s: %s", err, c.URL)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid loki URL, the scheme must be one of 'http' or 'https': %s", c.URL)
+	}
+
+	switch c.Format {
+	case "", "protobuf", "json":
+	default:
+		return fmt.Errorf("invalid loki format, must be one of 'protobuf' or 'json': %s", c.Format)
+	}
+
+	return nil
+}
+
+func configFromEnv() Config {
+	var labels []string
+
+	for _, s := range strings.Split(os.Getenv("LOKI_LABELS"), ",") {
+		if s = strings.TrimSpace(s); len(s) != 0 {
+			labels = append(labels, s)
+		}
+	}
+
+	return Config{
+		URL:      os.Getenv("LOKI_URL"),
+		Labels:   labels,
+		TenantID: os.Getenv("LOKI_TENANT_ID"),
+		Username: os.Getenv("LOKI_USERNAME"),
+		Password: os.Getenv("LOKI_PASSWORD"),
+		Format:   os.Getenv("LOKI_FORMAT"),
+	}
+}
+
+// env is the destination configured by the environment, it's created by the
+// first call to NewWriter so its state is shared by all writers.
+var env struct {
+	once sync.Once
+	dest *destination
+	err  error
+}
+
+func NewWriter(group string, stream string) (lib.Writer, error) {
+	env.once.Do(func() {
+		config := configFromEnv()
+
+		// Configuration errors won't go away by retrying the write operation.
+		if env.err = config.validate(); env.err != nil {
+			env.err = lib.PermanentError(env.err)
+			return
+		}
+
+		env.dest = newDestination(config)
+	})
+
+	if env.err != nil {
+		return nil, env.err
+	}
+
+	return env.dest.Open(group, stream)
+}
+
+// NewDestination returns a destination which pushes to the loki server
+// configured by config, errors in the configuration are reported immediately.
+func NewDestination(config Config) (lib.Destination, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return newDestination(config), nil
+}
+
+func newDestination(config Config) *destination {
+	if len(config.Format) == 0 {
+		config.Format = "protobuf"
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	return &destination{
+		config: config,
+		client: &http.Client{Timeout: time.Duration(config.Timeout)},
+		format: config.Format,
+		last:   make(map[string]time.Time),
+	}
+}
+
+// destination holds the state shared by the writers of a loki server, writers
+// are opened for each batch so the state would be lost otherwise.
+type destination struct {
+	config Config
+	client *http.Client
+
+	mutex sync.Mutex
+
+	// The encoding of push requests, which is switched to json when the
+	// server doesn't support protobuf.
+	format string
+
+	// The time of the last entry pushed to each stream, loki rejects entries
+	// older than the ones it already has.
+	last map[string]time.Time
+}
+
+func (d *destination) Open(group string, stream string) (lib.Writer, error) {
+	return &writer{d}, nil
+}
+
+func (d *destination) Close(group string, stream string) {}
+
+type writer struct {
+	*destination
+}
+
+func (w *writer) Close() error {
+	return nil
+}
+
+func (w *writer) WriteMessage(msg lib.Message) error {
+	return w.WriteMessageBatch(lib.MessageBatch{msg})
+}
+
+func (w *writer) WriteMessageBatch(batch lib.MessageBatch) (err error) {
+	if len(batch) == 0 {
+		return
+	}
+
+	streams := w.streams(batch)
+
+	if err = w.push(streams); err != nil {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, s := range streams {
+		key, t := s.key(), s.entries[len(s.entries)-1].time
+
+		if t.After(w.last[key]) {
+			w.last[key] = t
+		}
+	}
+
+	return
+}
+
+// streams groups the messages of batch by labels, batches are sorted when
+// they're flushed so the entries of each stream are in order, but entries may
+// still be older than the last ones pushed by a previous batch, their times
+// are moved forward in this case.
+func (d *destination) streams(batch lib.MessageBatch) []*stream {
+	var streams []*stream
+	index := make(map[string]*stream)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, msg := range batch {
+		labels := d.labels(msg)
+		key := formatLabels(labels)
+		s := index[key]
+
+		if s == nil {
+			s = &stream{labels: labels}
+			index[key] = s
+			streams = append(streams, s)
+		}
+
+		t := msg.Event.Time
+		last, ok := d.last[key]
+
+		if n := len(s.entries); n != 0 {
+			last, ok = s.entries[n-1].time, true
+		}
+
+		if ok && t.Before(last) {
+			t = last
+		}
+
+		s.entries = append(s.entries, entry{time: t, line: msg.Event.String()})
+	}
+
+	return streams
+}
+
+func (d *destination) labels(msg lib.Message) map[string]string {
+	labels := map[string]string{
+		"group":  msg.Group,
+		"stream": msg.Stream,
+	}
+
+	if msg.Event.Level != ecslogs.NONE {
+		labels["level"] = msg.Event.Level.String()
+	}
+
+	for _, name := range d.config.Labels {
+		switch v := msg.Event.Data[name].(type) {
+		case nil, map[string]interface{}, []interface{}:
+		case string:
+			if len(v) != 0 {
+				labels[labelName(name)] = v
+			}
+		default:
+			labels[labelName(name)] = fmt.Sprint(v)
+		}
+	}
+
+	return labels
+}
+
+// labelName replaces the characters that aren't allowed in label names.
+func labelName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func (d *destination) push(streams []*stream) (err error) {
+	var body []byte
+	var contentType string
+
+	d.mutex.Lock()
+	format := d.format
+	d.mutex.Unlock()
+
+	switch format {
+	case "json":
+		if body, err = encodeJSON(streams); err != nil {
+			return lib.PermanentError(err)
+		}
+		contentType = "application/json"
+	default:
+		body = snappy.Encode(nil, encodeProtobuf(streams))
+		contentType = "application/x-protobuf"
+	}
+
+	var req *http.Request
+	var res *http.Response
+
+	if req, err = http.NewRequest("POST", strings.TrimRight(d.config.URL, "/")+"/loki/api/v1/push", bytes.NewReader(body)); err != nil {
+		return lib.PermanentError(err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	if len(d.config.TenantID) != 0 {
+		req.Header.Set("X-Scope-OrgID", d.config.TenantID)
+	}
+
+	if len(d.config.Username) != 0 {
+		req.SetBasicAuth(d.config.Username, d.config.Password)
+	}
+
+	if res, err = d.client.Do(req); err != nil {
+		return
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnsupportedMediaType && format != "json" {
+		d.mutex.Lock()
+		d.format = "json"
+		d.mutex.Unlock()
+		return d.push(streams)
+	}
+
+	if res.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		err = fmt.Errorf("loki push request failed with status %d: %s", res.StatusCode, bytes.TrimSpace(b))
+
+		// Loki returns 429 when the ingestion rate limit is reached and 5xx
+		// when ingesters are unavailable, other errors won't go away when the
+		// request is retried.
+		if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < 500 {
+			err = lib.PermanentError(err)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,223 @@
+package loki
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func TestEncodeProtobuf(t *testing.T) {
+	streams := []*stream{{
+		labels:  map[string]string{"group": "a"},
+		entries: []entry{{time: time.Unix(1, 2), line: "A"}},
+	}}
+
+	// The key is {group="a"} (11 bytes), the timestamp has 1 second and 2
+	// nanoseconds.
+	expected := []byte{
+		0x0a, 0x18, // streams, 24 bytes
+		0x0a, 0x0b, '{', 'g', 'r', 'o', 'u', 'p', '=', '"', 'a', '"', '}',
+		0x12, 0x09, // entries, 9 bytes
+		0x0a, 0x04, 0x08, 0x01, 0x10, 0x02, // timestamp
+		0x12, 0x01, 'A', // line
+	}
+
+	if b := encodeProtobuf(streams); !bytes.Equal(b, expected) {
+		t.Errorf("invalid protobuf encoding:\n%x\n%x", expected, b)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	s := formatLabels(map[string]string{"stream": "1", "group": `a"b`, "level": "INFO"})
+
+	if s != `{group="a\"b", level="INFO", stream="1"}` {
+		t.Errorf("invalid labels: %s", s)
+	}
+}
+
+func TestWriterStreams(t *testing.T) {
+	t0 := time.Unix(1500000000, 0)
+
+	d := newDestination(Config{URL: "http://localhost:3100", Labels: []string{"app.name", "code", "user"}})
+	d.last[`{group="a", level="INFO", stream="1"}`] = t0.Add(time.Second)
+
+	streams := d.streams(lib.MessageBatch{
+		{Group: "a", Stream: "1", Event: ecslogs.Event{Level: ecslogs.INFO, Time: t0, Message: "1"}},
+		{Group: "a", Stream: "1", Event: ecslogs.Event{Level: ecslogs.ERROR, Time: t0, Message: "2", Data: ecslogs.EventData{"code": 500, "user": map[string]interface{}{}}}},
+		{Group: "a", Stream: "1", Event: ecslogs.Event{Level: ecslogs.INFO, Time: t0.Add(2 * time.Second), Message: "3", Data: ecslogs.EventData{"app.name": ""}}},
+		{Group: "a", Stream: "1", Event: ecslogs.Event{Time: t0, Data: ecslogs.EventData{"app.name": "web"}}},
+	})
+
+	if len(streams) != 3 {
+		t.Fatalf("invalid number of streams: %d", len(streams))
+	}
+
+	labels := []map[string]string{
+		{"group": "a", "stream": "1", "level": "INFO"},
+		{"group": "a", "stream": "1", "level": "ERROR", "code": "500"},
+		{"group": "a", "stream": "1", "app_name": "web"},
+	}
+
+	for i, s := range streams {
+		if !reflect.DeepEqual(s.labels, labels[i]) {
+			t.Errorf("invalid labels of stream %d: %v", i, s.labels)
+		}
+	}
+
+	// The first entry is older than the last one that was pushed to the
+	// stream.
+	if e := streams[0].entries; len(e) != 2 || !e[0].time.Equal(t0.Add(time.Second)) || !e[1].time.Equal(t0.Add(2*time.Second)) {
+		t.Errorf("invalid entries: %+v", e)
+	}
+}
+
+type pushServer struct {
+	t        *testing.T
+	protobuf bool
+	status   int
+	requests []string
+	bodies   [][]byte
+}
+
+func (s *pushServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	contentType := req.Header.Get("Content-Type")
+	b, _ := ioutil.ReadAll(req.Body)
+
+	if req.URL.Path != "/loki/api/v1/push" {
+		s.t.Errorf("invalid request path: %s", req.URL.Path)
+	}
+
+	if req.Header.Get("X-Scope-OrgID") != "tenant" {
+		s.t.Error("missing tenant header")
+	}
+
+	s.requests = append(s.requests, contentType)
+
+	if contentType == "application/x-protobuf" {
+		if !s.protobuf {
+			res.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var err error
+
+		if b, err = snappy.Decode(nil, b); err != nil {
+			s.t.Error(err)
+		}
+	}
+
+	s.bodies = append(s.bodies, b)
+
+	if s.status != 0 {
+		res.WriteHeader(s.status)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func testBatch() lib.MessageBatch {
+	return lib.MessageBatch{
+		{Group: "a", Stream: "1", Event: ecslogs.Event{Level: ecslogs.INFO, Time: time.Unix(1, 2), Message: "A"}},
+	}
+}
+
+func TestWriteMessageBatch(t *testing.T) {
+	s := &pushServer{t: t, protobuf: true}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	d := newDestination(Config{URL: server.URL, TenantID: "tenant"})
+	w, _ := d.Open("a", "1")
+
+	if err := w.WriteMessageBatch(testBatch()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.bodies) != 1 || !bytes.Equal(s.bodies[0], encodeProtobuf(d.streams(testBatch()))) {
+		t.Errorf("invalid push requests: %v", s.bodies)
+	}
+
+	// The time of the last entry is kept when the writer is opened again.
+	w, _ = d.Open("a", "1")
+
+	batch := testBatch()
+	batch[0].Event.Time = time.Unix(1, 0)
+
+	if streams := w.(*writer).streams(batch); !streams[0].entries[0].time.Equal(time.Unix(1, 2)) {
+		t.Errorf("the time of the entry should have been moved to the time of the last entry: %s", streams[0].entries[0].time)
+	}
+}
+
+func TestWriteMessageBatchJSONFallback(t *testing.T) {
+	s := &pushServer{t: t}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	d := newDestination(Config{URL: server.URL, TenantID: "tenant"})
+
+	// The format is kept when writers are opened again.
+	for i := 0; i != 2; i++ {
+		w, _ := d.Open("a", "1")
+
+		if err := w.WriteMessageBatch(testBatch()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(s.requests, []string{"application/x-protobuf", "application/json", "application/json"}) {
+		t.Errorf("the destination should have switched to json: %v", s.requests)
+	}
+
+	var req struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+
+	if err := json.Unmarshal(s.bodies[0], &req); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.Streams) != 1 || req.Streams[0].Stream["group"] != "a" || len(req.Streams[0].Values) != 1 || req.Streams[0].Values[0][0] != "1000000002" {
+		t.Errorf("invalid json push request: %s", s.bodies[0])
+	}
+}
+
+func TestWriteMessageBatchErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(&pushServer{t: t, protobuf: true, status: test.status})
+		w, _ := newDestination(Config{URL: server.URL, TenantID: "tenant"}).Open("a", "1")
+		err := w.WriteMessageBatch(testBatch())
+		server.Close()
+
+		if err == nil {
+			t.Errorf("%d: expected an error", test.status)
+			continue
+		}
+
+		if lib.IsPermanentError(err) != test.permanent {
+			t.Errorf("%d: invalid error kind: %v", test.status, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package match
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// A Pattern matches strings against a glob expression where '*' matches any
+// sequence of characters and '?' a single character, or against a regular
+// expression when the pattern is enclosed in slashes (like /^billing-.*$/).
+type Pattern struct {
+	re *regexp.Regexp
+}
+
+func ParsePattern(s string) (p Pattern, err error) {
+	var expr string
+
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		expr = s[1 : len(s)-1]
+	} else {
+		expr = globToRegexp(s)
+	}
+
+	if p.re, err = regexp.Compile(expr); err != nil {
+		err = fmt.Errorf("invalid pattern %q: %s", s, err)
+	}
+
+	return
+}
+
+func (p Pattern) MatchString(s string) bool {
+	return p.re.MatchString(s)
+}
+
+func globToRegexp(glob string) string {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.Replace(expr, `\*`, `.*`, -1)
+	expr = strings.Replace(expr, `\?`, `.`, -1)
+	return "^" + expr + "$"
+}
+
+// Config represents the conditions that messages must satisfy to match, empty
+// conditions match all messages.
+type Config struct {
+	// Patterns matched against the group and stream of messages.
+	Group  string `json:"group"`
+	Stream string `json:"stream"`
+
+	// The range of levels of matching messages, MinLevel is the least severe
+	// level (ERROR matches ERROR, CRIT, ALERT and EMERG) and MaxLevel the most
+	// verbose one (INFO excludes DEBUG and TRACE).
+	MinLevel string `json:"min_level"`
+	MaxLevel string `json:"max_level"`
+
+	// A pattern matched against the event message.
+	Message string `json:"message"`
+
+	// Patterns matched against the values of event data, keys of nested
+	// objects are separated by dots.
+	Data map[string]string `json:"data"`
+}
+
+// A Matcher tests whether messages satisfy a set of conditions.
+type Matcher struct {
+	group    *Pattern
+	stream   *Pattern
+	message  *Pattern
+	minLevel ecslogs.Level
+	maxLevel ecslogs.Level
+	data     map[string]Pattern
+}
+
+func New(config Config) (m *Matcher, err error) {
+	m = &Matcher{}
+
+	if m.group, err = parseOptionalPattern(config.Group); err != nil {
+		return nil, err
+	}
+
+	if m.stream, err = parseOptionalPattern(config.Stream); err != nil {
+		return nil, err
+	}
+
+	if m.message, err = parseOptionalPattern(config.Message); err != nil {
+		return nil, err
+	}
+
+	if len(config.MinLevel) != 0 {
+		if m.minLevel, err = ecslogs.ParseLevel(config.MinLevel); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.MaxLevel) != 0 {
+		if m.maxLevel, err = ecslogs.ParseLevel(config.MaxLevel); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.Data) != 0 {
+		m.data = make(map[string]Pattern, len(config.Data))
+
+		for k, v := range config.Data {
+			if m.data[k], err = ParsePattern(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return
+}
+
+func (m *Matcher) Match(msg lib.Message) bool {
+	if m.group != nil && !m.group.MatchString(msg.Group) {
+		return false
+	}
+
+	if m.stream != nil && !m.stream.MatchString(msg.Stream) {
+		return false
+	}
+
+	// Levels are ordered from the most severe to the most verbose, messages
+	// without a level have an unknown severity so they never satisfy the
+	// minimum level but aren't excluded by the maximum one.
+	if m.minLevel != ecslogs.NONE && (msg.Event.Level == ecslogs.NONE || msg.Event.Level > m.minLevel) {
+		return false
+	}
+
+	if m.maxLevel != ecslogs.NONE && msg.Event.Level > m.maxLevel {
+		return false
+	}
+
+	if m.message != nil && !m.message.MatchString(msg.Event.Message) {
+		return false
+	}
+
+	for k, p := range m.data {
+		v, ok := Lookup(msg.Event.Data, k)
+
+		if !ok || !p.MatchString(fmt.Sprint(v)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Lookup returns the value at path in data, where path is a list of keys
+// separated by dots.
+func Lookup(data ecslogs.EventData, path string) (v interface{}, ok bool) {
+	// Keys that contain dots themselves take precedence.
+	if v, ok = data[path]; ok {
+		return
+	}
+
+	var m map[string]interface{} = data
+
+	for {
+		i := strings.IndexByte(path, '.')
+
+		if i < 0 {
+			v, ok = m[path]
+			return
+		}
+
+		switch x := m[path[:i]].(type) {
+		case map[string]interface{}:
+			m = x
+		case ecslogs.EventData:
+			m = x
+		default:
+			return
+		}
+
+		path = path[i+1:]
+	}
+}
+
+func parseOptionalPattern(s string) (*Pattern, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	p, err := ParsePattern(s)
+	return &p, err
+}
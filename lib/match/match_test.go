@@ -0,0 +1,92 @@
+package match
+
+import (
+	"testing"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		match   bool
+	}{
+		{"billing-*", "billing-api", true},
+		{"billing-*", "api-billing", false},
+		{"api-?", "api-1", true},
+		{"api-?", "api-10", false},
+		{"a.b", "axb", false},
+		{"/^api-[0-9]+$/", "api-10", true},
+		{"/^api-[0-9]+$/", "api-x", false},
+	}
+
+	for _, test := range tests {
+		p, err := ParsePattern(test.pattern)
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if match := p.MatchString(test.value); match != test.match {
+			t.Errorf("%q matching %q: expected %t but found %t", test.pattern, test.value, test.match, match)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	msg := lib.Message{
+		Group:  "billing",
+		Stream: "api-1",
+		Event: ecslogs.Event{
+			Level:   ecslogs.WARN,
+			Message: "payment declined",
+			Data: ecslogs.EventData{
+				"http": map[string]interface{}{"status": 402},
+			},
+		},
+	}
+
+	tests := []struct {
+		config Config
+		match  bool
+	}{
+		{Config{}, true},
+		{Config{Group: "billing"}, true},
+		{Config{Group: "search"}, false},
+		{Config{Stream: "api-*"}, true},
+		{Config{MinLevel: "ERROR"}, false},
+		{Config{MinLevel: "WARN"}, true},
+		{Config{MaxLevel: "ERROR"}, false},
+		{Config{MaxLevel: "INFO"}, true},
+		{Config{Message: "*declined"}, true},
+		{Config{Data: map[string]string{"http.status": "4??"}}, true},
+		{Config{Data: map[string]string{"http.status": "5??"}}, false},
+		{Config{Data: map[string]string{"http.method": "*"}}, false},
+	}
+
+	for _, test := range tests {
+		m, err := New(test.config)
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if match := m.Match(msg); match != test.match {
+			t.Errorf("%#v: expected %t but found %t", test.config, test.match, match)
+		}
+	}
+}
+
+func TestMatcherError(t *testing.T) {
+	if _, err := New(Config{Group: "/(/"}); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+
+	if _, err := New(Config{MinLevel: "whatever"}); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+}
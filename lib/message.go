@@ -2,6 +2,7 @@ package lib
 
 import (
 	"encoding/json"
+	"strings"
 	"sync"
 
 	"github.com/segmentio/ecs-logs-go"
@@ -12,6 +13,14 @@ type Message struct {
 	Group  string        `json:"group,omitempty"`
 	Stream string        `json:"stream,omitempty"`
 	Event  ecslogs.Event `json:"event,omitempty"`
+
+	// Position is set by readers that track which messages were delivered so
+	// they can resume from the right place after a restart.
+	Position *Position `json:"-"`
+
+	// PlainText is set by readers when the message wasn't a JSON event, only
+	// these messages are parsed by the processing stages.
+	PlainText bool `json:"-"`
 }
 
 func (m Message) Bytes() []byte {
@@ -28,6 +37,19 @@ func (m Message) ContentLength() int {
 	return n
 }
 
+// ParseText decodes s as a JSON event, when s isn't a JSON event the returned
+// event has s as message and plain is true.
+func ParseText(s string) (e ecslogs.Event, plain bool) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+
+	if d.Decode(&e) != nil {
+		e, plain = ecslogs.Event{Message: s}, true
+	}
+
+	return
+}
+
 type MessageBatch []Message
 
 func (list MessageBatch) Swap(i int, j int) {
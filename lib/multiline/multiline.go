@@ -0,0 +1,298 @@
+package multiline
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+)
+
+const (
+	DefaultMaxLines = 500
+	DefaultMaxBytes = 65536
+	DefaultTimeout  = lib.Duration(time.Second)
+)
+
+// presets are continuation patterns matching the lines that follow the first
+// line of stack traces. Blank lines are only merged when the line after them
+// continues the trace as well.
+var presets = map[string]string{
+	// Lines starting with "at", "... N more", "Caused by:" or "Suppressed:",
+	// all indented except for "Caused by:".
+	"java": `^(\s+at\s|\s+\.\.\.\s+\d+\s+(more|common frames omitted)|\s*Caused by:|\s+Suppressed:)`,
+
+	// Frame locations and their source lines, the error markers under them,
+	// the chained exceptions messages and the final exception line. Tracebacks
+	// start with their header, which is not a continuation.
+	"python": `^(  File ".*", line \d+|    \S|\s+[~^]+\s*$|During handling of the above exception|The above exception was the direct cause|[A-Za-z_][\w.]*(Error|Exception|Exit|Interrupt|Warning)(: |$))`,
+
+	// Goroutine headers, function calls with their package path and
+	// arguments, their locations indented by a tab, signal descriptions and
+	// the exit status of the program.
+	"go": `^(goroutine \d+ \[[^\]]+\]:$|[\w.\-]+(/[\w.\-]+)*\.(\(\*?[\w\[\]., ]+\)\.)?[\w\[\].]+\(((0x[0-9a-f]+|\.\.\.|\{[^}]*\})(, )?)*\)$|\t.+:\d+( \+0x[0-9a-f]+)?$|\[signal |created by [\w./\-]|exit status \d+$)`,
+}
+
+// Presets returns the sorted list of names of the built-in presets.
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+
+	for name := range presets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// A Rule configures how lines of the streams matching Group and Stream are
+// merged. Exactly one of Preset, Start or Continuation must be set: lines that
+// match Continuation, or that don't match Start, are appended to the previous
+// message of the stream.
+type Rule struct {
+	Group  string `json:"group"`
+	Stream string `json:"stream"`
+
+	Preset       string `json:"preset"`
+	Start        string `json:"start"`
+	Continuation string `json:"continuation"`
+
+	// The limits of merged messages, and how long to wait for continuation
+	// lines before the message is emitted.
+	MaxLines int          `json:"max_lines"`
+	MaxBytes int          `json:"max_bytes"`
+	Timeout  lib.Duration `json:"timeout"`
+}
+
+// An Aggregator merges messages of the same stream that are the lines of a
+// single event.
+//
+// Aggregators are not safe to use concurrently from multiple goroutines.
+type Aggregator struct {
+	rules   []rule
+	streams map[streamKey]*pending
+	bytes   int
+}
+
+type rule struct {
+	Rule
+	matcher      *match.Matcher
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+	blank        bool
+}
+
+type streamKey struct {
+	group  string
+	stream string
+}
+
+type pending struct {
+	msg      lib.Message
+	lines    int
+	blank    int
+	bytes    int
+	deadline time.Time
+}
+
+// New compiles rules into an aggregator, the first rule that matches the group
+// and stream of a message applies to it.
+func New(rules []Rule) (*Aggregator, error) {
+	a := &Aggregator{
+		rules:   make([]rule, 0, len(rules)),
+		streams: make(map[streamKey]*pending),
+	}
+
+	for i, r := range rules {
+		c, err := compile(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("multiline rule #%d: %s", i+1, err)
+		}
+
+		a.rules = append(a.rules, c)
+	}
+
+	return a, nil
+}
+
+func compile(r Rule) (c rule, err error) {
+	c.Rule = r
+
+	if c.matcher, err = match.New(match.Config{Group: r.Group, Stream: r.Stream}); err != nil {
+		return
+	}
+
+	if len(r.Preset) != 0 {
+		expr, ok := presets[r.Preset]
+
+		if !ok {
+			err = fmt.Errorf("unknown preset %q, must be one of %s", r.Preset, strings.Join(Presets(), ", "))
+			return
+		}
+
+		if len(r.Start) != 0 || len(r.Continuation) != 0 {
+			err = errors.New("start and continuation cannot be set with a preset")
+			return
+		}
+
+		c.continuation = regexp.MustCompile(expr)
+		c.blank = true
+	} else {
+		switch {
+		case len(r.Start) != 0 && len(r.Continuation) != 0:
+			err = errors.New("start and continuation cannot be both set")
+		case len(r.Start) != 0:
+			c.start, err = regexp.Compile(r.Start)
+		case len(r.Continuation) != 0:
+			c.continuation, err = regexp.Compile(r.Continuation)
+		default:
+			err = errors.New("one of preset, start or continuation must be set")
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	if c.MaxLines <= 0 {
+		c.MaxLines = DefaultMaxLines
+	}
+
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = DefaultMaxBytes
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+
+	return
+}
+
+func (r *rule) continues(line string) bool {
+	if r.start != nil {
+		return !r.start.MatchString(line)
+	}
+	return r.continuation.MatchString(line)
+}
+
+// Interval returns how often Flush should be called.
+func (a *Aggregator) Interval() time.Duration {
+	interval := time.Duration(DefaultTimeout)
+
+	for _, r := range a.rules {
+		if t := time.Duration(r.Timeout); t < interval {
+			interval = t
+		}
+	}
+
+	return interval / 2
+}
+
+// Add submits msg to the aggregator, it returns the messages that are complete
+// and true if msg was merged into a previous message.
+//
+// Only plain text messages are merged, structured events are returned
+// unchanged after the message pending on their stream, if any.
+//
+// The position of a merged message is the one of its first line, so the lines
+// that were merged can be acknowledged right away. With presets, blank lines
+// are held until the next line is read and discarded if it doesn't continue
+// the message.
+func (a *Aggregator) Add(msg lib.Message, now time.Time) (batch lib.MessageBatch, merged bool) {
+	r := a.rule(msg)
+
+	if r == nil {
+		batch = lib.MessageBatch{msg}
+		return
+	}
+
+	key := streamKey{group: msg.Group, stream: msg.Stream}
+	line := msg.Event.Message
+	p := a.streams[key]
+
+	if !msg.PlainText {
+		if p != nil {
+			batch = append(batch, p.msg)
+			a.bytes -= p.bytes
+			delete(a.streams, key)
+		}
+		batch = append(batch, msg)
+		return
+	}
+
+	if p == nil && r.blank && len(strings.TrimSpace(line)) == 0 {
+		batch = lib.MessageBatch{msg}
+		return
+	}
+
+	if p != nil {
+		if r.blank && len(strings.TrimSpace(line)) == 0 && p.lines+p.blank < r.MaxLines {
+			p.blank++
+			merged = true
+			return
+		}
+
+		sep := strings.Repeat("\n", p.blank+1)
+
+		if r.continues(line) && p.lines+p.blank < r.MaxLines && (len(p.msg.Event.Message)+len(sep)+len(line)) <= r.MaxBytes {
+			p.msg.Event.Message += sep + line
+			p.lines += p.blank + 1
+			p.bytes += len(sep) + len(line)
+			a.bytes += len(sep) + len(line)
+			p.blank = 0
+			merged = true
+			return
+		}
+
+		batch = append(batch, p.msg)
+		a.bytes -= p.bytes
+	}
+
+	p = &pending{
+		msg:      msg,
+		lines:    1,
+		bytes:    msg.ContentLength(),
+		deadline: now.Add(time.Duration(r.Timeout)),
+	}
+
+	a.streams[key] = p
+	a.bytes += p.bytes
+	return
+}
+
+// Bytes returns the approximate size of the messages held by the aggregator
+// while waiting for continuation lines.
+func (a *Aggregator) Bytes() int {
+	return a.bytes
+}
+
+// Flush returns the messages that have waited for continuation lines for
+// longer than their timeout, or all the messages if force is true.
+func (a *Aggregator) Flush(now time.Time, force bool) (batch lib.MessageBatch) {
+	for key, p := range a.streams {
+		if force || !now.Before(p.deadline) {
+			batch = append(batch, p.msg)
+			a.bytes -= p.bytes
+			delete(a.streams, key)
+		}
+	}
+
+	// Messages are emitted in the order they were read, as much as possible.
+	sort.Stable(batch)
+	return
+}
+
+func (a *Aggregator) rule(msg lib.Message) *rule {
+	for i := range a.rules {
+		if r := &a.rules[i]; r.matcher.Match(msg) {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package multiline
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func lines(a *Aggregator, now time.Time, group string, list ...string) (batch lib.MessageBatch, merged int) {
+	for i, line := range list {
+		b, m := a.Add(lib.Message{
+			Group:     group,
+			Stream:    "S",
+			PlainText: true,
+			Event:     ecslogs.Event{Message: line, Time: now.Add(time.Duration(i))},
+		}, now)
+
+		if m {
+			merged++
+		}
+
+		batch = append(batch, b...)
+	}
+	return
+}
+
+func messages(batch lib.MessageBatch) (list []string) {
+	for _, msg := range batch {
+		list = append(list, msg.Event.Message)
+	}
+	return
+}
+
+func TestAggregatorPresets(t *testing.T) {
+	tests := []struct {
+		preset string
+		lines  []string
+	}{
+		{
+			preset: "java",
+			lines: []string{
+				"Exception in thread \"main\" java.lang.IllegalStateException: boom",
+				"\tat com.example.App.run(App.java:12)",
+				"\tat com.example.App.main(App.java:5)",
+				"Caused by: java.io.IOException: closed",
+				"\t... 2 more",
+			},
+		},
+		{
+			preset: "python",
+			lines: []string{
+				"Traceback (most recent call last):",
+				"  File \"app.py\", line 3, in <module>",
+				"    main()",
+				"ValueError: boom",
+			},
+		},
+		{
+			preset: "go",
+			lines: []string{
+				"panic: boom",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/go/src/app/main.go:5 +0x39",
+				"exit status 2",
+			},
+		},
+	}
+
+	now := time.Now()
+
+	for _, test := range tests {
+		a, err := New([]Rule{{Preset: test.preset}})
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		batch, merged := lines(a, now, "G", append(test.lines, "next message")...)
+
+		if merged != len(test.lines)-1 {
+			t.Errorf("%s: %d lines were merged instead of %d", test.preset, merged, len(test.lines)-1)
+		}
+
+		if list := messages(batch); len(list) != 1 || list[0] != strings.Join(test.lines, "\n") {
+			t.Errorf("%s: invalid messages: %q", test.preset, list)
+		}
+
+		if list := messages(a.Flush(now, true)); len(list) != 1 || list[0] != "next message" {
+			t.Errorf("%s: invalid flushed messages: %q", test.preset, list)
+		}
+	}
+}
+
+func TestAggregatorPresetsUnrelatedLines(t *testing.T) {
+	tests := []struct {
+		preset   string
+		lines    []string
+		messages []string
+	}{
+		{
+			// Tracebacks are not appended to the previous message, and blank
+			// lines are discarded when they aren't followed by a frame.
+			preset: "python",
+			lines: []string{
+				"starting worker",
+				"",
+				"Traceback (most recent call last):",
+				"  File \"app.py\", line 3, in <module>",
+				"ValueError: boom",
+			},
+			messages: []string{
+				"starting worker",
+				"Traceback (most recent call last):\n  File \"app.py\", line 3, in <module>\nValueError: boom",
+			},
+		},
+		{
+			preset: "go",
+			lines: []string{
+				"calling handler(request)",
+				"handler(request)",
+				"",
+				"fmt.Println(x)",
+				"panic: boom",
+				"",
+				"goroutine 1 [running]:",
+				"main.(*Server).Serve(0xc000010000, {0x1, 0x2}, ...)",
+				"\t/go/src/app/main.go:5 +0x39",
+			},
+			messages: []string{
+				"calling handler(request)",
+				"handler(request)",
+				"fmt.Println(x)",
+				"panic: boom\n\ngoroutine 1 [running]:\nmain.(*Server).Serve(0xc000010000, {0x1, 0x2}, ...)\n\t/go/src/app/main.go:5 +0x39",
+			},
+		},
+	}
+
+	now := time.Now()
+
+	for _, test := range tests {
+		a, _ := New([]Rule{{Preset: test.preset}})
+		batch, _ := lines(a, now, "G", test.lines...)
+		batch = append(batch, a.Flush(now, true)...)
+
+		if list := messages(batch); strings.Join(list, "|") != strings.Join(test.messages, "|") {
+			t.Errorf("%s: invalid messages: %q", test.preset, list)
+		}
+	}
+}
+
+func TestAggregatorStart(t *testing.T) {
+	a, err := New([]Rule{{Group: "G", Start: `^\d{4}-\d{2}-\d{2} `, MaxLines: 3}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	batch, _ := lines(a, now, "G",
+		"2017-01-01 first",
+		"a",
+		"b",
+		"c",
+		"2017-01-01 second",
+	)
+
+	if list := messages(batch); len(list) != 2 || list[0] != "2017-01-01 first\na\nb" || list[1] != "c" {
+		t.Errorf("invalid messages: %q", list)
+	}
+
+	// Messages of other groups are not merged.
+	if batch, merged := lines(a, now, "H", "2017-01-01 first", "a"); len(batch) != 2 || merged != 0 {
+		t.Errorf("invalid messages: %q", messages(batch))
+	}
+}
+
+func TestAggregatorStructuredEvents(t *testing.T) {
+	a, _ := New([]Rule{{Continuation: `^\s`}})
+	now := time.Now()
+	lines(a, now, "G", "A", " B")
+
+	// Structured events are never merged, the pending message is returned
+	// first so the order of the stream is preserved.
+	batch, merged := a.Add(lib.Message{
+		Group:  "G",
+		Stream: "S",
+		Event:  ecslogs.Event{Message: " C", Time: now},
+	}, now)
+
+	if list := messages(batch); merged || len(list) != 2 || list[0] != "A\n B" || list[1] != " C" {
+		t.Errorf("invalid messages: %q", list)
+	}
+
+	if n := a.Bytes(); n != 0 {
+		t.Error("no messages should be held after a structured event:", n)
+	}
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	a, _ := New([]Rule{{Continuation: `^\s`, Timeout: lib.Duration(time.Second)}})
+	now := time.Now()
+	lines(a, now, "G", "A", " B")
+
+	if batch := a.Flush(now.Add(500*time.Millisecond), false); len(batch) != 0 {
+		t.Errorf("no messages should have been flushed: %q", messages(batch))
+	}
+
+	if n := a.Bytes(); n == 0 {
+		t.Error("the size of the held messages should be reported")
+	}
+
+	if list := messages(a.Flush(now.Add(time.Second), false)); len(list) != 1 || list[0] != "A\n B" {
+		t.Errorf("invalid flushed messages: %q", list)
+	}
+
+	if n := a.Bytes(); n != 0 {
+		t.Error("no messages should be held after they were flushed:", n)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	tests := []Rule{
+		{},
+		{Preset: "whatever"},
+		{Preset: "java", Start: "A"},
+		{Start: "A", Continuation: "B"},
+		{Start: "("},
+	}
+
+	for _, rule := range tests {
+		if _, err := New([]Rule{rule}); err == nil {
+			t.Errorf("%#v: expected an error", rule)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Options carries the settings of a source or destination declared in a
+// configuration file.
+type Options map[string]interface{}
+
+// Decode stores the options in the value pointed to by v, which is usually a
+// struct with json tags. Options that don't match a field of v are reported as
+// errors.
+func (opts Options) Decode(v interface{}) (err error) {
+	var b []byte
+
+	if b, err = json.Marshal(opts); err != nil {
+		return
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+
+	if err = d.Decode(v); err != nil {
+		err = fmt.Errorf("invalid options: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	return
+}
+
+// Duration is a time.Duration that is represented as a string like "1.5s" in
+// options and configuration files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be strings like \"1.5s\" or \"100ms\": %s", b)
+	}
+
+	v, err := time.ParseDuration(s)
+
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
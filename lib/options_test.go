@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOptionsDecodeDuration(t *testing.T) {
+	var v struct {
+		Timeout Duration `json:"timeout"`
+	}
+
+	if err := (Options{"timeout": "1.5s"}).Decode(&v); err != nil {
+		t.Error(err)
+	} else if v.Timeout != Duration(1500*time.Millisecond) {
+		t.Error("invalid duration:", time.Duration(v.Timeout))
+	}
+
+	if err := (Options{"timeout": 10}).Decode(&v); err == nil {
+		t.Error("expected an error for a duration that isn't a string")
+	}
+}
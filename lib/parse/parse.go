@@ -0,0 +1,397 @@
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+)
+
+// A Rule selects the format used to parse the plain-text messages of the
+// streams matching Group and Stream.
+//
+// The supported formats are:
+//
+//	logfmt   key=value pairs, the level, time and msg (or message) keys set
+//	         the corresponding event fields and the others go to event data,
+//	         messages with words that aren't pairs are left unchanged
+//	regex    a regular expression with named captures, the level, time and
+//	         message captures set the event fields and the others go to event
+//	         data
+//	combined the Apache and nginx combined access log format
+//	klog     the format of Kubernetes and glog logs, in the local time zone
+//
+// TimeFormat is the layout of the time values, as defined by the time package,
+// it defaults to RFC 3339.
+type Rule struct {
+	Group      string `json:"group"`
+	Stream     string `json:"stream"`
+	Format     string `json:"format"`
+	Pattern    string `json:"pattern"`
+	TimeFormat string `json:"time_format"`
+}
+
+// A Parser extracts the level, time, message and data of events from
+// plain-text messages.
+type Parser struct {
+	rules []rule
+}
+
+type rule struct {
+	matcher *match.Matcher
+	format  format
+}
+
+// format is implemented by the parsers of each supported format, parse returns
+// false if line doesn't have the expected format.
+type format interface {
+	parse(line string, event *ecslogs.Event) bool
+}
+
+// New compiles rules into a parser, the first rule that matches the group and
+// stream of a message applies to it.
+func New(rules []Rule) (*Parser, error) {
+	p := &Parser{rules: make([]rule, 0, len(rules))}
+
+	for i, r := range rules {
+		c, err := compile(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("parser #%d: %s", i+1, err)
+		}
+
+		p.rules = append(p.rules, c)
+	}
+
+	return p, nil
+}
+
+func compile(r Rule) (c rule, err error) {
+	if c.matcher, err = match.New(match.Config{Group: r.Group, Stream: r.Stream}); err != nil {
+		return
+	}
+
+	timeFormat := r.TimeFormat
+
+	if len(timeFormat) == 0 {
+		timeFormat = time.RFC3339Nano
+	}
+
+	if r.Format != "regex" && len(r.Pattern) != 0 {
+		err = errors.New("pattern can only be set with the regex format")
+		return
+	}
+
+	switch r.Format {
+	case "logfmt":
+		c.format = logfmt{timeFormat: timeFormat}
+
+	case "regex":
+		var re *regexp.Regexp
+
+		if len(r.Pattern) == 0 {
+			err = errors.New("missing pattern for the regex format")
+			return
+		}
+
+		if re, err = regexp.Compile(r.Pattern); err != nil {
+			return
+		}
+
+		c.format = regex{re: re, timeFormat: timeFormat}
+
+	case "combined":
+		c.format = combined{}
+
+	case "klog":
+		c.format = klog{}
+
+	default:
+		err = fmt.Errorf("invalid format, must be one of 'logfmt', 'regex', 'combined' or 'klog': %q", r.Format)
+	}
+
+	return
+}
+
+// Apply parses the message of msg, the event is left unchanged if it was
+// decoded from JSON or if the message doesn't have the format of the rule that
+// applies to it.
+func (p *Parser) Apply(msg *lib.Message) bool {
+	if !msg.PlainText || len(msg.Event.Message) == 0 {
+		return false
+	}
+
+	for _, r := range p.rules {
+		if r.matcher.Match(*msg) {
+			if msg.Event.Data == nil {
+				msg.Event.Data = ecslogs.EventData{}
+			}
+			return r.format.parse(msg.Event.Message, &msg.Event)
+		}
+	}
+
+	return false
+}
+
+// setField sets the event field for key, or the event data if key isn't one
+// of the level, time or message keys.
+func setField(e *ecslogs.Event, key string, value string, timeFormat string) {
+	switch key {
+	case "level", "lvl", "severity":
+		if lvl, ok := parseLevel(value); ok {
+			e.Level = lvl
+			return
+		}
+
+	case "time", "ts", "timestamp":
+		if t, err := time.Parse(timeFormat, value); err == nil {
+			e.Time = t
+			return
+		}
+
+	case "msg", "message":
+		e.Message = value
+		return
+	}
+
+	e.Data[key] = value
+}
+
+// parseLevel converts the level names used by most logging libraries.
+func parseLevel(s string) (lvl ecslogs.Level, ok bool) {
+	switch strings.ToUpper(s) {
+	case "EMERG", "EMERGENCY", "PANIC":
+		lvl = ecslogs.EMERG
+	case "ALERT":
+		lvl = ecslogs.ALERT
+	case "CRIT", "CRITICAL", "FATAL", "F":
+		lvl = ecslogs.CRIT
+	case "ERROR", "ERR", "E":
+		lvl = ecslogs.ERROR
+	case "WARN", "WARNING", "W":
+		lvl = ecslogs.WARN
+	case "NOTICE", "N":
+		lvl = ecslogs.NOTICE
+	case "INFO", "I":
+		lvl = ecslogs.INFO
+	case "DEBUG", "D":
+		lvl = ecslogs.DEBUG
+	case "TRACE", "T":
+		lvl = ecslogs.TRACE
+	default:
+		return
+	}
+	return lvl, true
+}
+
+type logfmt struct {
+	timeFormat string
+}
+
+func (f logfmt) parse(line string, e *ecslogs.Event) bool {
+	pairs, ok := parseLogfmt(line)
+
+	if !ok {
+		return false
+	}
+
+	for _, kv := range pairs {
+		setField(e, kv[0], kv[1], f.timeFormat)
+	}
+
+	return true
+}
+
+// parseLogfmt splits line into key/value pairs, values may be quoted. It
+// returns false if line contains no pairs or words that aren't pairs, so plain
+// sentences are not mistaken for logfmt.
+func parseLogfmt(line string) (pairs [][2]string, ok bool) {
+	for len(line) != 0 {
+		line = strings.TrimLeft(line, " \t")
+
+		if len(line) == 0 {
+			break
+		}
+
+		i := strings.IndexAny(line, "= \t")
+
+		if i < 0 {
+			i = len(line)
+		}
+
+		key := line[:i]
+		line = line[i:]
+
+		if len(key) == 0 || !strings.HasPrefix(line, "=") {
+			return nil, false
+		}
+
+		line = line[1:]
+		var value string
+
+		if strings.HasPrefix(line, `"`) {
+			end := 1
+
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end >= len(line) {
+				return nil, false
+			}
+
+			var err error
+
+			if value, err = strconv.Unquote(line[:end+1]); err != nil {
+				return nil, false
+			}
+
+			line = line[end+1:]
+		} else {
+			if i = strings.IndexAny(line, " \t"); i < 0 {
+				i = len(line)
+			}
+			value, line = line[:i], line[i:]
+		}
+
+		pairs = append(pairs, [2]string{key, value})
+		ok = true
+	}
+
+	return
+}
+
+type regex struct {
+	re         *regexp.Regexp
+	timeFormat string
+}
+
+func (f regex) parse(line string, e *ecslogs.Event) bool {
+	m := f.re.FindStringSubmatch(line)
+
+	if m == nil {
+		return false
+	}
+
+	for i, name := range f.re.SubexpNames() {
+		if len(name) != 0 && len(m[i]) != 0 {
+			setField(e, name, m[i], f.timeFormat)
+		}
+	}
+
+	return true
+}
+
+var combinedRegexp = regexp.MustCompile(
+	`^(?P<remote_addr>\S+) \S+ (?P<remote_user>\S+) \[(?P<time>[^\]]+)\] ` +
+		`"(?P<method>[A-Z]+) (?P<path>\S+) (?P<protocol>[^"]+)" ` +
+		`(?P<status>\d{3}) (?P<bytes>\d+|-)` +
+		`(?: "(?P<referer>[^"]*)" "(?P<user_agent>[^"]*)")?`,
+)
+
+type combined struct{}
+
+func (combined) parse(line string, e *ecslogs.Event) bool {
+	m := combinedRegexp.FindStringSubmatch(line)
+
+	if m == nil {
+		return false
+	}
+
+	var status string
+
+	for i, name := range combinedRegexp.SubexpNames() {
+		v := m[i]
+
+		if name == "status" {
+			status = v
+		}
+
+		if len(name) == 0 || len(v) == 0 || v == "-" {
+			continue
+		}
+
+		switch name {
+		case "time":
+			if t, err := time.Parse("02/Jan/2006:15:04:05 -0700", v); err == nil {
+				e.Time = t
+			}
+
+		case "status", "bytes":
+			n, _ := strconv.Atoi(v)
+			e.Data[name] = n
+
+		default:
+			e.Data[name] = v
+		}
+	}
+
+	// Server errors are reported as errors and client errors as warnings.
+	switch status[0] {
+	case '5':
+		e.Level = ecslogs.ERROR
+	case '4':
+		e.Level = ecslogs.WARN
+	default:
+		e.Level = ecslogs.INFO
+	}
+
+	return true
+}
+
+var klogRegexp = regexp.MustCompile(`(?s)^([IWEF])(\d{2})(\d{2}) (\d{2}:\d{2}:\d{2}\.\d{6})\s+(\d+) ([^:\]]+):(\d+)\] (.*)$`)
+
+type klog struct{}
+
+func (klog) parse(line string, e *ecslogs.Event) bool {
+	m := klogRegexp.FindStringSubmatch(line)
+
+	if m == nil {
+		return false
+	}
+
+	e.Level, _ = parseLevel(m[1])
+
+	// The klog format has no year or time zone, times are in the local time
+	// zone and in the year of the time the message was read at, or of the
+	// year before for messages of December read in January.
+	now := e.Time
+
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if t, err := parseKlogTime(m[2]+m[3]+" "+m[4], now); err == nil {
+		e.Time = t
+	}
+
+	e.Info.PID, _ = strconv.Atoi(m[5])
+	e.Info.Source = m[6] + ":" + m[7]
+	e.Message = m[8]
+	return true
+}
+
+func parseKlogTime(s string, now time.Time) (t time.Time, err error) {
+	now = now.In(time.Local)
+
+	if t, err = time.ParseInLocation("2006 0102 15:04:05.000000", fmt.Sprintf("%d %s", now.Year(), s), time.Local); err != nil {
+		return
+	}
+
+	// Clocks may be slightly off, only times that are far in the future
+	// belong to the previous year.
+	if t.Sub(now) > 24*time.Hour {
+		t = t.AddDate(-1, 0, 0)
+	}
+
+	return
+}
@@ -0,0 +1,176 @@
+package parse
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func parseLine(t *testing.T, rule Rule, line string) (ecslogs.Event, bool) {
+	p, err := New([]Rule{rule})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := lib.Message{
+		Group:     "G",
+		Stream:    "S",
+		PlainText: true,
+		Event: ecslogs.Event{
+			Message: line,
+			Time:    time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			Data:    ecslogs.EventData{},
+		},
+	}
+
+	ok := p.Apply(&msg)
+	return msg.Event, ok
+}
+
+func TestParseLogfmt(t *testing.T) {
+	e, ok := parseLine(t, Rule{Format: "logfmt"}, `time=2017-03-04T05:06:07Z level=warn msg="disk \"full\"" path=/var used=99`)
+
+	if !ok {
+		t.Fatal("the message should have been parsed")
+	}
+
+	if e.Level != ecslogs.WARN {
+		t.Error("invalid level:", e.Level)
+	}
+
+	if !e.Time.Equal(time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)) {
+		t.Error("invalid time:", e.Time)
+	}
+
+	if e.Message != `disk "full"` {
+		t.Error("invalid message:", e.Message)
+	}
+
+	if expected := (ecslogs.EventData{"path": "/var", "used": "99"}); !reflect.DeepEqual(e.Data, expected) {
+		t.Error("invalid data:", e.Data)
+	}
+
+	for _, line := range []string{"hello world", "level=info starting server on port=80"} {
+		if _, ok := parseLine(t, Rule{Format: "logfmt"}, line); ok {
+			t.Errorf("%q: a message with words that aren't key=value pairs should not have been parsed", line)
+		}
+	}
+}
+
+func TestParseJSONEvent(t *testing.T) {
+	p, _ := New([]Rule{{Format: "logfmt"}})
+	msg := lib.Message{Event: ecslogs.Event{Message: "level=error"}}
+
+	if p.Apply(&msg) || msg.Event.Level != ecslogs.NONE {
+		t.Error("messages decoded from JSON events should not be parsed")
+	}
+}
+
+func TestParseRegex(t *testing.T) {
+	rule := Rule{
+		Format:     "regex",
+		Pattern:    `^\[(?P<level>\w+)\] (?P<time>\S+ \S+) (?P<component>\w+): (?P<message>.*)$`,
+		TimeFormat: "2006-01-02 15:04:05",
+	}
+
+	e, ok := parseLine(t, rule, "[ERROR] 2017-03-04 05:06:07 db: connection lost")
+
+	if !ok {
+		t.Fatal("the message should have been parsed")
+	}
+
+	if e.Level != ecslogs.ERROR || e.Message != "connection lost" || e.Time.Hour() != 5 {
+		t.Errorf("invalid event: %#v", e)
+	}
+
+	if !reflect.DeepEqual(e.Data, ecslogs.EventData{"component": "db"}) {
+		t.Error("invalid data:", e.Data)
+	}
+}
+
+func TestParseCombined(t *testing.T) {
+	e, ok := parseLine(t, Rule{Format: "combined"}, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 404 2326 "http://example.com/" "Mozilla/4.08"`)
+
+	if !ok {
+		t.Fatal("the message should have been parsed")
+	}
+
+	expected := ecslogs.EventData{
+		"remote_addr": "127.0.0.1",
+		"remote_user": "frank",
+		"method":      "GET",
+		"path":        "/a.gif",
+		"protocol":    "HTTP/1.0",
+		"status":      404,
+		"bytes":       2326,
+		"referer":     "http://example.com/",
+		"user_agent":  "Mozilla/4.08",
+	}
+
+	if !reflect.DeepEqual(e.Data, expected) {
+		t.Errorf("invalid data:\n- expected: %#v\n- found:    %#v", expected, e.Data)
+	}
+
+	if e.Level != ecslogs.WARN {
+		t.Error("invalid level:", e.Level)
+	}
+
+	if e.Time.UTC().Hour() != 20 {
+		t.Error("invalid time:", e.Time)
+	}
+}
+
+func TestParseKlog(t *testing.T) {
+	e, ok := parseLine(t, Rule{Format: "klog"}, "E0101 05:06:07.123456   12345 server.go:42] request failed\ngoroutine 1 [running]:")
+
+	if !ok {
+		t.Fatal("the message should have been parsed")
+	}
+
+	if e.Level != ecslogs.ERROR || e.Message != "request failed\ngoroutine 1 [running]:" || e.Info.PID != 12345 || e.Info.Source != "server.go:42" {
+		t.Errorf("invalid event: %#v", e)
+	}
+
+	if expected := time.Date(2017, 1, 1, 5, 6, 7, 123456000, time.Local); !e.Time.Equal(expected) {
+		t.Error("invalid time:", e.Time)
+	}
+}
+
+func TestParseKlogTime(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 10, 0, time.Local)
+
+	tests := []struct {
+		s    string
+		time time.Time
+	}{
+		{"0101 00:00:09.000000", time.Date(2017, 1, 1, 0, 0, 9, 0, time.Local)},
+		{"0101 00:01:00.000000", time.Date(2017, 1, 1, 0, 1, 0, 0, time.Local)},
+		{"1231 23:59:59.000000", time.Date(2016, 12, 31, 23, 59, 59, 0, time.Local)},
+	}
+
+	for _, test := range tests {
+		if tm, err := parseKlogTime(test.s, now); err != nil || !tm.Equal(test.time) {
+			t.Errorf("%s: invalid time: %s (%v)", test.s, tm, err)
+		}
+	}
+}
+
+func TestNewError(t *testing.T) {
+	tests := []Rule{
+		{},
+		{Format: "whatever"},
+		{Format: "regex"},
+		{Format: "regex", Pattern: "("},
+		{Format: "logfmt", Pattern: "A"},
+	}
+
+	for _, rule := range tests {
+		if _, err := New([]Rule{rule}); err == nil {
+			t.Errorf("%#v: expected an error", rule)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// Action defines what happens to the values where sensitive data was found.
+type Action string
+
+const (
+	// Mask replaces the sensitive data with "[REDACTED]".
+	Mask Action = "mask"
+
+	// Hash replaces the sensitive data with a keyed hash of its value, so
+	// equal values can still be correlated.
+	Hash Action = "hash"
+
+	// Drop removes the whole field that contained sensitive data, or clears
+	// the message when it's found in the event message.
+	Drop Action = "drop"
+)
+
+// Config represents the options of the redaction stage.
+type Config struct {
+	// The key used to compute the HMAC of hashed values, it's required by
+	// rules which action is hash.
+	HashKey string `json:"hash_key"`
+
+	Rules []Rule `json:"rules"`
+}
+
+// A Rule detects sensitive data with one of the built-in detectors or with a
+// regular expression, the action defaults to Mask.
+type Rule struct {
+	Detector string `json:"detector"`
+	Pattern  string `json:"pattern"`
+	Action   Action `json:"action"`
+}
+
+type detector struct {
+	expr  string
+	valid func(string) bool
+}
+
+var detectors = map[string]detector{
+	"aws_access_key": {expr: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+	"jwt":            {expr: `\beyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+`},
+	"email":          {expr: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`},
+	"credit_card":    {expr: `\b(?:\d[ -]?){12,18}\d\b`, valid: luhn},
+}
+
+// Detectors returns the sorted list of built-in detector names.
+func Detectors() []string {
+	names := make([]string, 0, len(detectors))
+
+	for name := range detectors {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// A Redactor removes sensitive data from messages.
+type Redactor struct {
+	rules []rule
+	key   []byte
+}
+
+type rule struct {
+	re     *regexp.Regexp
+	valid  func(string) bool
+	action Action
+}
+
+// New compiles the redaction rules in config.
+func New(config Config) (*Redactor, error) {
+	r := &Redactor{
+		rules: make([]rule, 0, len(config.Rules)),
+		key:   []byte(config.HashKey),
+	}
+
+	for i, c := range config.Rules {
+		var x rule
+		var expr string
+
+		switch {
+		case len(c.Detector) != 0 && len(c.Pattern) != 0:
+			return nil, fmt.Errorf("redaction rule #%d: detector and pattern cannot be both set", i+1)
+
+		case len(c.Detector) != 0:
+			d, ok := detectors[c.Detector]
+			if !ok {
+				return nil, fmt.Errorf("redaction rule #%d: unknown detector %q, must be one of %s", i+1, c.Detector, strings.Join(Detectors(), ", "))
+			}
+			expr, x.valid = d.expr, d.valid
+
+		case len(c.Pattern) != 0:
+			expr = c.Pattern
+
+		default:
+			return nil, fmt.Errorf("redaction rule #%d: one of detector or pattern must be set", i+1)
+		}
+
+		switch x.action = c.Action; x.action {
+		case "":
+			x.action = Mask
+		case Hash:
+			// Unkeyed hashes of short values like emails can be reversed by
+			// hashing candidate values.
+			if len(config.HashKey) == 0 {
+				return nil, fmt.Errorf("redaction rule #%d: the hash action requires a hash_key", i+1)
+			}
+		case Mask, Drop:
+		default:
+			return nil, fmt.Errorf("redaction rule #%d: invalid action, must be one of 'mask', 'hash' or 'drop': %s", i+1, c.Action)
+		}
+
+		var err error
+
+		if x.re, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("redaction rule #%d: %s", i+1, err)
+		}
+
+		r.rules = append(r.rules, x)
+	}
+
+	return r, nil
+}
+
+// Apply redacts the message, event data and error strings of msg. The event
+// data are modified in place so they must not be shared with other messages.
+func (r *Redactor) Apply(msg *lib.Message) {
+	if s, drop := r.redact(msg.Event.Message); drop {
+		msg.Event.Message = ""
+	} else {
+		msg.Event.Message = s
+	}
+
+	errors := msg.Event.Info.Errors[:0]
+
+	for _, e := range msg.Event.Info.Errors {
+		var drop bool
+
+		if e.Error, drop = r.redact(e.Error); !drop {
+			errors = append(errors, e)
+		}
+	}
+
+	if len(errors) == 0 {
+		errors = nil
+	}
+
+	msg.Event.Info.Errors = errors
+	r.redactMap(msg.Event.Data)
+}
+
+func (r *Redactor) redactMap(m map[string]interface{}) {
+	for k, v := range m {
+		if v, drop := r.redactValue(v); drop {
+			delete(m, k)
+		} else {
+			m[k] = v
+		}
+	}
+}
+
+func (r *Redactor) redactValue(v interface{}) (interface{}, bool) {
+	switch x := v.(type) {
+	case string:
+		return r.redact(x)
+
+	case json.Number:
+		s, drop := r.redact(x.String())
+		if s == x.String() {
+			return x, drop
+		}
+		return s, drop
+
+	case map[string]interface{}:
+		r.redactMap(x)
+
+	case ecslogs.EventData:
+		r.redactMap(x)
+
+	case []interface{}:
+		list := x[:0]
+		for _, item := range x {
+			if item, drop := r.redactValue(item); !drop {
+				list = append(list, item)
+			}
+		}
+		return list, false
+	}
+
+	return v, false
+}
+
+// redact returns s with the sensitive data replaced, or true if s contained
+// data that must be dropped.
+func (r *Redactor) redact(s string) (string, bool) {
+	if len(s) == 0 {
+		return s, false
+	}
+
+	for _, x := range r.rules {
+		drop := false
+
+		s = x.re.ReplaceAllStringFunc(s, func(m string) string {
+			if x.valid != nil && !x.valid(m) {
+				return m
+			}
+
+			switch x.action {
+			case Hash:
+				return r.hash(m)
+			case Drop:
+				drop = true
+			}
+
+			return "[REDACTED]"
+		})
+
+		if drop {
+			return "", true
+		}
+	}
+
+	return s, false
+}
+
+func (r *Redactor) hash(s string) string {
+	h := hmac.New(sha256.New, r.key)
+	h.Write([]byte(s))
+	return "[HASH:" + hex.EncodeToString(h.Sum(nil)[:8]) + "]"
+}
+
+// luhn validates the check digit of card numbers, separators are ignored.
+func luhn(s string) bool {
+	sum := 0
+	count := 0
+
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+
+		if count%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		count++
+	}
+
+	return count >= 13 && count <= 19 && sum%10 == 0
+}
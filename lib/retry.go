@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jpillora/backoff"
+)
+
+type RetryConfig struct {
+	// The maximum number of times a batch write is attempted, values lower
+	// than one disable retries.
+	MaxAttempts int
+
+	// The bounds of the exponential backoff applied between two attempts.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// NewRetryDestination wraps dest so writes that fail are retried with an
+// exponential backoff. Each attempt reopens a writer, because most writers
+// cannot be used anymore after they returned an error.
+//
+// Errors marked with PermanentError are not retried, and only the messages
+// reported by errors created with PartialError are written again.
+func NewRetryDestination(dest Destination, config RetryConfig) Destination {
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+
+	if config.MinDelay <= 0 {
+		config.MinDelay = 100 * time.Millisecond
+	}
+
+	if config.MaxDelay < config.MinDelay {
+		config.MaxDelay = config.MinDelay
+	}
+
+	return retryDestination{
+		Destination: dest,
+		config:      config,
+	}
+}
+
+type retryDestination struct {
+	Destination
+	config RetryConfig
+}
+
+func (d retryDestination) Flush() error {
+	return FlushDestination(d.Destination)
+}
+
+func (d retryDestination) Open(group string, stream string) (Writer, error) {
+	return &retryWriter{
+		dest:   d.Destination,
+		group:  group,
+		stream: stream,
+		config: d.config,
+	}, nil
+}
+
+type retryWriter struct {
+	dest   Destination
+	group  string
+	stream string
+	config RetryConfig
+	writer Writer
+}
+
+func (w *retryWriter) Close() (err error) {
+	if w.writer != nil {
+		err = w.writer.Close()
+		w.writer = nil
+	}
+	return
+}
+
+func (w *retryWriter) WriteMessage(msg Message) error {
+	return w.WriteMessageBatch(MessageBatch{msg})
+}
+
+func (w *retryWriter) WriteMessageBatch(batch MessageBatch) (err error) {
+	b := &backoff.Backoff{
+		Factor: 2,
+		Jitter: true,
+		Min:    w.config.MinDelay,
+		Max:    w.config.MaxDelay,
+	}
+
+	for attempt := 1; true; attempt++ {
+		if err = w.write(batch); err == nil {
+			break
+		}
+
+		if attempt >= w.config.MaxAttempts || IsPermanentError(err) {
+			break
+		}
+
+		if failed, ok := FailedMessages(err); ok {
+			batch = failed
+		}
+
+		delay := b.Duration()
+
+		log.WithFields(log.Fields{
+			"group":   w.group,
+			"stream":  w.stream,
+			"error":   err,
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("retrying message batch")
+
+		time.Sleep(delay)
+	}
+
+	return
+}
+
+func (w *retryWriter) write(batch MessageBatch) (err error) {
+	if w.writer == nil {
+		if w.writer, err = w.dest.Open(w.group, w.stream); err != nil {
+			w.writer = nil
+			return
+		}
+	}
+
+	if err = w.writer.WriteMessageBatch(batch); err != nil {
+		w.writer.Close()
+		w.writer = nil
+	}
+
+	return
+}
@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/segmentio/ecs-logs-go"
+)
+
+func TestRetryDestination(t *testing.T) {
+	log.SetHandler(discard.New())
+
+	tests := []struct {
+		errors   []error
+		attempts int
+		failed   bool
+	}{
+		{
+			errors:   nil,
+			attempts: 1,
+		},
+		{
+			errors:   []error{errors.New("A"), errors.New("B")},
+			attempts: 3,
+		},
+		{
+			errors:   []error{errors.New("A"), errors.New("B"), errors.New("C")},
+			attempts: 3,
+			failed:   true,
+		},
+		{
+			errors:   []error{PermanentError(errors.New("A"))},
+			attempts: 1,
+			failed:   true,
+		},
+	}
+
+	for _, test := range tests {
+		dest := &failingDestination{errors: test.errors}
+		retry := NewRetryDestination(dest, RetryConfig{
+			MaxAttempts: 3,
+			MinDelay:    time.Millisecond,
+			MaxDelay:    time.Millisecond,
+		})
+
+		w, _ := retry.Open("A", "B")
+		err := w.WriteMessageBatch(MessageBatch{Message{Group: "A", Stream: "B"}})
+		w.Close()
+
+		if test.failed && err == nil {
+			t.Error("expected an error but the write succeeded")
+		}
+
+		if !test.failed && err != nil {
+			t.Error(err)
+		}
+
+		if dest.attempts != test.attempts {
+			t.Errorf("invalid number of attempts: %d != %d", dest.attempts, test.attempts)
+		}
+	}
+}
+
+func TestRetryPartialError(t *testing.T) {
+	batch := MessageBatch{
+		Message{Group: "A", Stream: "B", Event: ecslogs.Event{Message: "1"}},
+		Message{Group: "A", Stream: "B", Event: ecslogs.Event{Message: "2"}},
+		Message{Group: "A", Stream: "B", Event: ecslogs.Event{Message: "3"}},
+	}
+
+	dest := &failingDestination{errors: []error{PartialError(errors.New("A"), batch[1:2])}}
+	retry := NewRetryDestination(dest, RetryConfig{
+		MaxAttempts: 3,
+		MinDelay:    time.Millisecond,
+		MaxDelay:    time.Millisecond,
+	})
+
+	w, _ := retry.Open("A", "B")
+	defer w.Close()
+
+	if err := w.WriteMessageBatch(batch); err != nil {
+		t.Error(err)
+	}
+
+	if len(dest.batches) != 2 || len(dest.batches[1]) != 1 || dest.batches[1][0].Event.Message != "2" {
+		t.Errorf("only the failed message should have been written again: %v", dest.batches)
+	}
+
+	if failed, ok := FailedMessages(PermanentError(PartialError(errors.New("B"), batch[:1]))); !ok || len(failed) != 1 {
+		t.Error("the failed messages should be found in wrapped errors")
+	}
+
+	if _, ok := FailedMessages(errors.New("C")); ok {
+		t.Error("errors should not have failed messages by default")
+	}
+}
+
+func TestIsPermanentError(t *testing.T) {
+	err := errors.New("A")
+
+	if IsPermanentError(err) {
+		t.Error("errors should not be permanent by default")
+	}
+
+	if !IsPermanentError(PermanentError(err)) {
+		t.Error("errors wrapped by PermanentError should be permanent")
+	}
+
+	if PermanentError(nil) != nil {
+		t.Error("wrapping a nil error should return nil")
+	}
+}
+
+// The failingDestination type is used to mock destinations which return
+// errors on the first write attempts.
+type failingDestination struct {
+	errors   []error
+	attempts int
+	batches  []MessageBatch
+}
+
+func (d *failingDestination) Open(group string, stream string) (Writer, error) {
+	return failingWriter{d}, nil
+}
+
+func (d *failingDestination) Close(group string, stream string) {}
+
+type failingWriter struct {
+	dest *failingDestination
+}
+
+func (w failingWriter) Close() error { return nil }
+
+func (w failingWriter) WriteMessage(msg Message) error {
+	return w.WriteMessageBatch(MessageBatch{msg})
+}
+
+func (w failingWriter) WriteMessageBatch(batch MessageBatch) (err error) {
+	w.dest.batches = append(w.dest.batches, batch)
+
+	if w.dest.attempts++; len(w.dest.errors) != 0 {
+		err, w.dest.errors = w.dest.errors[0], w.dest.errors[1:]
+	}
+	return
+}
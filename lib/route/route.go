@@ -0,0 +1,100 @@
+package route
+
+import (
+	"fmt"
+
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+)
+
+// A Rule sends the messages matching its conditions to a set of destinations.
+type Rule struct {
+	match.Config
+	Destinations []string `json:"destinations"`
+}
+
+// A Router selects the destinations of messages from a list of rules, each
+// message is sent to the destinations of all the rules it matches. Messages
+// that match no rules are not sent anywhere.
+type Router struct {
+	routes []route
+	count  int
+}
+
+type route struct {
+	matcher      *match.Matcher
+	destinations []int
+}
+
+// NewRouter compiles rules into a router, destinations is the list of names of
+// the destinations that rules can refer to.
+func NewRouter(rules []Rule, destinations []string) (*Router, error) {
+	index := make(map[string]int, len(destinations))
+
+	for i, name := range destinations {
+		index[name] = i
+	}
+
+	r := &Router{
+		routes: make([]route, 0, len(rules)),
+		count:  len(destinations),
+	}
+
+	for i, rule := range rules {
+		m, err := match.New(rule.Config)
+
+		if err != nil {
+			return nil, fmt.Errorf("route #%d: %s", i+1, err)
+		}
+
+		if len(rule.Destinations) == 0 {
+			return nil, fmt.Errorf("route #%d: no destinations", i+1)
+		}
+
+		rt := route{matcher: m}
+
+		for _, name := range rule.Destinations {
+			j, ok := index[name]
+
+			if !ok {
+				return nil, fmt.Errorf("route #%d: unknown destination %q", i+1, name)
+			}
+
+			rt.destinations = append(rt.destinations, j)
+		}
+
+		r.routes = append(r.routes, rt)
+	}
+
+	return r, nil
+}
+
+// Split returns the messages of batch that go to each destination, the
+// returned slice is indexed like the list of destinations that the router was
+// created with.
+func (r *Router) Split(batch lib.MessageBatch) []lib.MessageBatch {
+	batches := make([]lib.MessageBatch, r.count)
+	selected := make([]bool, r.count)
+
+	for _, msg := range batch {
+		for i := range selected {
+			selected[i] = false
+		}
+
+		for _, rt := range r.routes {
+			if rt.matcher.Match(msg) {
+				for _, i := range rt.destinations {
+					selected[i] = true
+				}
+			}
+		}
+
+		for i, ok := range selected {
+			if ok {
+				batches[i] = append(batches[i], msg)
+			}
+		}
+	}
+
+	return batches
+}
@@ -0,0 +1,47 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+)
+
+func TestRouter(t *testing.T) {
+	r, err := NewRouter([]Rule{
+		{Config: match.Config{Group: "billing"}, Destinations: []string{"A"}},
+		{Config: match.Config{MinLevel: "ERROR"}, Destinations: []string{"A", "B"}},
+	}, []string{"A", "B", "C"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m1 := lib.Message{Group: "billing", Stream: "1", Event: ecslogs.Event{Level: ecslogs.INFO}}
+	m2 := lib.Message{Group: "search", Stream: "1", Event: ecslogs.Event{Level: ecslogs.ERROR}}
+	m3 := lib.Message{Group: "search", Stream: "1", Event: ecslogs.Event{Level: ecslogs.INFO}}
+
+	batches := r.Split(lib.MessageBatch{m1, m2, m3})
+
+	expected := []lib.MessageBatch{
+		{m1, m2},
+		{m2},
+		nil,
+	}
+
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("invalid batches:\n- expected: %#v\n- found:    %#v", expected, batches)
+	}
+}
+
+func TestRouterError(t *testing.T) {
+	if _, err := NewRouter([]Rule{{Destinations: []string{"D"}}}, []string{"A"}); err == nil {
+		t.Error("expected an error for an unknown destination")
+	}
+
+	if _, err := NewRouter([]Rule{{Config: match.Config{Group: "A"}}}, []string{"A"}); err == nil {
+		t.Error("expected an error for a route without destinations")
+	}
+}
@@ -0,0 +1,699 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/klauspost/compress/zstd"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/awsutil"
+)
+
+const (
+	DefaultKey           = "{group}/{yyyy}/{mm}/{dd}/{hh}/{host}-{seq}.json"
+	DefaultMaxObjectSize = 100 * 1024 * 1024
+	DefaultMaxObjectAge  = lib.Duration(5 * time.Minute)
+	DefaultMaxBufferSize = 100 * 1024 * 1024
+
+	// S3 doesn't accept multipart uploads with parts smaller than 5 MB, except
+	// for the last one.
+	MinPartSize = 5 * 1024 * 1024
+)
+
+// The compression formats of objects, and the extensions added to the default
+// key.
+var compressions = map[string]struct {
+	ext    string
+	writer func(io.Writer) io.WriteCloser
+}{
+	"gzip": {".gz", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+	"zstd": {".zst", newZstdWriter},
+	"none": {"", func(w io.Writer) io.WriteCloser { return nopCloser{w} }},
+}
+
+func newZstdWriter(w io.Writer) io.WriteCloser {
+	// The encoder only fails with invalid options.
+	z, _ := zstd.NewWriter(w)
+	return z
+}
+
+// Config represents the options of s3 destinations.
+type Config struct {
+	Bucket string `json:"bucket"`
+
+	// The template of object keys, see Key. It defaults to DefaultKey with the
+	// extension of the compression format.
+	Key string `json:"key"`
+
+	// The region of the bucket, it's detected from the environment when it's
+	// not set, and the URL of an S3 compatible service to use instead of AWS.
+	Region   string `json:"region"`
+	Endpoint string `json:"endpoint"`
+
+	// The compression of objects, "gzip" (default), "zstd" or "none".
+	Compression string `json:"compression"`
+
+	// Objects are uploaded when their compressed size reaches MaxObjectSize or
+	// after MaxObjectAge, the ones larger than PartSize are sent with
+	// multipart uploads.
+	MaxObjectSize int64        `json:"max_object_size"`
+	MaxObjectAge  lib.Duration `json:"max_object_age"`
+	PartSize      int64        `json:"part_size"`
+
+	// The maximum number of compressed bytes buffered by all streams, the
+	// messages are released from the memory budget of the program once they
+	// are written so the destination bounds them itself. Streams writing
+	// messages while the limit is exceeded upload what they buffered early.
+	MaxBufferSize int64 `json:"max_buffer_size"`
+
+	// The value of {host} in object keys, it defaults to the hostname.
+	Host string `json:"host"`
+}
+
+func (c Config) validate() error {
+	if len(c.Bucket) == 0 {
+		return errors.New("missing s3 bucket")
+	}
+
+	if _, ok := compressions[c.Compression]; !ok && len(c.Compression) != 0 {
+		return fmt.Errorf("invalid s3 compression, must be one of 'gzip', 'zstd' or 'none': %s", c.Compression)
+	}
+
+	if c.PartSize != 0 && c.PartSize < MinPartSize {
+		return fmt.Errorf("invalid s3 part size, it must be at least %d bytes: %d", MinPartSize, c.PartSize)
+	}
+
+	if c.MaxObjectSize < 0 {
+		return fmt.Errorf("invalid s3 max object size: %d", c.MaxObjectSize)
+	}
+
+	if c.MaxBufferSize < 0 {
+		return fmt.Errorf("invalid s3 max buffer size: %d", c.MaxBufferSize)
+	}
+
+	if len(c.Key) != 0 {
+		if _, err := ParseKey(c.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func configFromEnv() Config {
+	return Config{
+		Bucket:      os.Getenv("S3_BUCKET"),
+		Key:         os.Getenv("S3_KEY"),
+		Region:      os.Getenv("S3_REGION"),
+		Endpoint:    os.Getenv("S3_ENDPOINT"),
+		Compression: os.Getenv("S3_COMPRESSION"),
+	}
+}
+
+// NewDestination returns a destination which archives messages to the bucket
+// configured by config, errors in the configuration are reported immediately.
+//
+// Messages are buffered in memory until their object is uploaded, so writes
+// return before the messages are stored in S3, but their positions are only
+// committed once the object was uploaded.
+func NewDestination(config Config) (lib.Destination, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return newDestination(config), nil
+}
+
+func newDestination(config Config) *destination {
+	if len(config.Compression) == 0 {
+		config.Compression = "gzip"
+	}
+
+	if len(config.Key) == 0 {
+		config.Key = DefaultKey + compressions[config.Compression].ext
+	}
+
+	if config.MaxObjectSize == 0 {
+		config.MaxObjectSize = DefaultMaxObjectSize
+	}
+
+	if config.MaxObjectAge <= 0 {
+		config.MaxObjectAge = DefaultMaxObjectAge
+	}
+
+	if config.PartSize == 0 {
+		config.PartSize = MinPartSize
+	}
+
+	if config.MaxBufferSize == 0 {
+		config.MaxBufferSize = DefaultMaxBufferSize
+	}
+
+	if len(config.Host) == 0 {
+		config.Host, _ = os.Hostname()
+	}
+
+	key, _ := ParseKey(config.Key)
+
+	return &destination{
+		config:  config,
+		key:     key,
+		start:   time.Now().Unix(),
+		streams: make(map[string]*stream),
+	}
+}
+
+// envDestination lazily creates the destination configured by the environment
+// so the configuration is only validated when it's used.
+type envDestination struct {
+	once sync.Once
+	dest *destination
+	err  error
+}
+
+func (d *envDestination) get() (*destination, error) {
+	d.once.Do(func() {
+		config := configFromEnv()
+
+		// Configuration errors won't go away by retrying the write operation.
+		if d.err = config.validate(); d.err != nil {
+			d.err = lib.PermanentError(d.err)
+			return
+		}
+
+		d.dest = newDestination(config)
+	})
+	return d.dest, d.err
+}
+
+func (d *envDestination) Open(group string, stream string) (lib.Writer, error) {
+	dest, err := d.get()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return dest.Open(group, stream)
+}
+
+func (d *envDestination) Close(group string, stream string) {
+	if dest, _ := d.get(); dest != nil {
+		dest.Close(group, stream)
+	}
+}
+
+func (d *envDestination) Flush() error {
+	if dest, _ := d.get(); dest != nil {
+		return dest.Flush()
+	}
+	return nil
+}
+
+type destination struct {
+	config Config
+	key    Key
+	start  int64
+	seq    uint64
+
+	cmtx   sync.Mutex
+	client *s3.S3
+
+	smtx    sync.Mutex
+	streams map[string]*stream
+
+	// The uploads started by Close, which Flush waits for.
+	join sync.WaitGroup
+
+	// The number of bytes buffered by the objects of all streams.
+	buffered int64
+}
+
+// A stream holds the object which the messages of a group and stream are
+// written to.
+type stream struct {
+	sync.Mutex
+	group  string
+	name   string
+	object *object
+	timer  *time.Timer
+
+	// Set when the stream was removed from the destination, writers must get
+	// the stream again so their messages aren't added to an object that would
+	// never be uploaded.
+	removed bool
+}
+
+type object struct {
+	key       string
+	partition string
+	created   time.Time
+	closed    bool
+
+	// The compressed content that wasn't uploaded yet, and the number of bytes
+	// that were.
+	buf      bytes.Buffer
+	enc      io.WriteCloser
+	uploaded int64
+
+	// The size of buf the last time it was counted in the bytes buffered by
+	// the destination.
+	counted int64
+
+	uploadID string
+	parts    []*s3.CompletedPart
+
+	// Release the positions of the messages of the object once it was
+	// uploaded.
+	releases []func() error
+}
+
+func (o *object) size() int64 {
+	return o.uploaded + int64(o.buf.Len())
+}
+
+func (d *destination) Open(group string, stream string) (lib.Writer, error) {
+	if _, err := d.getClient(); err != nil {
+		return nil, err
+	}
+	return &writer{dest: d, stream: d.get(group, stream)}, nil
+}
+
+// Close uploads the object of a stream that expired, the upload runs in the
+// background so the program doesn't wait for it.
+func (d *destination) Close(group string, stream string) {
+	key := group + ":" + stream
+
+	d.smtx.Lock()
+	s := d.streams[key]
+	d.smtx.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	d.join.Add(1)
+
+	go func() {
+		defer d.join.Done()
+
+		s.Lock()
+		defer s.Unlock()
+		d.flush(s)
+
+		// Streams with objects that couldn't be uploaded are kept so they're
+		// flushed when the program exits.
+		if s.object == nil {
+			d.smtx.Lock()
+			if d.streams[key] == s {
+				delete(d.streams, key)
+			}
+			d.smtx.Unlock()
+			s.removed = true
+		}
+	}()
+}
+
+// Flush uploads the objects of all streams, the multipart uploads of objects
+// that still can't be uploaded are aborted.
+func (d *destination) Flush() (err error) {
+	d.join.Wait()
+	d.smtx.Lock()
+	streams := make([]*stream, 0, len(d.streams))
+
+	for _, s := range d.streams {
+		streams = append(streams, s)
+	}
+
+	d.smtx.Unlock()
+
+	for _, s := range streams {
+		s.Lock()
+
+		if e := d.finish(s); e != nil {
+			err = lib.AppendError(err, e)
+			d.abort(s.object)
+		}
+
+		s.Unlock()
+	}
+
+	return
+}
+
+func (d *destination) get(group string, name string) (s *stream) {
+	key := group + ":" + name
+	d.smtx.Lock()
+
+	if s = d.streams[key]; s == nil {
+		s = &stream{group: group, name: name}
+		d.streams[key] = s
+	}
+
+	d.smtx.Unlock()
+	return
+}
+
+func (d *destination) getClient() (client *s3.S3, err error) {
+	d.cmtx.Lock()
+	defer d.cmtx.Unlock()
+
+	if client = d.client; client == nil {
+		sess, e := awsutil.NewSession(d.config.Region, d.config.Endpoint)
+
+		if e != nil {
+			err = e
+			return
+		}
+
+		// S3 compatible services usually don't support virtual hosted buckets.
+		client = s3.New(sess, &aws.Config{
+			S3ForcePathStyle: aws.Bool(len(d.config.Endpoint) != 0),
+		})
+		d.client = client
+	}
+
+	return
+}
+
+func (d *destination) write(w *writer, batch lib.MessageBatch) (err error) {
+	s := w.lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	start := 0
+
+	// The positions of the messages written to an object are held until the
+	// object is uploaded, they must be held before the object can be
+	// uploaded and released.
+	hold := func(end int) {
+		if o := s.object; o != nil && end > start {
+			o.releases = append(o.releases, lib.HoldBatch(batch[start:end]))
+		}
+		start = end
+	}
+
+	for i, msg := range batch {
+		partition := d.key.Expand(s.group, s.name, d.config.Host, "", msg.Event.Time)
+
+		if o := s.object; o != nil {
+			if o.closed || o.partition != partition || o.size() >= d.config.MaxObjectSize || now.Sub(o.created) >= time.Duration(d.config.MaxObjectAge) {
+				hold(i)
+
+				if err = d.finish(s); err != nil {
+					return lib.PartialError(err, batch[i:])
+				}
+			}
+		}
+
+		if s.object == nil {
+			d.create(s, partition, msg.Event.Time, now)
+		}
+
+		o := s.object
+
+		if int64(o.buf.Len()) >= d.config.PartSize {
+			if err = d.uploadPart(o); err != nil {
+				hold(i)
+				return lib.PartialError(err, batch[i:])
+			}
+		}
+
+		if _, err = o.enc.Write(append(msg.Bytes(), '\n')); err != nil {
+			hold(i)
+			return lib.PartialError(err, batch[i:])
+		}
+
+		d.count(o)
+
+		if atomic.LoadInt64(&d.buffered) > d.config.MaxBufferSize {
+			hold(i + 1)
+
+			if err = d.drain(s); err != nil {
+				return lib.PartialError(err, batch[i+1:])
+			}
+		}
+	}
+
+	hold(len(batch))
+	return
+}
+
+// count updates the number of bytes buffered by the destination with the
+// current size of the buffer of o.
+func (d *destination) count(o *object) {
+	n := int64(o.buf.Len())
+	atomic.AddInt64(&d.buffered, n-o.counted)
+	o.counted = n
+}
+
+// drain releases the memory buffered by the object of s when the destination
+// buffers too much, the content is uploaded as the next part when it's large
+// enough or the object is uploaded early otherwise.
+func (d *destination) drain(s *stream) error {
+	if o := s.object; int64(o.buf.Len()) >= MinPartSize {
+		return d.uploadPart(o)
+	}
+	return d.finish(s)
+}
+
+func (d *destination) create(s *stream, partition string, t time.Time, now time.Time) {
+	// The sequence number starts with the time the program started so objects
+	// created after a restart don't overwrite the previous ones.
+	seq := fmt.Sprintf("%d-%06d", d.start, atomic.AddUint64(&d.seq, 1))
+
+	o := &object{
+		key:       d.key.Expand(s.group, s.name, d.config.Host, seq, t),
+		partition: partition,
+		created:   now,
+	}
+
+	o.enc = compressions[d.config.Compression].writer(&o.buf)
+	s.object = o
+
+	d.schedule(s, o)
+}
+
+// schedule arms the timer which uploads o when it reaches the maximum age.
+func (d *destination) schedule(s *stream, o *object) {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	s.timer = time.AfterFunc(time.Duration(d.config.MaxObjectAge), func() {
+		s.Lock()
+		defer s.Unlock()
+
+		if s.object == o {
+			d.flush(s)
+		}
+	})
+}
+
+// flush uploads the object of s and logs the error if it fails, the object is
+// kept and uploading it is attempted again on the next write or when the
+// object expires again.
+func (d *destination) flush(s *stream) {
+	o := s.object
+
+	if err := d.finish(s); err != nil {
+		log.WithFields(log.Fields{
+			"group":  s.group,
+			"stream": s.name,
+			"key":    o.key,
+			"error":  err,
+		}).Error("failed to upload s3 object")
+
+		d.schedule(s, o)
+	}
+}
+
+// finish uploads the object of s, the object is kept if it fails so the upload
+// can be attempted again.
+func (d *destination) finish(s *stream) (err error) {
+	var client *s3.S3
+	var o = s.object
+
+	if o == nil {
+		return
+	}
+
+	if !o.closed {
+		if err = o.enc.Close(); err != nil {
+			return
+		}
+		o.closed = true
+		d.count(o)
+	}
+
+	if client, err = d.getClient(); err != nil {
+		return
+	}
+
+	if len(o.uploadID) == 0 {
+		if _, err = client.PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(d.config.Bucket),
+			Key:         aws.String(o.key),
+			Body:        bytes.NewReader(o.buf.Bytes()),
+			ContentType: aws.String("application/x-ndjson"),
+		}); err != nil {
+			return
+		}
+	} else {
+		if o.buf.Len() != 0 {
+			if err = d.uploadPart(o); err != nil {
+				return
+			}
+		}
+
+		if _, err = client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(d.config.Bucket),
+			Key:             aws.String(o.key),
+			UploadId:        aws.String(o.uploadID),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: o.parts},
+		}); err != nil {
+			return
+		}
+	}
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	s.object = nil
+	o.buf.Reset()
+	d.count(o)
+
+	for _, release := range o.releases {
+		if e := release(); e != nil {
+			log.WithFields(log.Fields{
+				"group":  s.group,
+				"stream": s.name,
+				"error":  e,
+			}).Error("failed to commit the positions of uploaded messages")
+		}
+	}
+
+	return
+}
+
+// abort aborts the multipart upload of o, if it has one, so the parts that
+// were uploaded aren't kept in the bucket.
+func (d *destination) abort(o *object) {
+	if o == nil || len(o.uploadID) == 0 {
+		return
+	}
+
+	client, err := d.getClient()
+
+	if err == nil {
+		_, err = client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(d.config.Bucket),
+			Key:      aws.String(o.key),
+			UploadId: aws.String(o.uploadID),
+		})
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key":   o.key,
+			"error": err,
+		}).Error("failed to abort s3 multipart upload")
+	}
+}
+
+// uploadPart uploads the buffered content of o as the next part of its
+// multipart upload, which is started by the first part.
+func (d *destination) uploadPart(o *object) (err error) {
+	var client *s3.S3
+	var part *s3.UploadPartOutput
+
+	if client, err = d.getClient(); err != nil {
+		return
+	}
+
+	if len(o.uploadID) == 0 {
+		var res *s3.CreateMultipartUploadOutput
+
+		if res, err = client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(d.config.Bucket),
+			Key:         aws.String(o.key),
+			ContentType: aws.String("application/x-ndjson"),
+		}); err != nil {
+			return
+		}
+
+		o.uploadID = aws.StringValue(res.UploadId)
+	}
+
+	number := int64(len(o.parts) + 1)
+
+	if part, err = client.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String(d.config.Bucket),
+		Key:        aws.String(o.key),
+		UploadId:   aws.String(o.uploadID),
+		PartNumber: aws.Int64(number),
+		Body:       bytes.NewReader(o.buf.Bytes()),
+	}); err != nil {
+		return
+	}
+
+	o.parts = append(o.parts, &s3.CompletedPart{
+		ETag:       part.ETag,
+		PartNumber: aws.Int64(number),
+	})
+	o.uploaded += int64(o.buf.Len())
+	o.buf.Reset()
+	d.count(o)
+	return
+}
+
+type writer struct {
+	dest   *destination
+	stream *stream
+}
+
+// lock locks the stream of w, the stream is replaced first if it was removed
+// from the destination since the writer was opened.
+func (w *writer) lock() *stream {
+	for {
+		s := w.stream
+		s.Lock()
+
+		if !s.removed {
+			return s
+		}
+
+		s.Unlock()
+		w.stream = w.dest.get(s.group, s.name)
+	}
+}
+
+func (w *writer) Close() error {
+	return nil
+}
+
+func (w *writer) WriteMessage(msg lib.Message) error {
+	return w.WriteMessageBatch(lib.MessageBatch{msg})
+}
+
+func (w *writer) WriteMessageBatch(batch lib.MessageBatch) error {
+	return w.dest.write(w, batch)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
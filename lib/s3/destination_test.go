@@ -0,0 +1,524 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func init() {
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+}
+
+func TestParseKey(t *testing.T) {
+	date := time.Date(2024, 3, 7, 23, 0, 0, 0, time.FixedZone("", -3600))
+
+	tests := []struct {
+		template string
+		key      string
+	}{
+		{DefaultKey, "ecs/web/2024/03/08/00/host-1.json"},
+		{"logs/{stream}/{yyyy}{mm}{dd}-{seq}", "logs/1/20240308-1"},
+	}
+
+	for _, test := range tests {
+		key, err := ParseKey(test.template)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.template, err)
+			continue
+		}
+
+		if s := key.Expand("/ecs/web", "1", "host", "1", date); s != test.key {
+			t.Errorf("%s: invalid key: %s != %s", test.template, test.key, s)
+		}
+	}
+
+	for _, template := range []string{"{group}/{yyyy}", "{group}-{seq", "{region}-{seq}"} {
+		if _, err := ParseKey(template); err == nil {
+			t.Errorf("%s: expected an error", template)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{Bucket: "A"}, true},
+		{Config{Bucket: "A", Compression: "none", PartSize: MinPartSize}, true},
+		{Config{}, false},
+		{Config{Bucket: "A", Compression: "zstd"}, true},
+		{Config{Bucket: "A", Compression: "lz4"}, false},
+		{Config{Bucket: "A", PartSize: 1024}, false},
+		{Config{Bucket: "A", Key: "{group}"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.config.validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: unexpected validation result: %v", test.config, err)
+		}
+	}
+}
+
+// bucket is a stand-in for the S3 API which supports the operations used by
+// the destination.
+type bucket struct {
+	sync.Mutex
+	t       *testing.T
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	parts   int
+	aborted int
+
+	// Makes the requests completing multipart uploads fail.
+	failComplete bool
+}
+
+func newBucket(t *testing.T) *bucket {
+	return &bucket{
+		t:       t,
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+}
+
+func (b *bucket) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	b.Lock()
+	defer b.Unlock()
+
+	if !strings.HasPrefix(req.URL.Path, "/bucket/") {
+		b.t.Errorf("invalid request path: %s", req.URL.Path)
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(req.URL.Path, "/bucket/")
+	query := req.URL.Query()
+	body, _ := ioutil.ReadAll(req.Body)
+
+	switch {
+	case req.Method == "POST" && query["uploads"] != nil:
+		id := strconv.Itoa(len(b.uploads) + 1)
+		b.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(res, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+
+	case req.Method == "PUT" && len(query.Get("uploadId")) != 0:
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		b.uploads[query.Get("uploadId")][n] = body
+		b.parts++
+		res.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+
+	case req.Method == "POST" && len(query.Get("uploadId")) != 0 && b.failComplete:
+		res.WriteHeader(http.StatusInternalServerError)
+
+	case req.Method == "DELETE" && len(query.Get("uploadId")) != 0:
+		delete(b.uploads, query.Get("uploadId"))
+		b.aborted++
+		res.WriteHeader(http.StatusNoContent)
+
+	case req.Method == "POST" && len(query.Get("uploadId")) != 0:
+		parts := b.uploads[query.Get("uploadId")]
+		numbers := make([]int, 0, len(parts))
+
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+
+		sort.Ints(numbers)
+		var object []byte
+
+		for _, n := range numbers {
+			object = append(object, parts[n]...)
+		}
+
+		b.objects[key] = object
+		fmt.Fprintf(res, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><ETag>"1"</ETag></CompleteMultipartUploadResult>`, key)
+
+	case req.Method == "PUT":
+		b.objects[key] = body
+		res.Header().Set("ETag", `"1"`)
+
+	default:
+		b.t.Errorf("unexpected request: %s %s", req.Method, req.URL)
+		res.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (b *bucket) keys() (keys []string) {
+	b.Lock()
+	defer b.Unlock()
+
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return
+}
+
+// messages decodes the messages stored in an object.
+func (b *bucket) messages(key string, compressed bool) (messages []string) {
+	b.Lock()
+	data := b.objects[key]
+	b.Unlock()
+
+	r := bytes.NewReader(data)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 2*1024*1024)
+
+	if compressed {
+		z, err := gzip.NewReader(r)
+
+		if err != nil {
+			b.t.Error(err)
+			return
+		}
+
+		scanner = bufio.NewScanner(z)
+	}
+
+	for scanner.Scan() {
+		var msg lib.Message
+
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			b.t.Error(err)
+			return
+		}
+
+		messages = append(messages, msg.Event.Message)
+	}
+
+	return
+}
+
+func testMessage(t time.Time, message string) lib.Message {
+	return lib.Message{
+		Group:  "web",
+		Stream: "1",
+		Event:  ecslogs.Event{Level: ecslogs.INFO, Time: t, Message: message},
+	}
+}
+
+func testDestination(t *testing.T, config Config) (*destination, *bucket, func()) {
+	b := newBucket(t)
+	server := httptest.NewServer(b)
+
+	config.Bucket = "bucket"
+	config.Region = "us-east-1"
+	config.Endpoint = server.URL
+	config.Host = "host"
+
+	d := newDestination(config)
+	d.start = 1
+	return d, b, server.Close
+}
+
+func TestDestinationPartitions(t *testing.T) {
+	d, b, close := testDestination(t, Config{})
+	defer close()
+
+	t0 := time.Date(2024, 3, 7, 10, 59, 0, 0, time.UTC)
+	w, err := d.Open("web", "1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.WriteMessageBatch(lib.MessageBatch{
+		testMessage(t0, "1"),
+		testMessage(t0.Add(time.Second), "2"),
+		testMessage(t0.Add(time.Minute), "3"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first object was uploaded when a message of the next hour was
+	// written.
+	if keys := b.keys(); len(keys) != 1 || keys[0] != "web/2024/03/07/10/host-1-000001.json.gz" {
+		t.Fatalf("invalid objects: %v", keys)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := b.keys()
+
+	if len(keys) != 2 || keys[1] != "web/2024/03/07/11/host-1-000002.json.gz" {
+		t.Fatalf("invalid objects: %v", keys)
+	}
+
+	if m := b.messages(keys[0], true); strings.Join(m, ",") != "1,2" {
+		t.Errorf("invalid messages in %s: %v", keys[0], m)
+	}
+
+	if m := b.messages(keys[1], true); strings.Join(m, ",") != "3" {
+		t.Errorf("invalid messages in %s: %v", keys[1], m)
+	}
+}
+
+func TestDestinationZstd(t *testing.T) {
+	d, b, close := testDestination(t, Config{Compression: "zstd"})
+	defer close()
+
+	t0 := time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)
+	w, _ := d.Open("web", "1")
+
+	if err := w.WriteMessageBatch(lib.MessageBatch{
+		testMessage(t0, "1"),
+		testMessage(t0, "2"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := b.keys()
+
+	if len(keys) != 1 || keys[0] != "web/2024/03/07/10/host-1-000001.json.zst" {
+		t.Fatalf("invalid objects: %v", keys)
+	}
+
+	z, err := zstd.NewReader(bytes.NewReader(b.objects[keys[0]]))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer z.Close()
+	data, err := ioutil.ReadAll(z)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("invalid number of messages: %d", lines)
+	}
+}
+
+func TestDestinationMultipartUpload(t *testing.T) {
+	d, b, close := testDestination(t, Config{Compression: "none"})
+	defer close()
+
+	t0 := time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)
+	line := strings.Repeat("A", 1024*1024)
+	w, _ := d.Open("web", "1")
+
+	for i := 0; i != 7; i++ {
+		if err := w.WriteMessage(testMessage(t0, line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The object is uploaded in the background.
+	d.Close("web", "1")
+	d.join.Wait()
+
+	keys := b.keys()
+
+	if len(keys) != 1 || keys[0] != "web/2024/03/07/10/host-1-000001.json" {
+		t.Fatalf("invalid objects: %v", keys)
+	}
+
+	if b.parts != 2 {
+		t.Errorf("invalid number of parts: %d", b.parts)
+	}
+
+	if m := b.messages(keys[0], false); len(m) != 7 || m[6] != line {
+		t.Errorf("invalid messages: %d", len(m))
+	}
+}
+
+func TestDestinationMaxObjectAge(t *testing.T) {
+	d, b, close := testDestination(t, Config{MaxObjectAge: lib.Duration(10 * time.Millisecond)})
+	defer close()
+
+	w, _ := d.Open("web", "1")
+
+	if err := w.WriteMessage(testMessage(time.Now(), "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i != 100 && len(b.keys()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if keys := b.keys(); len(keys) != 1 {
+		t.Errorf("the object should have been uploaded after the maximum age: %v", keys)
+	}
+}
+
+func TestDestinationUploadError(t *testing.T) {
+	d, b, close := testDestination(t, Config{})
+	close()
+
+	t0 := time.Date(2024, 3, 7, 10, 59, 0, 0, time.UTC)
+	w, _ := d.Open("web", "1")
+
+	err := w.WriteMessageBatch(lib.MessageBatch{
+		testMessage(t0, "1"),
+		testMessage(t0.Add(time.Minute), "2"),
+	})
+
+	if failed, ok := lib.FailedMessages(err); !ok || len(failed) != 1 || failed[0].Event.Message != "2" {
+		t.Errorf("only the messages of the next object should have failed: %v", err)
+	}
+
+	if len(b.keys()) != 0 {
+		t.Error("no objects should have been uploaded")
+	}
+}
+
+func TestDestinationPositions(t *testing.T) {
+	d, _, close := testDestination(t, Config{})
+	defer close()
+
+	var commits []string
+
+	c := lib.NewCheckpointInterval(func(value string) error {
+		commits = append(commits, value)
+		return nil
+	}, 0)
+
+	msg := testMessage(time.Now(), "1")
+	msg.Position = c.Track("A")
+	batch := lib.MessageBatch{msg}
+
+	w, _ := d.Open("web", "1")
+
+	if err := w.WriteMessageBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	lib.AckBatch(batch)
+
+	// The position is committed once the object was uploaded.
+	if len(commits) != 0 {
+		t.Errorf("the position was committed before the object was uploaded: %v", commits)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(commits) != 1 || commits[0] != "A" {
+		t.Errorf("invalid commits: %v", commits)
+	}
+}
+
+func TestDestinationAbortMultipartUpload(t *testing.T) {
+	d, b, close := testDestination(t, Config{Compression: "none"})
+	defer close()
+
+	b.failComplete = true
+	line := strings.Repeat("A", 1024*1024)
+	w, _ := d.Open("web", "1")
+
+	for i := 0; i != 7; i++ {
+		w.WriteMessage(testMessage(time.Now(), line))
+	}
+
+	if err := d.Flush(); err == nil {
+		t.Error("expected an error")
+	}
+
+	if b.aborted != 1 || len(b.uploads) != 0 {
+		t.Errorf("the multipart upload should have been aborted: %d", b.aborted)
+	}
+}
+
+func TestDestinationWriteAfterClose(t *testing.T) {
+	d, b, close := testDestination(t, Config{})
+	defer close()
+
+	t0 := time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)
+	w, _ := d.Open("web", "1")
+
+	if err := w.WriteMessage(testMessage(t0, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Close("web", "1")
+	d.join.Wait()
+
+	// The writer was opened before the stream was removed, its messages must
+	// still be uploaded when the destination is flushed.
+	if err := w.WriteMessage(testMessage(t0, "2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := b.keys()
+
+	if len(keys) != 2 {
+		t.Fatalf("invalid objects: %v", keys)
+	}
+
+	if m := b.messages(keys[1], true); strings.Join(m, ",") != "2" {
+		t.Errorf("invalid messages in %s: %v", keys[1], m)
+	}
+}
+
+func TestDestinationMaxBufferSize(t *testing.T) {
+	d, b, close := testDestination(t, Config{Compression: "none", MaxBufferSize: 200})
+	defer close()
+
+	t0 := time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)
+	w1, _ := d.Open("web", "1")
+	w2, _ := d.Open("web", "2")
+
+	if err := w1.WriteMessage(testMessage(t0, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.keys()) != 0 {
+		t.Fatal("no objects should have been uploaded below the limit")
+	}
+
+	// The second stream exceeds the limit, its object is uploaded right away.
+	if err := w2.WriteMessage(testMessage(t0, strings.Repeat("A", 200))); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := b.keys(); len(keys) != 1 || keys[0] != "web/2024/03/07/10/host-1-000002.json" {
+		t.Errorf("invalid objects: %v", keys)
+	}
+
+	if n := atomic.LoadInt64(&d.buffered); n == 0 || n > 200 {
+		t.Errorf("invalid number of buffered bytes: %d", n)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt64(&d.buffered); n != 0 {
+		t.Errorf("no bytes should be buffered after a flush: %d", n)
+	}
+}
@@ -0,0 +1,16 @@
+package s3
+
+import "github.com/segmentio/ecs-logs/lib"
+
+func init() {
+	lib.RegisterDestination("s3", &envDestination{})
+	lib.RegisterDestinationFactory("s3", func(opts lib.Options) (lib.Destination, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		return NewDestination(c)
+	})
+}
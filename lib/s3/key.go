@@ -0,0 +1,95 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Key is a template of object keys, it supports the {group}, {stream},
+// {host} and {seq} placeholders, and {yyyy}, {mm}, {dd} and {hh} which are
+// replaced by the UTC date and hour of the first message of objects.
+type Key struct {
+	parts []keyPart
+}
+
+type keyPart struct {
+	text        string
+	placeholder string
+}
+
+func ParseKey(s string) (key Key, err error) {
+	var seq bool
+
+	for len(s) != 0 {
+		i := strings.IndexByte(s, '{')
+
+		if i < 0 {
+			key.parts = append(key.parts, keyPart{text: s})
+			break
+		}
+
+		if i != 0 {
+			key.parts = append(key.parts, keyPart{text: s[:i]})
+		}
+
+		j := strings.IndexByte(s[i:], '}')
+
+		if j < 0 {
+			err = fmt.Errorf("invalid key template, missing '}': %s", s)
+			return
+		}
+
+		switch name := s[i+1 : i+j]; name {
+		case "seq":
+			seq = true
+			fallthrough
+		case "group", "stream", "host", "yyyy", "mm", "dd", "hh":
+			key.parts = append(key.parts, keyPart{placeholder: name})
+		default:
+			err = fmt.Errorf("invalid key template, unknown placeholder {%s}", name)
+			return
+		}
+
+		s = s[i+j+1:]
+	}
+
+	// Without a sequence number objects would overwrite each other.
+	if !seq {
+		err = fmt.Errorf("invalid key template, the {seq} placeholder is required")
+	}
+
+	return
+}
+
+// Expand returns the key of an object, objects of the same partition are
+// those that have the same key when seq is empty.
+func (key Key) Expand(group string, stream string, host string, seq string, t time.Time) string {
+	var b strings.Builder
+	t = t.UTC()
+
+	for _, p := range key.parts {
+		switch p.placeholder {
+		case "":
+			b.WriteString(p.text)
+		case "group":
+			b.WriteString(strings.TrimLeft(group, "/"))
+		case "stream":
+			b.WriteString(strings.TrimLeft(stream, "/"))
+		case "host":
+			b.WriteString(host)
+		case "seq":
+			b.WriteString(seq)
+		case "yyyy":
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case "mm":
+			fmt.Fprintf(&b, "%02d", t.Month())
+		case "dd":
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case "hh":
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		}
+	}
+
+	return b.String()
+}
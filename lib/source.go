@@ -48,6 +48,23 @@ func GetSources(names ...string) (sources []Source) {
 	return
 }
 
+// A SourceFactory creates sources from the options declared in a
+// configuration file.
+type SourceFactory func(Options) (Source, error)
+
+func RegisterSourceFactory(name string, factory SourceFactory) {
+	srcmtx.Lock()
+	srcfactories[name] = factory
+	srcmtx.Unlock()
+}
+
+func GetSourceFactory(name string) (factory SourceFactory) {
+	srcmtx.RLock()
+	factory = srcfactories[name]
+	srcmtx.RUnlock()
+	return
+}
+
 func SourcesAvailable() (sources []string) {
 	srcmtx.RLock()
 	sources = make([]string, 0, len(srcmap))
@@ -92,6 +109,14 @@ var (
 			}{r, w}), nil
 		}),
 	}
+	srcfactories = map[string]SourceFactory{
+		"stdin": func(opts Options) (Source, error) {
+			if err := opts.Decode(&struct{}{}); err != nil {
+				return nil, err
+			}
+			return GetSource("stdin"), nil
+		},
+	}
 )
 
 func pipe(w *io.PipeWriter, r io.Reader) {
@@ -0,0 +1,16 @@
+package splunk
+
+import "github.com/segmentio/ecs-logs/lib"
+
+func init() {
+	lib.RegisterDestination("splunk", lib.DestinationFunc(NewWriter))
+	lib.RegisterDestinationFactory("splunk", func(opts lib.Options) (lib.Destination, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		return NewDestination(c)
+	})
+}
@@ -0,0 +1,373 @@
+package splunk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+const (
+	DefaultMaxContentLength = 1000000
+	DefaultTimeout          = lib.Duration(30 * time.Second)
+	DefaultAckTimeout       = lib.Duration(1 * time.Minute)
+	DefaultAckInterval      = lib.Duration(1 * time.Second)
+)
+
+// Config represents the options of splunk destinations, which write to the
+// HTTP Event Collector (HEC).
+type Config struct {
+	// The URL of the collector, for example https://splunk:8088.
+	URL string `json:"url"`
+
+	// The HEC token.
+	Token string `json:"token"`
+
+	// The index of events, the default index of the token is used when it's
+	// empty.
+	Index string `json:"index"`
+
+	// Wait for indexer acknowledgements, the token must have them enabled.
+	// Events that weren't acknowledged after AckTimeout are written again.
+	Ack         bool         `json:"ack"`
+	AckTimeout  lib.Duration `json:"ack_timeout"`
+	AckInterval lib.Duration `json:"ack_interval"`
+
+	// The channel sent with requests when acknowledgements are enabled, a
+	// random one is generated when it's empty.
+	Channel string `json:"channel"`
+
+	// The maximum size of requests, batches are split to stay below it.
+	MaxContentLength int `json:"max_content_length"`
+
+	// The maximum amount of time a request may take.
+	Timeout lib.Duration `json:"timeout"`
+}
+
+func (c Config) validate() error {
+	if len(c.URL) == 0 {
+		return errors.New("missing splunk URL")
+	}
+
+	u, err := url.Parse(c.URL)
+
+	if err != nil {
+		return fmt.Errorf("invalid splunk URL, %s: %s", err, c.URL)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid splunk URL, the scheme must be one of 'http' or 'https': %s", c.URL)
+	}
+
+	if len(c.Token) == 0 {
+		return errors.New("missing splunk token")
+	}
+
+	if c.MaxContentLength < 0 {
+		return fmt.Errorf("invalid splunk max content length: %d", c.MaxContentLength)
+	}
+
+	return nil
+}
+
+func configFromEnv() Config {
+	return Config{
+		URL:   os.Getenv("SPLUNK_URL"),
+		Token: os.Getenv("SPLUNK_TOKEN"),
+		Index: os.Getenv("SPLUNK_INDEX"),
+		Ack:   os.Getenv("SPLUNK_ACK") == "true",
+	}
+}
+
+func NewWriter(group string, stream string) (lib.Writer, error) {
+	config := configFromEnv()
+
+	// Configuration errors won't go away by retrying the write operation.
+	if err := config.validate(); err != nil {
+		return nil, lib.PermanentError(err)
+	}
+
+	return newWriter(config), nil
+}
+
+// NewDestination returns a destination which writes to the HTTP Event
+// Collector configured by config, errors in the configuration are reported
+// immediately.
+func NewDestination(config Config) (lib.Destination, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return lib.DestinationFunc(func(group string, stream string) (lib.Writer, error) {
+		return newWriter(config), nil
+	}), nil
+}
+
+func newWriter(config Config) *writer {
+	if config.MaxContentLength == 0 {
+		config.MaxContentLength = DefaultMaxContentLength
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.AckTimeout <= 0 {
+		config.AckTimeout = DefaultAckTimeout
+	}
+
+	if config.AckInterval <= 0 {
+		config.AckInterval = DefaultAckInterval
+	}
+
+	if len(config.Channel) == 0 {
+		config.Channel = newChannel()
+	}
+
+	return &writer{
+		config: config,
+		client: &http.Client{Timeout: time.Duration(config.Timeout)},
+	}
+}
+
+// newChannel returns a random UUID, which is the format HEC expects channels
+// to have.
+func newChannel() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+type writer struct {
+	config Config
+	client *http.Client
+}
+
+type event struct {
+	Time       *epoch        `json:"time,omitempty"`
+	Host       string        `json:"host,omitempty"`
+	Source     string        `json:"source"`
+	SourceType string        `json:"sourcetype"`
+	Index      string        `json:"index,omitempty"`
+	Event      ecslogs.Event `json:"event"`
+}
+
+// epoch is a time represented as seconds since the epoch with a fractional
+// part, which is how HEC expects event times. Events without a time are sent
+// without one and get the time they were received at.
+type epoch time.Time
+
+func (t epoch) MarshalJSON() ([]byte, error) {
+	u := time.Time(t).UnixNano() / int64(time.Microsecond)
+	return []byte(fmt.Sprintf("%d.%06d", u/1000000, u%1000000)), nil
+}
+
+type response struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+type ackRequest struct {
+	Acks []int64 `json:"acks"`
+}
+
+type ackResponse struct {
+	Acks map[string]bool `json:"acks"`
+}
+
+// A chunk is a part of a batch which fits in a single request.
+type chunk struct {
+	body  []byte
+	batch lib.MessageBatch
+	ackID int64
+}
+
+func (w *writer) Close() error {
+	return nil
+}
+
+func (w *writer) WriteMessage(msg lib.Message) error {
+	return w.WriteMessageBatch(lib.MessageBatch{msg})
+}
+
+func (w *writer) WriteMessageBatch(batch lib.MessageBatch) error {
+	var pending []chunk
+
+	chunks, err := w.encode(batch)
+
+	if err != nil {
+		return lib.PermanentError(err)
+	}
+
+	for i, c := range chunks {
+		var res response
+
+		if err = w.post("/services/collector/event", c.body, &res); err == nil && w.config.Ack {
+			if res.AckID == nil {
+				err = lib.PermanentError(errors.New("splunk returned no acknowledgement ID, indexer acknowledgement must be enabled on the token"))
+			} else {
+				c.ackID = *res.AckID
+				pending = append(pending, c)
+			}
+		}
+
+		if err != nil {
+			if i == 0 {
+				return err
+			}
+			// The events of the chunks that weren't acknowledged yet are
+			// written again as well since they may not have been indexed.
+			return lib.PartialError(err, messages(append(pending, chunks[i:]...)))
+		}
+	}
+
+	return w.wait(pending)
+}
+
+// wait polls the acknowledgement endpoint until all the pending chunks are
+// acknowledged, or returns an error with the events that weren't.
+func (w *writer) wait(pending []chunk) error {
+	deadline := time.Now().Add(time.Duration(w.config.AckTimeout))
+
+	for len(pending) != 0 {
+		var req ackRequest
+		var res ackResponse
+
+		for _, c := range pending {
+			req.Acks = append(req.Acks, c.ackID)
+		}
+
+		body, _ := json.Marshal(req)
+
+		if err := w.post("/services/collector/ack", body, &res); err != nil {
+			return lib.PartialError(err, messages(pending))
+		}
+
+		waiting := pending[:0]
+
+		for _, c := range pending {
+			if !res.Acks[strconv.FormatInt(c.ackID, 10)] {
+				waiting = append(waiting, c)
+			}
+		}
+
+		if pending = waiting; len(pending) == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return lib.PartialError(
+				fmt.Errorf("splunk didn't acknowledge %d requests within %s", len(pending), time.Duration(w.config.AckTimeout)),
+				messages(pending),
+			)
+		}
+
+		time.Sleep(time.Duration(w.config.AckInterval))
+	}
+
+	return nil
+}
+
+// encode splits batch in chunks which don't exceed the maximum content length,
+// unless a single event is larger than the limit.
+func (w *writer) encode(batch lib.MessageBatch) (chunks []chunk, err error) {
+	var c chunk
+
+	for _, msg := range batch {
+		var b []byte
+
+		e := event{
+			Host:       msg.Event.Info.Host,
+			Source:     msg.Group,
+			SourceType: msg.Stream,
+			Index:      w.config.Index,
+			Event:      msg.Event,
+		}
+
+		if !msg.Event.Time.IsZero() {
+			t := epoch(msg.Event.Time)
+			e.Time = &t
+		}
+
+		if b, err = json.Marshal(e); err != nil {
+			return
+		}
+
+		if len(c.batch) != 0 && (len(c.body)+len(b)+1) > w.config.MaxContentLength {
+			chunks = append(chunks, c)
+			c = chunk{}
+		}
+
+		c.body = append(c.body, b...)
+		c.body = append(c.body, '\n')
+		c.batch = append(c.batch, msg)
+	}
+
+	if len(c.batch) != 0 {
+		chunks = append(chunks, c)
+	}
+
+	return
+}
+
+func (w *writer) post(path string, body []byte, res interface{}) (err error) {
+	var req *http.Request
+	var r *http.Response
+
+	if req, err = http.NewRequest("POST", strings.TrimRight(w.config.URL, "/")+path, bytes.NewReader(body)); err != nil {
+		return lib.PermanentError(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+w.config.Token)
+
+	if w.config.Ack {
+		req.Header.Set("X-Splunk-Request-Channel", w.config.Channel)
+	}
+
+	if r, err = w.client.Do(req); err != nil {
+		return
+	}
+
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+		err = fmt.Errorf("splunk request to %s failed with status %d: %s", path, r.StatusCode, bytes.TrimSpace(b))
+
+		// Invalid tokens or events won't be accepted if they're sent again.
+		if r.StatusCode != http.StatusTooManyRequests && r.StatusCode < 500 {
+			err = lib.PermanentError(err)
+		}
+
+		return
+	}
+
+	if err = json.NewDecoder(r.Body).Decode(res); err != nil {
+		err = fmt.Errorf("invalid splunk response: %s", err)
+	}
+
+	return
+}
+
+func messages(chunks []chunk) (batch lib.MessageBatch) {
+	for _, c := range chunks {
+		batch = append(batch, c.batch...)
+	}
+	return
+}
@@ -0,0 +1,273 @@
+package splunk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func TestEpochMarshalJSON(t *testing.T) {
+	tests := []struct {
+		time time.Time
+		json string
+	}{
+		{time.Unix(1500000000, 0), "1500000000.000000"},
+		{time.Unix(1500000000, 123456789), "1500000000.123456"},
+	}
+
+	for _, test := range tests {
+		if b, _ := json.Marshal(epoch(test.time)); string(b) != test.json {
+			t.Errorf("invalid epoch: %s != %s", test.json, b)
+		}
+	}
+}
+
+func TestEncodeWithoutTime(t *testing.T) {
+	batch := testBatch("1")
+	batch[0].Event.Time = time.Time{}
+
+	chunks, err := newWriter(Config{Token: "token"}).encode(batch)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunks) != 1 || bytes.HasPrefix(chunks[0].body, []byte(`{"time"`)) {
+		t.Errorf("events without a time should be sent without one: %s", chunks[0].body)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{URL: "https://localhost:8088", Token: "A"}, true},
+		{Config{URL: "https://localhost:8088"}, false},
+		{Config{URL: "https://localhost:8088", Token: "A", MaxContentLength: -1}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.config.validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: unexpected validation result: %v", test.config, err)
+		}
+	}
+}
+
+// collector is a stand-in for the HTTP Event Collector, it acknowledges
+// requests once their ID is lower than acked.
+type collector struct {
+	sync.Mutex
+	t        *testing.T
+	requests [][]event
+	status   []int
+	acked    int64
+	ackPolls int
+}
+
+func (c *collector) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+
+	if auth := req.Header.Get("Authorization"); auth != "Splunk token" {
+		c.t.Errorf("invalid authorization header: %q", auth)
+	}
+
+	switch req.URL.Path {
+	case "/services/collector/event":
+		var events []event
+		dec := json.NewDecoder(req.Body)
+
+		for dec.More() {
+			var e struct {
+				Source     string        `json:"source"`
+				SourceType string        `json:"sourcetype"`
+				Index      string        `json:"index"`
+				Event      ecslogs.Event `json:"event"`
+			}
+
+			if err := dec.Decode(&e); err != nil {
+				c.t.Error(err)
+				return
+			}
+
+			events = append(events, event{Source: e.Source, SourceType: e.SourceType, Index: e.Index, Event: e.Event})
+		}
+
+		id := len(c.requests)
+		c.requests = append(c.requests, events)
+
+		if len(c.status) != 0 {
+			status := c.status[0]
+			c.status = c.status[1:]
+
+			if status != http.StatusOK {
+				res.WriteHeader(status)
+				fmt.Fprint(res, `{"text":"Server is busy","code":9}`)
+				return
+			}
+		}
+
+		fmt.Fprintf(res, `{"text":"Success","code":0,"ackId":%d}`, id)
+
+	case "/services/collector/ack":
+		var r ackRequest
+		json.NewDecoder(req.Body).Decode(&r)
+		c.ackPolls++
+
+		if len(req.Header.Get("X-Splunk-Request-Channel")) == 0 {
+			c.t.Error("missing channel header")
+		}
+
+		acks := map[string]bool{}
+
+		for _, id := range r.Acks {
+			acks[fmt.Sprint(id)] = id < c.acked
+		}
+
+		json.NewEncoder(res).Encode(ackResponse{Acks: acks})
+	}
+}
+
+// testBatch returns messages of the web group and 1 stream, which are the
+// source and source type of the events sent to splunk.
+func testBatch(messages ...string) (batch lib.MessageBatch) {
+	for _, m := range messages {
+		batch = append(batch, lib.Message{
+			Group:  "web",
+			Stream: "1",
+			Event:  ecslogs.Event{Level: ecslogs.INFO, Time: time.Unix(1500000000, 0), Message: m},
+		})
+	}
+	return
+}
+
+func TestWriteMessageBatch(t *testing.T) {
+	c := &collector{t: t}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	w := newWriter(Config{URL: server.URL, Token: "token", Index: "main"})
+
+	if err := w.WriteMessageBatch(testBatch("1", "2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.requests) != 1 || len(c.requests[0]) != 2 {
+		t.Fatalf("invalid requests: %+v", c.requests)
+	}
+
+	e := c.requests[0][1]
+
+	if e.Source != "web" || e.SourceType != "1" || e.Index != "main" || e.Event.Message != "2" {
+		t.Errorf("invalid event: %+v", e)
+	}
+}
+
+func TestWriteMessageBatchSplit(t *testing.T) {
+	c := &collector{t: t, status: []int{200, 503}}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	batch := testBatch("1", "2", "3")
+	chunks, _ := newWriter(Config{URL: server.URL, Token: "token"}).encode(batch[:1])
+
+	// Each request can only contain two events.
+	w := newWriter(Config{URL: server.URL, Token: "token", MaxContentLength: 2*len(chunks[0].body) + 1})
+	err := w.WriteMessageBatch(batch)
+
+	if len(c.requests) != 2 || len(c.requests[0]) != 2 || len(c.requests[1]) != 1 {
+		t.Errorf("invalid requests: %+v", c.requests)
+	}
+
+	if lib.IsPermanentError(err) {
+		t.Errorf("expected a temporary error: %v", err)
+	}
+
+	if failed, ok := lib.FailedMessages(err); !ok || len(failed) != 1 || failed[0].Event.Message != "3" {
+		t.Errorf("only the events of the failed request should be written again: %v", failed)
+	}
+}
+
+func TestWriteMessageBatchErrors(t *testing.T) {
+	// The collector returns 403 for invalid tokens and 503 when its queues
+	// are full.
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusForbidden, true},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, test := range tests {
+		c := &collector{t: t, status: []int{test.status}}
+		server := httptest.NewServer(c)
+
+		err := newWriter(Config{URL: server.URL, Token: "token"}).WriteMessageBatch(testBatch("1"))
+		server.Close()
+
+		if err == nil {
+			t.Errorf("%d: expected an error", test.status)
+			continue
+		}
+
+		if lib.IsPermanentError(err) != test.permanent {
+			t.Errorf("%d: invalid error kind: %v", test.status, err)
+		}
+	}
+}
+
+func TestWriteMessageBatchAck(t *testing.T) {
+	c := &collector{t: t, acked: 1}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	w := newWriter(Config{
+		URL:         server.URL,
+		Token:       "token",
+		Ack:         true,
+		AckTimeout:  lib.Duration(50 * time.Millisecond),
+		AckInterval: lib.Duration(time.Millisecond),
+	})
+
+	if err := w.WriteMessageBatch(testBatch("1")); err != nil {
+		t.Error(err)
+	}
+
+	// The second request is never acknowledged.
+	err := w.WriteMessageBatch(testBatch("2"))
+
+	if failed, ok := lib.FailedMessages(err); !ok || len(failed) != 1 || failed[0].Event.Message != "2" {
+		t.Errorf("the events that weren't acknowledged should be written again: %v", err)
+	}
+
+	if c.ackPolls < 2 {
+		t.Errorf("the acknowledgements should have been polled until the timeout: %d", c.ackPolls)
+	}
+
+	if !strings.Contains(err.Error(), "acknowledge") {
+		t.Errorf("invalid error: %s", err)
+	}
+}
+
+func TestNewChannel(t *testing.T) {
+	a, b := newChannel(), newChannel()
+
+	if len(a) != 36 || a == b || a[14] != '4' {
+		t.Errorf("invalid channels: %s %s", a, b)
+	}
+
+	if !bytes.Equal([]byte{a[8], a[13], a[18], a[23]}, []byte("----")) {
+		t.Errorf("invalid channel: %s", a)
+	}
+}
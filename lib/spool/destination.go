@@ -0,0 +1,228 @@
+package spool
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// NewDestination returns a destination which writes message batches to dest,
+// batches that could not be written are stored in s and replayed in order in
+// the background once dest accepts writes again.
+//
+// While the spool isn't empty new batches are appended to it as well, so they
+// don't get written before the ones that are waiting.
+func NewDestination(name string, dest lib.Destination, s *Spool, retryInterval time.Duration) lib.Destination {
+	if retryInterval <= 0 {
+		retryInterval = 5 * time.Second
+	}
+
+	d := &destination{
+		Destination: dest,
+		name:        name,
+		spool:       s,
+		interval:    retryInterval,
+		signal:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	go d.replay()
+	return d
+}
+
+type destination struct {
+	lib.Destination
+	name     string
+	spool    *Spool
+	interval time.Duration
+	signal   chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+func (d *destination) Open(group string, stream string) (lib.Writer, error) {
+	return &writer{
+		dest:   d,
+		group:  group,
+		stream: stream,
+	}, nil
+}
+
+// Flush stops replaying the spool and closes it, batches that are still in the
+// spool are replayed the next time it's opened.
+func (d *destination) Flush() (err error) {
+	d.once.Do(func() {
+		close(d.done)
+		<-d.stopped
+		err = lib.FlushDestination(d.Destination)
+
+		if e := d.spool.Close(); e != nil {
+			err = lib.AppendError(err, e)
+		}
+	})
+	return
+}
+
+// wait blocks until a batch is pushed to the spool, it returns false if the
+// destination was stopped.
+func (d *destination) wait() bool {
+	select {
+	case <-d.signal:
+		return true
+	case <-d.done:
+		return false
+	}
+}
+
+// sleep blocks for the retry interval, it returns false if the destination was
+// stopped.
+func (d *destination) sleep() bool {
+	timer := time.NewTimer(d.interval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-d.done:
+		return false
+	}
+}
+
+func (d *destination) push(group string, stream string, batch lib.MessageBatch) (err error) {
+	if err = d.spool.Push(group, stream, batch); err != nil {
+		return
+	}
+
+	select {
+	default:
+	case d.signal <- struct{}{}:
+	}
+
+	return
+}
+
+func (d *destination) replay() {
+	defer close(d.stopped)
+
+	for {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+
+		group, stream, batch, err := d.spool.Peek()
+
+		if err == io.EOF {
+			if !d.wait() {
+				return
+			}
+			continue
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"destination": d.name,
+				"error":       err,
+			}).Error("failed to read message batch from spool")
+			if !d.sleep() {
+				return
+			}
+			continue
+		}
+
+		if err = write(d.Destination, group, stream, batch); err != nil {
+			if !lib.IsPermanentError(err) {
+				if !d.sleep() {
+					return
+				}
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"group":       group,
+				"stream":      stream,
+				"destination": d.name,
+				"error":       err,
+				"count":       len(batch),
+			}).Error("dropping spooled message batch")
+		} else {
+			log.WithFields(log.Fields{
+				"group":       group,
+				"stream":      stream,
+				"destination": d.name,
+				"count":       len(batch),
+			}).Info("replayed spooled message batch")
+		}
+
+		if err = d.spool.Pop(); err != nil {
+			log.WithFields(log.Fields{
+				"destination": d.name,
+				"error":       err,
+			}).Error("failed to remove message batch from spool")
+			if !d.sleep() {
+				return
+			}
+		}
+	}
+}
+
+func write(dest lib.Destination, group string, stream string, batch lib.MessageBatch) (err error) {
+	var w lib.Writer
+
+	if w, err = dest.Open(group, stream); err != nil {
+		return
+	}
+	defer w.Close()
+
+	return w.WriteMessageBatch(batch)
+}
+
+type writer struct {
+	dest   *destination
+	group  string
+	stream string
+}
+
+func (w *writer) Close() error {
+	return nil
+}
+
+func (w *writer) WriteMessage(msg lib.Message) error {
+	return w.WriteMessageBatch(lib.MessageBatch{msg})
+}
+
+func (w *writer) WriteMessageBatch(batch lib.MessageBatch) (err error) {
+	if !w.dest.spool.Empty() {
+		return w.dest.push(w.group, w.stream, batch)
+	}
+
+	if err = write(w.dest.Destination, w.group, w.stream, batch); err == nil || lib.IsPermanentError(err) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"group":       w.group,
+		"stream":      w.stream,
+		"destination": w.dest.name,
+		"error":       err,
+		"count":       len(batch),
+	}).Warn("spooling message batch")
+
+	if failed, ok := lib.FailedMessages(err); ok {
+		batch = failed
+	}
+
+	if e := w.dest.push(w.group, w.stream, batch); e != nil {
+		err = lib.AppendError(err, e)
+	} else {
+		err = nil
+	}
+
+	return
+}
@@ -0,0 +1,391 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// ErrFull is returned by Push when storing a batch would make the spool exceed
+// its size limit.
+var ErrFull = errors.New("the spool is full")
+
+type Config struct {
+	// The maximum number of bytes stored by the spool, zero means no limit.
+	MaxBytes int64
+
+	// The size at which segment files are rotated.
+	SegmentBytes int64
+}
+
+// A Spool is a disk-backed FIFO queue of message batches.
+//
+// Batches are appended as JSON lines to segment files, segments are removed
+// once all their batches have been popped. The position of the next batch to
+// read is saved in a cursor file so a spool can be reopened after a restart.
+type Spool struct {
+	mutex  sync.Mutex
+	dir    string
+	config Config
+
+	// bytes is the size of the records that were not read yet.
+	bytes int64
+
+	// segments are sorted from oldest to newest, the first one is being read
+	// and the last one is being written.
+	segments []segment
+	offset   int64
+	output   *os.File
+	next     uint64
+
+	// cached result of the last call to Peek
+	head   *record
+	length int64
+}
+
+type segment struct {
+	id   uint64
+	size int64
+}
+
+type record struct {
+	Group  string           `json:"group"`
+	Stream string           `json:"stream"`
+	Batch  lib.MessageBatch `json:"batch"`
+}
+
+func Open(dir string, config Config) (s *Spool, err error) {
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = 10000000
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	s = &Spool{
+		dir:    dir,
+		config: config,
+	}
+
+	if err = s.load(); err != nil {
+		return nil, err
+	}
+
+	// Writes always start in a new segment so a record that was partially
+	// written before a crash doesn't get mixed with new ones.
+	if err = s.rotate(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func (s *Spool) load() (err error) {
+	var files []os.FileInfo
+
+	if files, err = ioutil.ReadDir(s.dir); err != nil {
+		return
+	}
+
+	for _, f := range files {
+		if id, ok := parseSegmentName(f.Name()); ok {
+			s.segments = append(s.segments, segment{id: id, size: f.Size()})
+			s.bytes += f.Size()
+		}
+	}
+
+	sort.Slice(s.segments, func(i int, j int) bool {
+		return s.segments[i].id < s.segments[j].id
+	})
+
+	id, offset, err := s.readCursor()
+
+	if err != nil {
+		return
+	}
+
+	// Segments older than the one referenced by the cursor were fully read
+	// before the spool was closed.
+	for len(s.segments) != 0 && s.segments[0].id < id {
+		if err = s.removeHead(); err != nil {
+			return
+		}
+	}
+
+	if len(s.segments) != 0 && s.segments[0].id == id {
+		s.offset = offset
+		s.bytes -= offset
+	}
+
+	// Segment ids never go backward, otherwise a new segment could be
+	// mistaken for one that was already read.
+	s.next = id
+
+	if n := len(s.segments); n != 0 {
+		s.next = s.segments[n-1].id + 1
+	}
+
+	return
+}
+
+func (s *Spool) Close() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.output != nil {
+		err = s.output.Close()
+		s.output = nil
+	}
+
+	return
+}
+
+// Empty returns true if there are no batches waiting in the spool.
+func (s *Spool) Empty() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.head == nil && s.bytes == 0
+}
+
+// Bytes returns the size of the batches waiting in the spool.
+func (s *Spool) Bytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bytes
+}
+
+func (s *Spool) Push(group string, stream string, batch lib.MessageBatch) (err error) {
+	var b []byte
+
+	if b, err = json.Marshal(record{
+		Group:  group,
+		Stream: stream,
+		Batch:  batch,
+	}); err != nil {
+		return
+	}
+
+	b = append(b, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.output == nil {
+		return errors.New("the spool was closed")
+	}
+
+	if s.config.MaxBytes > 0 && s.bytes+int64(len(b)) > s.config.MaxBytes {
+		return ErrFull
+	}
+
+	if s.segments[len(s.segments)-1].size >= s.config.SegmentBytes {
+		if err = s.rotate(); err != nil {
+			return
+		}
+	}
+
+	if _, err = s.output.Write(b); err == nil {
+		err = s.output.Sync()
+	}
+
+	// Even on failure some bytes may have been written, the segment size
+	// is refreshed from the file so the accounting stays accurate.
+	if info, e := s.output.Stat(); e == nil {
+		last := &s.segments[len(s.segments)-1]
+		s.bytes += info.Size() - last.size
+		last.size = info.Size()
+	}
+
+	return
+}
+
+// Peek returns the oldest batch in the spool without removing it, io.EOF is
+// returned if the spool is empty.
+func (s *Spool) Peek() (group string, stream string, batch lib.MessageBatch, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for s.head == nil {
+		var line []byte
+
+		if line, err = s.readLine(); err != nil {
+			return
+		}
+
+		r := &record{}
+
+		if json.Unmarshal(line, r) != nil {
+			// The record was corrupted, there's nothing we can do but
+			// skipping it.
+			if err = s.advance(int64(len(line))); err != nil {
+				return
+			}
+			continue
+		}
+
+		s.head, s.length = r, int64(len(line))
+	}
+
+	group, stream, batch = s.head.Group, s.head.Stream, s.head.Batch
+	return
+}
+
+// Pop removes the batch returned by the last call to Peek.
+func (s *Spool) Pop() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.head == nil {
+		return
+	}
+
+	length := s.length
+	s.head, s.length = nil, 0
+	return s.advance(length)
+}
+
+func (s *Spool) readLine() (line []byte, err error) {
+	for {
+		head := s.segments[0]
+		last := len(s.segments) == 1
+
+		if s.offset < head.size {
+			var f *os.File
+
+			if f, err = os.Open(s.segmentPath(head.id)); err != nil {
+				return
+			}
+
+			if _, err = f.Seek(s.offset, io.SeekStart); err == nil {
+				line, err = bufio.NewReader(f).ReadBytes('\n')
+			}
+
+			f.Close()
+
+			if err == nil {
+				return
+			}
+
+			if err != io.EOF || last {
+				return
+			}
+
+			// The segment ends with a partial record, it was left by a crash
+			// while writing and is dropped.
+			err = nil
+		}
+
+		if last {
+			err = io.EOF
+			return
+		}
+
+		if err = s.removeHead(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Spool) advance(n int64) error {
+	s.offset += n
+	s.bytes -= n
+	return s.writeCursor()
+}
+
+func (s *Spool) rotate() (err error) {
+	var f *os.File
+	var id = s.next
+
+	if f, err = os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err != nil {
+		return
+	}
+
+	if s.output != nil {
+		s.output.Close()
+	}
+
+	s.output = f
+	s.segments = append(s.segments, segment{id: id})
+	s.next++
+	return
+}
+
+func (s *Spool) removeHead() (err error) {
+	head := s.segments[0]
+
+	if err = os.Remove(s.segmentPath(head.id)); err != nil {
+		if !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+	}
+
+	s.segments = s.segments[1:]
+	s.bytes -= head.size - s.offset
+	s.offset = 0
+
+	if len(s.segments) != 0 {
+		err = s.writeCursor()
+	}
+
+	return
+}
+
+func (s *Spool) readCursor() (id uint64, offset int64, err error) {
+	var b []byte
+
+	if b, err = ioutil.ReadFile(s.cursorPath()); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	if _, err = fmt.Sscanf(string(b), "%d %d", &id, &offset); err != nil {
+		err = fmt.Errorf("invalid spool cursor in %s: %s", s.cursorPath(), err)
+	}
+
+	return
+}
+
+func (s *Spool) writeCursor() (err error) {
+	tmp := s.cursorPath() + ".tmp"
+	data := fmt.Sprintf("%d %d\n", s.segments[0].id, s.offset)
+
+	if err = ioutil.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, s.cursorPath())
+}
+
+func (s *Spool) cursorPath() string {
+	return filepath.Join(s.dir, "cursor")
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d.log", id))
+}
+
+func parseSegmentName(name string) (id uint64, ok bool) {
+	if !strings.HasSuffix(name, ".log") {
+		return
+	}
+
+	var err error
+	id, err = strconv.ParseUint(strings.TrimSuffix(name, ".log"), 10, 64)
+	ok = err == nil
+	return
+}
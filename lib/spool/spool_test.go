@@ -0,0 +1,254 @@
+package spool
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+func makeBatch(messages ...string) (batch lib.MessageBatch) {
+	for _, s := range messages {
+		batch = append(batch, lib.Message{
+			Group:  "A",
+			Stream: "B",
+			Event: ecslogs.Event{
+				Level:   ecslogs.INFO,
+				Time:    time.Date(2016, 6, 13, 12, 23, 42, 0, time.UTC),
+				Message: s,
+			},
+		})
+	}
+	return
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	batches := []lib.MessageBatch{
+		makeBatch("Hello", "World"),
+		makeBatch("How are you?"),
+		makeBatch("Well"),
+	}
+
+	// The segment size is small enough that each batch is stored in its own
+	// segment.
+	s, err := Open(dir, Config{SegmentBytes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Empty() {
+		t.Error("a new spool should be empty")
+	}
+
+	for _, batch := range batches {
+		if err := s.Push("A", "B", batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, batch, err := s.Peek(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(batch, batches[0]) {
+		t.Errorf("invalid batch:\n- expected: %v\n- found:    %v", batches[0], batch)
+	}
+
+	if err := s.Pop(); err != nil {
+		t.Error(err)
+	}
+
+	s.Close()
+
+	// Reopen the spool, the first batch was popped so only the last two should
+	// be returned.
+	if s, err = Open(dir, Config{SegmentBytes: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, expected := range batches[1:] {
+		group, stream, batch, err := s.Peek()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if group != "A" || stream != "B" {
+			t.Errorf("invalid group and stream: %s:%s", group, stream)
+		}
+
+		if !reflect.DeepEqual(batch, expected) {
+			t.Errorf("invalid batch:\n- expected: %v\n- found:    %v", expected, batch)
+		}
+
+		if err := s.Pop(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, _, _, err := s.Peek(); err != io.EOF {
+		t.Error("expected io.EOF but got", err)
+	}
+
+	if !s.Empty() {
+		t.Error("the spool should be empty after all batches were popped")
+	}
+
+	if n := s.Bytes(); n != 0 {
+		t.Error("the spool should not contain any data after all segments were read:", n)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, Config{MaxBytes: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Push("A", "B", makeBatch("Hello World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Push("A", "B", makeBatch("Hello World!", "How are you?")); err != ErrFull {
+		t.Error("expected ErrFull but got", err)
+	}
+}
+
+func TestSpoolPartialRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Push("A", "B", makeBatch("Hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash which happened while writing a record.
+	s.output.WriteString(`{"group":"A","stream":"B","batch":[`)
+	s.Close()
+
+	if s, err = Open(dir, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Push("A", "B", makeBatch("World")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []lib.MessageBatch{makeBatch("Hello"), makeBatch("World")} {
+		_, _, batch, err := s.Peek()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(batch, expected) {
+			t.Errorf("invalid batch:\n- expected: %v\n- found:    %v", expected, batch)
+		}
+
+		s.Pop()
+	}
+
+	if _, _, _, err := s.Peek(); err != io.EOF {
+		t.Error("expected io.EOF but got", err)
+	}
+}
+
+func TestSpoolBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, Config{MaxBytes: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Push("A", "B", makeBatch("Hello World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Peek()
+	s.Pop()
+
+	// The batch was read so it doesn't count against the size limit anymore,
+	// even if it's still in the segment being written.
+	if n := s.Bytes(); n != 0 {
+		t.Error("the spool should not count the batches that were read:", n)
+	}
+
+	if err := s.Push("A", "B", makeBatch("Hello World!")); err != nil {
+		t.Error(err)
+	}
+}
+
+type failDestination struct{}
+
+func (failDestination) Open(group string, stream string) (lib.Writer, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failDestination) Close(group string, stream string) {}
+
+func TestDestinationFlush(t *testing.T) {
+	log.SetHandler(discard.New())
+
+	dir, err := ioutil.TempDir("", "spool_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDestination("test", failDestination{}, s, time.Hour)
+	w, _ := d.Open("A", "B")
+
+	if err := w.WriteMessageBatch(makeBatch("Hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flushing stops the replay which is waiting for the next retry, and
+	// closes the spool.
+	if err := lib.FlushDestination(d); err != nil {
+		t.Error(err)
+	}
+
+	if err := s.Push("A", "B", makeBatch("World")); err == nil {
+		t.Error("the spool should have been closed")
+	}
+}
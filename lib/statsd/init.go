@@ -4,4 +4,13 @@ import "github.com/segmentio/ecs-logs/lib"
 
 func init() {
 	lib.RegisterDestination("statsd", lib.DestinationFunc(NewWriter))
+	lib.RegisterDestinationFactory("statsd", func(opts lib.Options) (lib.Destination, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		return NewDestination(c)
+	})
 }
@@ -27,25 +27,62 @@ type WriterConfig struct {
 	Dial    func(addr string, group string, stream string) (Client, error)
 }
 
-func NewWriter(group string, stream string) (w lib.Writer, err error) {
-	var c WriterConfig
-	var s string
+// Config represents the options of statsd destinations declared in
+// configuration files.
+type Config struct {
+	URL string `json:"url"`
+}
+
+func (config Config) address() (address string, err error) {
 	var u *url.URL
 
-	if s = os.Getenv("STATSD_URL"); len(s) != 0 {
-		if u, err = url.Parse(s); err != nil {
-			err = fmt.Errorf("invalid statsd URL: %s", err)
-			return
-		}
+	if len(config.URL) == 0 {
+		return
+	}
 
-		if u.Scheme != "udp" {
-			err = fmt.Errorf("invalid statsd URL: only the UDP protocol is supported but %s was found", u.Scheme)
-			return
-		}
+	if u, err = url.Parse(config.URL); err != nil {
+		err = fmt.Errorf("invalid statsd URL: %s", err)
+		return
+	}
 
-		c.Address = u.Host
+	if u.Scheme != "udp" {
+		err = fmt.Errorf("invalid statsd URL: only the UDP protocol is supported but %s was found", u.Scheme)
+		return
 	}
 
+	address = u.Host
+	return
+}
+
+func NewWriter(group string, stream string) (w lib.Writer, err error) {
+	var address string
+
+	if address, err = (Config{URL: os.Getenv("STATSD_URL")}).address(); err != nil {
+		err = lib.PermanentError(err)
+		return
+	}
+
+	return newWriter(address, group, stream)
+}
+
+// NewDestination returns a destination which sends metrics to the statsd
+// agent configured by config.
+func NewDestination(config Config) (lib.Destination, error) {
+	address, err := config.address()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.DestinationFunc(func(group string, stream string) (lib.Writer, error) {
+		return newWriter(address, group, stream)
+	}), nil
+}
+
+func newWriter(address string, group string, stream string) (lib.Writer, error) {
+	var c WriterConfig
+
+	c.Address = address
 	c.Group = group
 	c.Stream = stream
 
@@ -22,6 +22,33 @@ func (store *Store) Add(msg Message, now time.Time) (group *Group, stream *Strea
 	return
 }
 
+// Stream returns the stream with the given group and name, or nil if it
+// doesn't exist.
+func (store *Store) Stream(group string, stream string) *Stream {
+	if g := store.groups[group]; g != nil {
+		return g.streams[stream]
+	}
+	return nil
+}
+
+// Oldest returns the stream which first buffered message is the oldest, or nil
+// if no messages are buffered.
+func (store *Store) Oldest() (oldest *Stream) {
+	var t time.Time
+
+	for _, group := range store.groups {
+		for _, stream := range group.streams {
+			if len(stream.messages) != 0 {
+				if first := stream.messages[0].Event.Time; oldest == nil || first.Before(t) {
+					oldest, t = stream, first
+				}
+			}
+		}
+	}
+
+	return
+}
+
 func (store *Store) RemoveExpired(timeout time.Duration, now time.Time) (streams []*Stream) {
 	for name, group := range store.groups {
 		streams = append(streams, group.RemoveExpired(timeout, now)...)
@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+)
+
+func TestStoreOldest(t *testing.T) {
+	now := time.Now()
+	store := NewStore()
+
+	if store.Oldest() != nil {
+		t.Error("an empty store should have no oldest stream")
+	}
+
+	store.Add(Message{Group: "A", Stream: "1", Event: ecslogs.Event{Time: now}}, now)
+	store.Add(Message{Group: "B", Stream: "2", Event: ecslogs.Event{Time: now.Add(-time.Second)}}, now)
+	store.Add(Message{Group: "A", Stream: "3", Event: ecslogs.Event{Time: now.Add(time.Second)}}, now)
+
+	if s := store.Oldest(); s == nil || s.Group() != "B" || s.Name() != "2" {
+		t.Errorf("invalid oldest stream: %v", s)
+	}
+
+	store.Stream("B", "2").DropOldest(1)
+
+	if s := store.Oldest(); s == nil || s.Group() != "A" || s.Name() != "1" {
+		t.Errorf("invalid oldest stream: %v", s)
+	}
+}
@@ -51,6 +51,25 @@ func (stream *Stream) Add(msg Message, now time.Time) {
 	stream.updatedOn = now
 }
 
+// DropOldest removes messages from the head of the stream until their size
+// reaches bytes or the stream is empty, the removed messages are returned.
+func (stream *Stream) DropOldest(bytes int) (list MessageBatch) {
+	count := 0
+	freed := 0
+
+	for _, msg := range stream.messages {
+		if freed >= bytes {
+			break
+		}
+		freed += msg.ContentLength()
+		count++
+	}
+
+	list, stream.messages = splitMessageListHead(stream.messages, count)
+	stream.bytes -= freed
+	return
+}
+
 func (stream *Stream) HasExpired(timeout time.Duration, now time.Time) bool {
 	return len(stream.messages) == 0 && now.Sub(stream.updatedOn) >= timeout
 }
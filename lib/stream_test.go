@@ -182,3 +182,29 @@ func TestStreamBytes(t *testing.T) {
 		t.Error("invalid stream bytes count left in stream:", st.bytes)
 	}
 }
+
+func TestStreamDropOldest(t *testing.T) {
+	ts := time.Now()
+	st := NewStream("A", "0123456789", ts)
+	m1 := Message{Group: "A", Stream: "0123456789", Event: ecslogs.Event{Message: "Hello World!"}}
+	m2 := Message{Group: "A", Stream: "0123456789", Event: ecslogs.Event{Message: "How are you?"}}
+
+	st.Add(m1, ts)
+	st.Add(m2, ts)
+
+	if list := st.DropOldest(1); !reflect.DeepEqual(list, MessageBatch{m1}) {
+		t.Error("invalid list of messages dropped from stream:", list)
+	}
+
+	if st.bytes != m2.ContentLength() {
+		t.Error("invalid stream bytes count left in stream:", st.bytes)
+	}
+
+	if list := st.DropOldest(1000); !reflect.DeepEqual(list, MessageBatch{m2}) {
+		t.Error("invalid list of messages dropped from stream:", list)
+	}
+
+	if list := st.DropOldest(1000); len(list) != 0 {
+		t.Error("no messages should be dropped from an empty stream:", list)
+	}
+}
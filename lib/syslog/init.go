@@ -4,4 +4,30 @@ import "github.com/segmentio/ecs-logs/lib"
 
 func init() {
 	lib.RegisterDestination("syslog", lib.DestinationFunc(NewWriter))
+	lib.RegisterDestinationFactory("syslog", func(opts lib.Options) (lib.Destination, error) {
+		var c Config
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		return NewDestination(c)
+	})
+
+	lib.RegisterSource("syslog", lib.SourceFunc(NewReader))
+	lib.RegisterSourceFactory("syslog", func(opts lib.Options) (lib.Source, error) {
+		var c SourceConfig
+
+		if err := opts.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		if _, _, err := c.listenAddress(); err != nil {
+			return nil, err
+		}
+
+		return lib.SourceFunc(func() (lib.Reader, error) {
+			return OpenReader(c)
+		}), nil
+	})
 }
@@ -0,0 +1,259 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Message is a syslog message parsed from the RFC 5424 or the RFC 3164 format,
+// fields that were missing in the message are left empty.
+type Message struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Text           string
+}
+
+var errInvalidPriority = errors.New("invalid syslog message, missing or malformed priority")
+
+// ParseMessage parses a syslog message, the format is detected from the version
+// field that follows the priority in RFC 5424 messages. The year of RFC 3164
+// timestamps, which don't have one, is guessed from now.
+func ParseMessage(b []byte, now time.Time) (m Message, err error) {
+	var prival int
+
+	if prival, b, err = parsePriority(b); err != nil {
+		return
+	}
+
+	m.Facility = prival / 8
+	m.Severity = prival % 8
+
+	if bytes.HasPrefix(b, []byte("1 ")) {
+		err = parseRFC5424(&m, b[2:])
+	} else {
+		parseRFC3164(&m, b, now)
+	}
+
+	return
+}
+
+func parsePriority(b []byte) (prival int, rest []byte, err error) {
+	i := bytes.IndexByte(b, '>')
+
+	if len(b) < 3 || b[0] != '<' || i < 2 || i > 4 {
+		err = errInvalidPriority
+		return
+	}
+
+	if prival, err = strconv.Atoi(string(b[1:i])); err != nil || prival > 191 {
+		err = errInvalidPriority
+		return
+	}
+
+	rest = b[i+1:]
+	return
+}
+
+func parseRFC5424(m *Message, b []byte) (err error) {
+	var fields [5]string
+
+	for i := range fields {
+		if fields[i], b, err = nextField(b); err != nil {
+			return
+		}
+	}
+
+	if fields[0] != "-" {
+		if m.Timestamp, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+			return fmt.Errorf("invalid syslog timestamp: %s", err)
+		}
+	}
+
+	m.Hostname = nilValue(fields[1])
+	m.AppName = nilValue(fields[2])
+	m.ProcID = nilValue(fields[3])
+	m.MsgID = nilValue(fields[4])
+
+	if m.StructuredData, b, err = parseStructuredData(b); err != nil {
+		return
+	}
+
+	if len(b) != 0 && b[0] == ' ' {
+		b = b[1:]
+	}
+
+	// The message may start with a byte order mark to indicate UTF-8.
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	m.Text = strings.TrimRight(string(b), "\r\n")
+	return
+}
+
+func nextField(b []byte) (field string, rest []byte, err error) {
+	i := bytes.IndexByte(b, ' ')
+
+	if i <= 0 {
+		err = errors.New("invalid syslog message, missing header fields")
+		return
+	}
+
+	return string(b[:i]), b[i+1:], nil
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parseStructuredData parses the [id param="value" ...] elements of a RFC 5424
+// message.
+func parseStructuredData(b []byte) (data map[string]map[string]string, rest []byte, err error) {
+	if len(b) != 0 && b[0] == '-' {
+		rest = b[1:]
+		return
+	}
+
+	errInvalid := errors.New("invalid syslog structured data")
+
+	for len(b) != 0 && b[0] == '[' {
+		b = b[1:]
+		i := bytes.IndexAny(b, " ]")
+
+		if i <= 0 {
+			err = errInvalid
+			return
+		}
+
+		id := string(b[:i])
+		params := make(map[string]string)
+		b = b[i:]
+
+		for len(b) != 0 && b[0] == ' ' {
+			b = b[1:]
+			j := bytes.IndexByte(b, '=')
+
+			if j <= 0 || j+1 >= len(b) || b[j+1] != '"' {
+				err = errInvalid
+				return
+			}
+
+			name := string(b[:j])
+			b = b[j+2:]
+
+			var value []byte
+			closed := false
+
+			for k := 0; k < len(b); k++ {
+				if b[k] == '\\' && k+1 < len(b) && (b[k+1] == '"' || b[k+1] == '\\' || b[k+1] == ']') {
+					value = append(value, b[k+1])
+					k++
+					continue
+				}
+
+				if b[k] == '"' {
+					b, closed = b[k+1:], true
+					break
+				}
+
+				value = append(value, b[k])
+			}
+
+			if !closed {
+				err = errInvalid
+				return
+			}
+
+			params[name] = string(value)
+		}
+
+		if len(b) == 0 || b[0] != ']' {
+			err = errInvalid
+			return
+		}
+
+		b = b[1:]
+
+		if data == nil {
+			data = make(map[string]map[string]string)
+		}
+
+		data[id] = params
+	}
+
+	rest = b
+	return
+}
+
+// parseRFC3164 parses the "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG" format, which
+// is loosely followed so parts that can't be parsed are left in the message.
+func parseRFC3164(m *Message, b []byte, now time.Time) {
+	s := strings.TrimRight(string(b), "\r\n")
+
+	if len(s) >= 16 && s[15] == ' ' {
+		if t, err := time.ParseInLocation(time.Stamp, s[:15], now.Location()); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+
+			// Messages from the end of December received in January are
+			// from the previous year.
+			if t.Sub(now) > 24*time.Hour {
+				t = t.AddDate(-1, 0, 0)
+			}
+
+			m.Timestamp = t
+			s = s[16:]
+		}
+	}
+
+	if m.Timestamp.IsZero() {
+		m.Text = s
+		return
+	}
+
+	// The hostname is optional, messages sent to the local socket usually
+	// don't have one, in which case the first word is the tag.
+	if word, rest := splitWord(s); len(word) != 0 && !isTag(word) {
+		m.Hostname, s = word, rest
+	}
+
+	if word, rest := splitWord(s); isTag(word) {
+		word = strings.TrimSuffix(word, ":")
+
+		if i := strings.IndexByte(word, '['); i >= 0 {
+			m.AppName = word[:i]
+			m.ProcID = strings.TrimSuffix(word[i+1:], "]")
+		} else {
+			m.AppName = word
+		}
+
+		s = rest
+	}
+
+	m.Text = s
+}
+
+func splitWord(s string) (word string, rest string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func isTag(word string) bool {
+	if !strings.HasSuffix(word, ":") || !utf8.ValidString(word) {
+		return false
+	}
+	word = strings.TrimSuffix(word, ":")
+	return len(word) != 0 && (!strings.ContainsAny(word, "[]") || strings.HasSuffix(word, "]"))
+}
@@ -0,0 +1,261 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+const (
+	DefaultListenURL = "udp://:514"
+
+	// The maximum size of messages received by syslog sources.
+	maxMessageBytes = 65536
+)
+
+// SourceConfig represents the options of syslog sources.
+type SourceConfig struct {
+	// The address to listen on, the scheme is one of udp, tcp, tls, unix or
+	// unixgram, for example unixgram:///dev/log. It defaults to udp://:514.
+	URL string `json:"url"`
+
+	// The certificate and key used by tls listeners.
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// The group and stream of messages that have no APP-NAME or HOSTNAME,
+	// they default to "syslog" and the address of the sender (or "local" for
+	// unix sockets).
+	Group  string `json:"group"`
+	Stream string `json:"stream"`
+}
+
+func (c SourceConfig) listenAddress() (network string, address string, err error) {
+	var u *url.URL
+
+	if len(c.URL) == 0 {
+		c.URL = DefaultListenURL
+	}
+
+	if u, err = url.Parse(c.URL); err != nil {
+		err = fmt.Errorf("invalid syslog URL: %s", err)
+		return
+	}
+
+	switch network = u.Scheme; network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tls":
+		address = u.Host
+	case "unix", "unixgram":
+		address = u.Path
+	default:
+		err = fmt.Errorf("invalid syslog URL, the scheme must be one of udp, tcp, tls, unix or unixgram: %s", c.URL)
+		return
+	}
+
+	if network == "tls" && (len(c.CertFile) == 0 || len(c.KeyFile) == 0) {
+		err = errors.New("a certificate and a key are required to listen with tls")
+	}
+
+	return
+}
+
+func NewReader() (lib.Reader, error) {
+	return OpenReader(SourceConfig{
+		URL:      os.Getenv("SYSLOG_LISTEN_URL"),
+		CertFile: os.Getenv("SYSLOG_TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("SYSLOG_TLS_KEY_FILE"),
+	})
+}
+
+func OpenReader(config SourceConfig) (lib.Reader, error) {
+	network, address, err := config.listenAddress()
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reader{
+		Listener: lib.NewListener("syslog"),
+		config:   config,
+	}
+
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		err = r.ListenPacket(network, address, r.handle)
+
+	case "tls":
+		var cert tls.Certificate
+
+		if cert, err = tls.LoadX509KeyPair(config.CertFile, config.KeyFile); err == nil {
+			err = r.Listen("tcp", address, &tls.Config{Certificates: []tls.Certificate{cert}}, r.serve)
+		}
+
+	default:
+		err = r.Listen(network, address, nil, r.serve)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+type reader struct {
+	*lib.Listener
+	config SourceConfig
+}
+
+func (r *reader) serve(conn net.Conn, from string) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxMessageBytes+16)
+	scanner.Split(splitFrame)
+
+	for scanner.Scan() {
+		if !r.handle(scanner.Bytes(), from) {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !r.Closed() {
+		log.WithFields(log.Fields{
+			"from":  from,
+			"error": err,
+		}).Error("closing syslog connection")
+	}
+}
+
+// splitFrame splits the messages of a syslog stream, they are framed either by
+// octet counting (a length followed by a space) or by new lines, as described
+// by RFC 6587.
+func splitFrame(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 {
+		return
+	}
+
+	if data[0] >= '1' && data[0] <= '9' {
+		i := bytes.IndexByte(data, ' ')
+
+		if i < 0 {
+			if atEOF || len(data) > 10 {
+				err = errors.New("invalid syslog frame length")
+			}
+			return
+		}
+
+		var n int
+
+		if n, err = strconv.Atoi(string(data[:i])); err != nil || n > maxMessageBytes {
+			err = errors.New("invalid syslog frame length")
+			return
+		}
+
+		if len(data) < i+1+n {
+			if atEOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+
+		return i + 1 + n, data[i+1 : i+1+n], nil
+	}
+
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, bytes.TrimRight(data[:i], "\r\x00"), nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return
+}
+
+// handle parses b and sends the message to the reader, it returns false if the
+// reader was closed.
+func (r *reader) handle(b []byte, from string) bool {
+	m, err := ParseMessage(b, time.Now())
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"from":  from,
+			"error": err,
+		}).Warn("dropping invalid syslog message")
+		return true
+	}
+
+	return r.Send(r.message(m, from))
+}
+
+func (r *reader) message(m Message, from string) lib.Message {
+	msg := lib.Message{
+		Group:  m.AppName,
+		Stream: m.Hostname,
+	}
+
+	msg.Event, msg.PlainText = lib.ParseText(m.Text)
+
+	if len(msg.Group) == 0 {
+		if msg.Group = r.config.Group; len(msg.Group) == 0 {
+			msg.Group = "syslog"
+		}
+	}
+
+	if len(msg.Stream) == 0 {
+		if msg.Stream = r.config.Stream; len(msg.Stream) == 0 {
+			msg.Stream = from
+		}
+	}
+
+	e := &msg.Event
+
+	if e.Level == ecslogs.NONE {
+		e.Level = ecslogs.MakeLevel(m.Severity)
+	}
+
+	if e.Time.IsZero() {
+		e.Time = m.Timestamp
+	}
+
+	if len(e.Info.Host) == 0 {
+		e.Info.Host = m.Hostname
+	}
+
+	if len(e.Info.ID) == 0 {
+		e.Info.ID = m.MsgID
+	}
+
+	if e.Info.PID == 0 {
+		e.Info.PID, _ = strconv.Atoi(m.ProcID)
+	}
+
+	if len(m.StructuredData) != 0 {
+		if e.Data == nil {
+			e.Data = ecslogs.EventData{}
+		}
+
+		for id, params := range m.StructuredData {
+			d := make(map[string]interface{}, len(params))
+
+			for k, v := range params {
+				d[k] = v
+			}
+
+			e.Data[id] = d
+		}
+	}
+
+	return msg
+}
@@ -0,0 +1,171 @@
+package syslog
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ecs-logs-go"
+)
+
+func TestParseMessageRFC5424(t *testing.T) {
+	m, err := ParseMessage([]byte(`<165>1 2003-10-11T22:14:15.003Z host.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"][meta a="b"] `+"\xef\xbb\xbf"+`An application event`), time.Now())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Message{
+		Facility:  20,
+		Severity:  5,
+		Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+		Hostname:  "host.example.com",
+		AppName:   "evntslog",
+		ProcID:    "1234",
+		MsgID:     "ID47",
+		StructuredData: map[string]map[string]string{
+			"exampleSDID@32473": {"iut": "3", "eventSource": `App"lication`},
+			"meta":              {"a": "b"},
+		},
+		Text: "An application event",
+	}
+
+	if !m.Timestamp.Equal(expected.Timestamp) {
+		t.Error("invalid timestamp:", m.Timestamp)
+	}
+
+	m.Timestamp = expected.Timestamp
+
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("invalid message:\n- expected: %#v\n- found:    %#v", expected, m)
+	}
+}
+
+func TestParseMessageRFC3164(t *testing.T) {
+	now := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input   string
+		message Message
+	}{
+		{
+			input: "<34>Oct 11 22:14:15 mymachine su[42]: 'su root' failed",
+			message: Message{
+				Facility:  4,
+				Severity:  2,
+				Timestamp: time.Date(2016, 10, 11, 22, 14, 15, 0, time.UTC),
+				Hostname:  "mymachine",
+				AppName:   "su",
+				ProcID:    "42",
+				Text:      "'su root' failed",
+			},
+		},
+		{
+			input: "<13>Jan  1 23:59:59 cron: job started\n",
+			message: Message{
+				Facility:  1,
+				Severity:  5,
+				Timestamp: time.Date(2017, 1, 1, 23, 59, 59, 0, time.UTC),
+				AppName:   "cron",
+				Text:      "job started",
+			},
+		},
+		{
+			input:   "<13>hello world",
+			message: Message{Facility: 1, Severity: 5, Text: "hello world"},
+		},
+	}
+
+	for _, test := range tests {
+		m, err := ParseMessage([]byte(test.input), now)
+
+		if err != nil {
+			t.Errorf("%q: %s", test.input, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(m, test.message) {
+			t.Errorf("%q: invalid message:\n- expected: %#v\n- found:    %#v", test.input, test.message, m)
+		}
+	}
+}
+
+func TestParseMessageError(t *testing.T) {
+	for _, s := range []string{"", "hello", "<>x", "<1000>x", "<13>1 -"} {
+		if _, err := ParseMessage([]byte(s), time.Now()); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestReaderTCP(t *testing.T) {
+	r, err := OpenReader(SourceConfig{URL: "tcp://127.0.0.1:0"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	conn, err := net.Dial("tcp", r.(*reader).Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	// Octet counting and new line framing on the same connection.
+	frame := "<11>1 2017-01-01T00:00:00Z web nginx - - - failed!"
+	fmt.Fprintf(conn, "%d %s", len(frame), frame)
+	conn.Write([]byte("<14>Jan  1 00:00:00 api: {\"level\":\"WARN\",\"message\":\"slow\"}\n"))
+
+	msg, err := r.ReadMessage()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Group != "nginx" || msg.Stream != "web" || msg.Event.Level != ecslogs.ERROR || msg.Event.Message != "failed!" {
+		t.Errorf("invalid message: %#v", msg)
+	}
+
+	if msg, err = r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Group != "api" || msg.Stream != "127.0.0.1" || msg.Event.Level != ecslogs.WARN || msg.Event.Message != "slow" {
+		t.Errorf("invalid message: %#v", msg)
+	}
+}
+
+func TestReaderUDP(t *testing.T) {
+	r, err := OpenReader(SourceConfig{URL: "udp://127.0.0.1:0", Stream: "S"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	conn, err := net.Dial("udp", r.(*reader).Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	conn.Write([]byte("<15>hello"))
+
+	msg, err := r.ReadMessage()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Group != "syslog" || msg.Stream != "S" || msg.Event.Level != ecslogs.DEBUG || msg.Event.Message != "hello" {
+		t.Errorf("invalid message: %#v", msg)
+	}
+}
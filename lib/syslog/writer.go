@@ -33,6 +33,32 @@ var (
 	connPools     map[string]*pool.LimitedConnPool
 )
 
+// Config represents the options of syslog destinations declared in
+// configuration files.
+type Config struct {
+	URL        string `json:"url"`
+	Template   string `json:"template"`
+	TimeFormat string `json:"time_format"`
+}
+
+func (c Config) writerConfig() (config WriterConfig, err error) {
+	if len(c.URL) != 0 {
+		var u *url.URL
+
+		if u, err = url.Parse(c.URL); err != nil {
+			err = fmt.Errorf("invalid syslog URL: %s", err)
+			return
+		}
+
+		config.Network = u.Scheme
+		config.Address = u.Host
+	}
+
+	config.Template = c.Template
+	config.TimeFormat = c.TimeFormat
+	return
+}
+
 type WriterConfig struct {
 	Network    string
 	Address    string
@@ -64,22 +90,35 @@ func init() {
 }
 
 func NewWriter(group, stream string) (lib.Writer, error) {
-	var c WriterConfig
+	c, err := Config{
+		URL:        os.Getenv("SYSLOG_URL"),
+		Template:   os.Getenv("SYSLOG_TEMPLATE"),
+		TimeFormat: os.Getenv("SYSLOG_TIME_FORMAT"),
+	}.writerConfig()
 
-	if s := os.Getenv("SYSLOG_URL"); len(s) != 0 {
-		u, err := url.Parse(s)
-		if err != nil {
-			return nil, fmt.Errorf("invalid syslog URL: %s", err)
-		}
+	if err != nil {
+		return nil, lib.PermanentError(err)
+	}
 
-		c.Network = u.Scheme
-		c.Address = u.Host
+	return DialWriter(c)
+}
+
+// NewDestination returns a destination which writes to the syslog server
+// configured by config.
+func NewDestination(config Config) (lib.Destination, error) {
+	c, err := config.writerConfig()
+
+	if err != nil {
+		return nil, err
 	}
 
-	c.Template = os.Getenv("SYSLOG_TEMPLATE")
-	c.TimeFormat = os.Getenv("SYSLOG_TIME_FORMAT")
+	if err = ValidateTemplate(c.Template); err != nil {
+		return nil, err
+	}
 
-	return DialWriter(c)
+	return lib.DestinationFunc(func(group string, stream string) (lib.Writer, error) {
+		return DialWriter(c)
+	}), nil
 }
 
 func DialWriter(config WriterConfig) (lib.Writer, error) {
@@ -232,6 +271,17 @@ func getPool(opts dialOpts) (*pool.LimitedConnPool, error) {
 	return p, nil
 }
 
+// ValidateTemplate returns an error if format cannot be used as the template
+// of syslog messages, an empty format selects the default template.
+func ValidateTemplate(format string) error {
+	if len(format) != 0 {
+		if _, err := template.New("syslog").Parse(format); err != nil {
+			return fmt.Errorf("invalid syslog template: %s", err)
+		}
+	}
+	return nil
+}
+
 func newWriterTemplate(format string) *template.Template {
 	if !strings.HasSuffix(format, "\n") {
 		format += "\n"
@@ -0,0 +1,282 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+)
+
+// A Rule modifies the data of the messages matching its conditions. Keys are
+// paths to event fields with nested objects separated by dots, the operations
+// are applied in the order of the fields below.
+type Rule struct {
+	match.Config
+
+	// Keys of fields that contain JSON strings to decode in place, the values
+	// that aren't valid JSON are left unchanged.
+	ParseJSON []string `json:"parse_json"`
+
+	// Keys of fields to rename, mapped to their new keys.
+	Rename map[string]string `json:"rename"`
+
+	// Keys of fields to copy, mapped to the keys of the copies.
+	Copy map[string]string `json:"copy"`
+
+	// Keys of fields to delete.
+	Delete []string `json:"delete"`
+
+	// Static values to set, existing values are overwritten.
+	Set map[string]interface{} `json:"set"`
+
+	// The key of a field to set the event level from, the value can be a
+	// level name (like "ERROR") or a syslog priority between 0 and 7.
+	LevelFrom string `json:"level_from"`
+}
+
+// A Transform applies a list of rules to messages, all the rules that match a
+// message are applied in order.
+type Transform struct {
+	rules []rule
+}
+
+type rule struct {
+	Rule
+	matcher *match.Matcher
+	rename  []string
+	copy    []string
+	set     []string
+}
+
+// New compiles rules into a transform.
+func New(rules []Rule) (*Transform, error) {
+	t := &Transform{rules: make([]rule, 0, len(rules))}
+
+	for i, r := range rules {
+		m, err := match.New(r.Config)
+
+		if err != nil {
+			return nil, fmt.Errorf("transform #%d: %s", i+1, err)
+		}
+
+		set := make([]string, 0, len(r.Set))
+
+		for k := range r.Set {
+			set = append(set, k)
+		}
+
+		sort.Strings(set)
+
+		// Map iterations are randomized, the keys are sorted so rules that
+		// rename, copy or set fields to the same key always give the same
+		// result.
+		t.rules = append(t.rules, rule{
+			Rule:    r,
+			matcher: m,
+			rename:  sortedKeys(r.Rename),
+			copy:    sortedKeys(r.Copy),
+			set:     set,
+		})
+	}
+
+	return t, nil
+}
+
+// Apply modifies the data of msg, which must not be shared with other
+// messages.
+func (t *Transform) Apply(msg *lib.Message) {
+	for _, r := range t.rules {
+		if r.matcher.Match(*msg) {
+			r.apply(msg)
+		}
+	}
+}
+
+func (r *rule) apply(msg *lib.Message) {
+	if msg.Event.Data == nil {
+		msg.Event.Data = ecslogs.EventData{}
+	}
+
+	data := msg.Event.Data
+
+	for _, k := range r.ParseJSON {
+		if s, ok := lookupString(data, k); ok {
+			var v interface{}
+			d := json.NewDecoder(strings.NewReader(s))
+			d.UseNumber()
+
+			if d.Decode(&v) == nil {
+				Set(data, k, v)
+			}
+		}
+	}
+
+	for _, k := range r.rename {
+		if v, ok := match.Lookup(data, k); ok {
+			Delete(data, k)
+			Set(data, r.Rename[k], v)
+		}
+	}
+
+	// Copied and static values are cloned, otherwise modifying one of them
+	// would change the others, or the values set on other messages.
+	for _, k := range r.copy {
+		if v, ok := match.Lookup(data, k); ok {
+			Set(data, r.Copy[k], clone(v))
+		}
+	}
+
+	for _, k := range r.Delete {
+		Delete(data, k)
+	}
+
+	for _, k := range r.set {
+		Set(data, k, clone(r.Set[k]))
+	}
+
+	if len(r.LevelFrom) != 0 {
+		if v, ok := match.Lookup(data, r.LevelFrom); ok {
+			if lvl, ok := parseLevel(v); ok {
+				msg.Event.Level = lvl
+			}
+		}
+	}
+}
+
+// Set stores v at path in data, creating the nested objects that don't exist.
+func Set(data ecslogs.EventData, path string, v interface{}) {
+	var m map[string]interface{} = data
+
+	for {
+		i := strings.IndexByte(path, '.')
+
+		if i < 0 {
+			m[path] = v
+			return
+		}
+
+		k := path[:i]
+
+		switch x := m[k].(type) {
+		case map[string]interface{}:
+			m = x
+		case ecslogs.EventData:
+			m = x
+		default:
+			n := map[string]interface{}{}
+			m[k] = n
+			m = n
+		}
+
+		path = path[i+1:]
+	}
+}
+
+// Delete removes the value at path in data.
+func Delete(data ecslogs.EventData, path string) {
+	if _, ok := data[path]; ok {
+		delete(data, path)
+		return
+	}
+
+	var m map[string]interface{} = data
+
+	for {
+		i := strings.IndexByte(path, '.')
+
+		if i < 0 {
+			delete(m, path)
+			return
+		}
+
+		switch x := m[path[:i]].(type) {
+		case map[string]interface{}:
+			m = x
+		case ecslogs.EventData:
+			m = x
+		default:
+			return
+		}
+
+		path = path[i+1:]
+	}
+}
+
+// clone returns a deep copy of the objects and arrays of v.
+func clone(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			m[k] = clone(v)
+		}
+		return m
+	case ecslogs.EventData:
+		m := make(ecslogs.EventData, len(x))
+		for k, v := range x {
+			m[k] = clone(v)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(x))
+		for i, v := range x {
+			a[i] = clone(v)
+		}
+		return a
+	default:
+		return v
+	}
+}
+
+func lookupString(data ecslogs.EventData, path string) (s string, ok bool) {
+	var v interface{}
+
+	if v, ok = match.Lookup(data, path); ok {
+		s, ok = v.(string)
+	}
+
+	return
+}
+
+func parseLevel(v interface{}) (lvl ecslogs.Level, ok bool) {
+	var s string
+
+	switch x := v.(type) {
+	case string:
+		s = x
+	case json.Number:
+		s = x.String()
+	case float64:
+		s = strconv.FormatFloat(x, 'f', -1, 64)
+	case int:
+		s = strconv.Itoa(x)
+	default:
+		return
+	}
+
+	if p, err := strconv.Atoi(s); err == nil {
+		if p < 0 || p > 7 {
+			return
+		}
+		return ecslogs.MakeLevel(p), true
+	}
+
+	lvl, err := ecslogs.ParseLevel(s)
+	return lvl, err == nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,115 @@
+package transform
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/match"
+)
+
+func TestTransform(t *testing.T) {
+	tr, err := New([]Rule{
+		{
+			ParseJSON: []string{"payload"},
+			Rename:    map[string]string{"CONTAINER_ID": "container.id"},
+			Copy:      map[string]string{"payload.user": "user"},
+			Delete:    []string{"secret"},
+			Set:       map[string]interface{}{"env": "prod", "team.name": "billing"},
+			LevelFrom: "payload.severity",
+		},
+		{
+			Config: match.Config{Group: "other"},
+			Set:    map[string]interface{}{"other": true},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := lib.Message{
+		Group: "billing",
+		Event: ecslogs.Event{
+			Level: ecslogs.INFO,
+			Data: ecslogs.EventData{
+				"payload":      `{"user":"bob","severity":"error"}`,
+				"CONTAINER_ID": "1234",
+				"secret":       "hunter2",
+			},
+		},
+	}
+
+	tr.Apply(&msg)
+
+	expected := ecslogs.EventData{
+		"payload":   map[string]interface{}{"user": "bob", "severity": "error"},
+		"container": map[string]interface{}{"id": "1234"},
+		"user":      "bob",
+		"env":       "prod",
+		"team":      map[string]interface{}{"name": "billing"},
+	}
+
+	if !reflect.DeepEqual(msg.Event.Data, expected) {
+		t.Errorf("invalid data:\n- expected: %#v\n- found:    %#v", expected, msg.Event.Data)
+	}
+
+	if msg.Event.Level != ecslogs.ERROR {
+		t.Error("invalid level:", msg.Event.Level)
+	}
+}
+
+func TestTransformValuesAreNotShared(t *testing.T) {
+	tr, _ := New([]Rule{{
+		Copy: map[string]string{"request": "copy"},
+		Set:  map[string]interface{}{"tags": map[string]interface{}{"env": "prod"}, "tags.team": "billing"},
+	}})
+
+	m1 := lib.Message{Event: ecslogs.Event{Data: ecslogs.EventData{"request": map[string]interface{}{"id": "1"}}}}
+	m2 := lib.Message{Event: ecslogs.Event{}}
+
+	tr.Apply(&m1)
+	tr.Apply(&m2)
+
+	// The keys are set in order, so the nested key is always set last.
+	expected := map[string]interface{}{"env": "prod", "team": "billing"}
+
+	if !reflect.DeepEqual(m2.Event.Data["tags"], expected) {
+		t.Errorf("invalid tags: %#v", m2.Event.Data["tags"])
+	}
+
+	m1.Event.Data["tags"].(map[string]interface{})["env"] = "dev"
+	m1.Event.Data["copy"].(map[string]interface{})["id"] = "2"
+
+	if !reflect.DeepEqual(m2.Event.Data["tags"], expected) {
+		t.Errorf("static values should not be shared between messages: %#v", m2.Event.Data["tags"])
+	}
+
+	if id := m1.Event.Data["request"].(map[string]interface{})["id"]; id != "1" {
+		t.Errorf("copied values should not be shared with the original: %v", id)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		level ecslogs.Level
+		ok    bool
+	}{
+		{"warn", ecslogs.WARN, true},
+		{"3", ecslogs.ERROR, true},
+		{json.Number("7"), ecslogs.DEBUG, true},
+		{float64(0), ecslogs.EMERG, true},
+		{"8", ecslogs.NONE, false},
+		{"whatever", ecslogs.NONE, false},
+		{true, ecslogs.NONE, false},
+	}
+
+	for _, test := range tests {
+		if lvl, ok := parseLevel(test.value); ok != test.ok || (ok && lvl != test.level) {
+			t.Errorf("%#v: expected %s (%t) but found %s (%t)", test.value, test.level, test.ok, lvl, ok)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"expvar"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -21,11 +24,29 @@ import (
 	"github.com/apex/log/handlers/multi"
 	"github.com/segmentio/ecs-logs-go"
 	"github.com/segmentio/ecs-logs/lib"
+	"github.com/segmentio/ecs-logs/lib/config"
+	"github.com/segmentio/ecs-logs/lib/filter"
+	"github.com/segmentio/ecs-logs/lib/multiline"
+	"github.com/segmentio/ecs-logs/lib/parse"
+	"github.com/segmentio/ecs-logs/lib/redact"
+	"github.com/segmentio/ecs-logs/lib/route"
+	"github.com/segmentio/ecs-logs/lib/spool"
+	"github.com/segmentio/ecs-logs/lib/transform"
 
 	_ "github.com/segmentio/ecs-logs/lib/cloudwatchlogs"
 	_ "github.com/segmentio/ecs-logs/lib/datadog"
+	_ "github.com/segmentio/ecs-logs/lib/docker"
+	_ "github.com/segmentio/ecs-logs/lib/elasticsearch"
+	_ "github.com/segmentio/ecs-logs/lib/file"
+	_ "github.com/segmentio/ecs-logs/lib/fluentd"
+	_ "github.com/segmentio/ecs-logs/lib/gelf"
+	_ "github.com/segmentio/ecs-logs/lib/http"
+	_ "github.com/segmentio/ecs-logs/lib/kinesis"
 	_ "github.com/segmentio/ecs-logs/lib/logdna"
 	_ "github.com/segmentio/ecs-logs/lib/loggly"
+	_ "github.com/segmentio/ecs-logs/lib/loki"
+	_ "github.com/segmentio/ecs-logs/lib/s3"
+	_ "github.com/segmentio/ecs-logs/lib/splunk"
 	_ "github.com/segmentio/ecs-logs/lib/statsd"
 	_ "github.com/segmentio/ecs-logs/lib/syslog"
 )
@@ -38,6 +59,75 @@ type source struct {
 type destination struct {
 	lib.Destination
 	name string
+
+	// Batches are submitted to the backlog of the destination, from where
+	// they are handed to the workers. Each worker has its own queue, the batches
+	// of a stream always go to the same one so they are written in order.
+	backlog *backlog
+	queues  []chan job
+	join    *sync.WaitGroup
+}
+
+// backlog holds the batches submitted to a destination until they are handed
+// to its workers, so a destination that stalls doesn't block the
+// main loop nor the other destinations. The batches in the backlog are still
+// accounted for by the memory budget, which applies its overflow policy when
+// destinations don't keep up.
+type backlog struct {
+	mutex  sync.Mutex
+	jobs   []job
+	closed bool
+	signal chan struct{}
+}
+
+func newBacklog() *backlog {
+	return &backlog{signal: make(chan struct{}, 1)}
+}
+
+func (b *backlog) push(j job) {
+	b.mutex.Lock()
+	b.jobs = append(b.jobs, j)
+	b.mutex.Unlock()
+	b.notify()
+}
+
+func (b *backlog) close() {
+	b.mutex.Lock()
+	b.closed = true
+	b.mutex.Unlock()
+	b.notify()
+}
+
+func (b *backlog) notify() {
+	select {
+	case b.signal <- struct{}{}:
+	default:
+	}
+}
+
+// take waits for batches to be pushed and returns them, closed is true when
+// no more batches will be pushed.
+func (b *backlog) take() (jobs []job, closed bool) {
+	for {
+		b.mutex.Lock()
+		jobs, closed = b.jobs, b.closed
+		b.jobs = nil
+		b.mutex.Unlock()
+
+		if len(jobs) != 0 || closed {
+			return
+		}
+
+		<-b.signal
+	}
+}
+
+// job is a message batch waiting in the queue of a destination.
+type job struct {
+	group  string
+	stream string
+	batch  lib.MessageBatch
+	dlv    *delivery
 }
 
 type reader struct {
@@ -45,10 +135,104 @@ type reader struct {
 	name string
 }
 
+// stages are the optional processing steps applied to messages read from the
+// sources before they are buffered.
+type stages struct {
+	budget     *lib.Budget
+	multiline  *multiline.Aggregator
+	parser     *parse.Parser
+	transforms *transform.Transform
+	filters    *filter.Filter
+	redactor   *redact.Redactor
+}
+
+// process runs msg through the processing stages, it returns the messages that
+// are ready to be buffered.
+func (s *stages) process(msg lib.Message, now time.Time) lib.MessageBatch {
+	if s.multiline == nil {
+		return s.prepare(lib.MessageBatch{msg}, now)
+	}
+
+	batch, merged := s.multiline.Add(msg, now)
+
+	if merged {
+		ack(lib.MessageBatch{msg})
+	}
+
+	s.budget.Hold(s.multiline.Bytes())
+	return s.prepare(batch, now)
+}
+
+// flush returns the messages that were held by the multiline stage for longer
+// than their timeout, or all of them if force is true.
+func (s *stages) flush(now time.Time, force bool) lib.MessageBatch {
+	if s.multiline == nil {
+		return nil
+	}
+	batch := s.multiline.Flush(now, force)
+	s.budget.Hold(s.multiline.Bytes())
+	return s.prepare(batch, now)
+}
+
+func (s *stages) prepare(batch lib.MessageBatch, now time.Time) lib.MessageBatch {
+	list := batch[:0]
+
+	for _, msg := range batch {
+		if s.parser != nil {
+			s.parser.Apply(&msg)
+		}
+
+		if s.transforms != nil {
+			s.transforms.Apply(&msg)
+		}
+
+		if s.filters != nil && !s.filters.Keep(msg, now) {
+			ack(lib.MessageBatch{msg})
+			continue
+		}
+
+		if s.redactor != nil {
+			s.redactor.Apply(&msg)
+		}
+
+		list = append(list, msg)
+	}
+
+	return list
+}
+
+// delivery tracks the writes of a message batch to the destinations, when all
+// writes have completed the batch is acknowledged to the readers it came from.
+// Writes that still fail after their retries drop the batch, it is
+// acknowledged as well so the checkpoints of the readers keep moving instead
+// of replaying everything read since the failure after a restart.
+type delivery struct {
+	batch   lib.MessageBatch
+	budget  *lib.Budget
+	pending int32
+}
+
+func newDelivery(batch lib.MessageBatch, budget *lib.Budget, count int) *delivery {
+	return &delivery{
+		batch:   batch,
+		budget:  budget,
+		pending: int32(count),
+	}
+}
+
+// done reports that the write to one of the destinations has completed.
+func (d *delivery) done() {
+	if atomic.AddInt32(&d.pending, -1) == 0 {
+		d.budget.Release(d.batch)
+		ack(d.batch)
+	}
+}
+
 func main() {
 	var err error
 	var src string
 	var dst string
+	var configFile string
 	var hostname string
 	var level = lib.LogLevel(log.InfoLevel)
 	var maxBytes int
@@ -56,11 +240,18 @@ func main() {
 	var flushTimeout time.Duration
 	var cacheTimeout time.Duration
 	var profileAddr string
+	var retry lib.RetryConfig
+	var spoolDir string
+	var spoolConfig spool.Config
+	var budgetLimits lib.BudgetLimits
+	var queueSize int
+	var concurrency int
 
 	hostname, _ = os.Hostname()
 
 	flag.StringVar(&src, "src", "stdin", "A comma separated list of log sources from which messages will be read ["+strings.Join(lib.SourcesAvailable(), ", ")+"]")
 	flag.StringVar(&dst, "dst", "stdout", "A comma separated list of log destinations to which messages will be written ["+strings.Join(lib.DestinationsAvailable(), ", ")+"]")
+	flag.StringVar(&configFile, "config", "", "Path to a JSON, YAML or TOML file declaring the log sources and destinations, overrides -src and -dst")
 	flag.StringVar(&hostname, "hostname", hostname, "The hostname advertised by ecs-logs")
 	flag.Var(&level, "log-level", "The minimum level of log messages shown by ecs-logs")
 	flag.IntVar(&maxBytes, "max-batch-bytes", 1000000, "The maximum size in bytes of a message batch")
@@ -68,6 +259,16 @@ func main() {
 	flag.DurationVar(&flushTimeout, "flush-timeout", 5*time.Second, "How often messages will be flushed")
 	flag.DurationVar(&cacheTimeout, "cache-timeout", 5*time.Minute, "How to wait before clearing unused internal cache")
 	flag.StringVar(&profileAddr, "pprof-addr", "", "Address to serve profile information")
+	flag.IntVar(&retry.MaxAttempts, "retry-max", 5, "The maximum number of attempts at writing a message batch to a destination")
+	flag.DurationVar(&retry.MinDelay, "retry-min-delay", 100*time.Millisecond, "How long to wait before the first retry of a failed write")
+	flag.DurationVar(&retry.MaxDelay, "retry-max-delay", 30*time.Second, "The maximum time to wait between two attempts at writing a message batch")
+	flag.StringVar(&spoolDir, "spool-dir", "", "Directory where message batches that could not be delivered are stored until their destination recovers")
+	flag.Int64Var(&spoolConfig.MaxBytes, "spool-max-bytes", 1000000000, "The maximum size in bytes of the spool of each destination")
+	flag.IntVar(&budgetLimits.MaxBytes, "max-memory", 0, "The maximum size in bytes of messages held in memory, zero means no limit")
+	flag.IntVar(&budgetLimits.MaxStreamBytes, "max-stream-memory", 0, "The maximum size in bytes of messages held in memory for a single stream, zero means no limit")
+	flag.Var(&budgetLimits.Policy, "overflow-policy", "What to do with messages exceeding the memory limits [block, drop-oldest, drop-newest]")
+	flag.IntVar(&queueSize, "queue-size", 100, "The number of message batches handed in advance to the workers of each destination, batches beyond it wait in the backlog of the destination and count against the memory budget")
+	flag.IntVar(&concurrency, "concurrency", 10, "The number of concurrent write operations to each destination, the batches of a stream are always written one at a time")
 	flag.Parse()
 
 	logger := &lib.LogHandler{
@@ -89,28 +290,62 @@ func main() {
 	}
 
 	var store = lib.NewStore()
+	var budget = lib.NewBudget(budgetLimits)
 	var sources []source
 	var readers []reader
 	var dests []destination
+	var router *route.Router
+	var stages stages
 
 	if len(hostname) == 0 {
 		log.Fatal("no hostname configured")
 	}
 
-	if sources = getSources(strings.Split(src, ",")); len(sources) == 0 {
+	if len(configFile) != 0 {
+		var conf config.Config
+
+		if conf, sources, dests, err = loadConfig(configFile); err != nil {
+			log.WithError(err).Fatal("invalid configuration")
+		}
+
+		if router, err = newRouter(conf); err != nil {
+			log.WithError(err).Fatal("invalid configuration")
+		}
+
+		if stages, err = newStages(conf); err != nil {
+			log.WithError(err).Fatal("invalid configuration")
+		}
+	} else {
+		sources = getSources(strings.Split(src, ","))
+		dests = getDestinations(strings.Split(dst, ","))
+	}
+
+	stages.budget = budget
+
+	if len(sources) == 0 {
 		log.Fatal("no or invalid log sources")
 	}
 
-	if dests = getDestinations(strings.Split(dst, ",")); len(dests) == 0 {
+	if len(dests) == 0 {
 		log.Fatal("no or invalid log destinations")
 	}
 
+	for i, dest := range dests {
+		dests[i].Destination = lib.NewRetryDestination(dest.Destination, retry)
+	}
+
+	if len(spoolDir) != 0 {
+		if err = spoolDestinations(dests, spoolDir, spoolConfig); err != nil {
+			log.WithError(err).Fatal("failed to open destination spools")
+		}
+	}
+
+	startDestinations(dests, queueSize, concurrency)
+
 	if readers, err = openSources(sources); err != nil {
 		log.WithError(err).Fatal("failed to open log sources readers")
 	}
 
-	join := &sync.WaitGroup{}
-
 	limits := lib.StreamLimits{
 		MaxCount: maxCount,
 		MaxBytes: maxBytes,
@@ -118,12 +353,35 @@ func main() {
 	}
 
 	expchan := time.Tick(flushTimeout / 2)
+	var mlchan <-chan time.Time
+	if stages.multiline != nil {
+		mlchan = time.Tick(stages.multiline.Interval())
+	}
 	msgchan := make(chan lib.Message, len(readers))
 	sigchan := make(chan os.Signal, 1)
 	counter := int32(len(readers))
 	startReaders(readers, msgchan, &counter, hostname)
 	setupSignals(sigchan)
 
+	// When the memory budget is exceeded and the overflow policy is to block,
+	// the messages are kept aside and the main loop stops receiving from
+	// msgchan until enough memory was released, which blocks the readers.
+	var input <-chan lib.Message = msgchan
+	var pending lib.MessageBatch
+	var dropped int64
+	var filtered map[string]int64
+
+	expvar.Publish("dropped_messages", expvar.Func(func() interface{} {
+		count, _ := budget.Dropped()
+		return count
+	}))
+
+	if stages.filters != nil {
+		expvar.Publish("filtered_messages", expvar.Func(func() interface{} {
+			return stages.filters.Stats()
+		}))
+	}
+
 	for _, s := range sources {
 		log.WithField("source", s.name).Info("source enabled")
 	}
@@ -134,29 +392,65 @@ func main() {
 
 	for {
 		select {
-		case msg, ok := <-msgchan:
+		case msg, ok := <-input:
 			now := time.Now()
 
 			if !ok {
 				log.Info("waiting for all write operations to complete")
 				limits.Force = true
-				flushAll(dests, store, limits, now, join)
-				flushQueue(dests, store, logger.Queue, limits, now, join)
-				join.Wait()
+				for _, msg := range stages.flush(now, true) {
+					add(dests, router, store, budget, msg, limits, now)
+				}
+				flushAll(dests, router, store, budget, limits, now)
+				flushQueue(dests, router, store, budget, logger.Queue, limits, now)
+				stopDestinations(dests)
+
+				if err := lib.FlushCheckpoints(); err != nil {
+					log.WithError(err).Error("failed to commit checkpoints")
+				}
 				return
 			}
 
-			_, stream := store.Add(msg, now)
-			flush(dests, stream, limits, now, join)
+			if pending = admit(dests, router, store, budget, stages.process(msg, now), limits, now); len(pending) != 0 {
+				input = nil
+			}
+
+		case <-budget.C:
+			if len(pending) != 0 && budget.Fits(pending[0]) {
+				if pending = admit(dests, router, store, budget, pending, limits, time.Now()); len(pending) == 0 {
+					input = msgchan
+				}
+			}
+
+		case <-mlchan:
+			now := time.Now()
+
+			if batch := stages.flush(now, false); len(pending) != 0 {
+				pending = append(pending, batch...)
+			} else if pending = admit(dests, router, store, budget, batch, limits, now); len(pending) != 0 {
+				input = nil
+			}
 
 		case <-logger.Queue.C:
 			now := time.Now()
-			flushQueue(dests, store, logger.Queue, limits, now, join)
+			flushQueue(dests, router, store, budget, logger.Queue, limits, now)
 
 		case <-expchan:
 			now := time.Now()
-			flushAll(dests, store, limits, now, join)
-			removeExpired(dests, store, cacheTimeout, now)
+			flushAll(dests, router, store, budget, limits, now)
+			removeExpired(dests, stages.filters, store, cacheTimeout, now)
+
+			if count, bytes := budget.Dropped(); count != dropped {
+				log.WithFields(log.Fields{
+					"count": count,
+					"bytes": bytes,
+				}).Warn("messages were dropped because the memory limits were exceeded")
+				dropped = count
+			}
+
+			if stages.filters != nil {
+				filtered = logFiltered(stages.filters, filtered)
+			}
 
 		case sig := <-sigchan:
 			log.WithFields(log.Fields{"signal": sig.String()}).Info("closing message readers")
@@ -211,6 +505,99 @@ func getDestinations(names []string) (destinations []destination) {
 	return
 }
 
+func loadConfig(path string) (c config.Config, sources []source, destinations []destination, err error) {
+	if c, err = config.Load(path); err != nil {
+		return
+	}
+
+	for _, e := range c.Sources {
+		var src lib.Source
+
+		if src, err = e.NewSource(); err != nil {
+			return
+		}
+
+		sources = append(sources, source{
+			Source: src,
+			name:   e.Name,
+		})
+	}
+
+	for _, e := range c.Destinations {
+		var dst lib.Destination
+
+		if dst, err = e.NewDestination(); err != nil {
+			return
+		}
+
+		destinations = append(destinations, destination{
+			Destination: dst,
+			name:        e.Name,
+		})
+	}
+
+	return
+}
+
+func newStages(c config.Config) (s stages, err error) {
+	if len(c.Multiline) != 0 {
+		if s.multiline, err = multiline.New(c.Multiline); err != nil {
+			return
+		}
+	}
+
+	if len(c.Parsers) != 0 {
+		if s.parser, err = parse.New(c.Parsers); err != nil {
+			return
+		}
+	}
+
+	if len(c.Transforms) != 0 {
+		if s.transforms, err = transform.New(c.Transforms); err != nil {
+			return
+		}
+	}
+
+	if len(c.Filters) != 0 {
+		if s.filters, err = filter.New(c.Filters); err != nil {
+			return
+		}
+	}
+
+	if len(c.Redact.Rules) != 0 {
+		s.redactor, err = redact.New(c.Redact)
+	}
+
+	return
+}
+
+func newRouter(c config.Config) (*route.Router, error) {
+	if len(c.Routes) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, len(c.Destinations))
+
+	for i, e := range c.Destinations {
+		names[i] = e.Name
+	}
+
+	return route.NewRouter(c.Routes, names)
+}
+
+func spoolDestinations(dests []destination, dir string, config spool.Config) error {
+	for i, dest := range dests {
+		s, err := spool.Open(filepath.Join(dir, dest.name), config)
+
+		if err != nil {
+			return err
+		}
+
+		dests[i].Destination = spool.NewDestination(dest.name, dest.Destination, s, 0)
+	}
+	return nil
+}
+
 func openSources(sources []source) (readers []reader, err error) {
 	readers = make([]reader, 0, len(sources))
 
@@ -282,6 +669,7 @@ func read(r reader, c chan<- lib.Message, counter *int32, hostname string) {
 				"reader":  r.name,
 				"missing": "group",
 			}).Warn("dropping message because the a required field wasn't set")
+			ack(lib.MessageBatch{msg})
 			continue
 		}
 
@@ -290,6 +678,7 @@ func read(r reader, c chan<- lib.Message, counter *int32, hostname string) {
 				"reader":  r.name,
 				"missing": "stream",
 			}).Warn("dropping message because the a required field wasn't set")
+			ack(lib.MessageBatch{msg})
 			continue
 		}
 
@@ -309,25 +698,191 @@ func read(r reader, c chan<- lib.Message, counter *int32, hostname string) {
 	}
 }
 
-func write(dest destination, group, stream string, batch lib.MessageBatch, join *sync.WaitGroup) {
-	defer join.Done()
+func startDestinations(dests []destination, queueSize int, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// The queue size applies to the whole destination, it's shared by its
+	// workers.
+	size := (queueSize + concurrency - 1) / concurrency
+
+	if size < 1 {
+		size = 1
+	}
+
+	for i := range dests {
+		dest := &dests[i]
+		dest.backlog = newBacklog()
+		dest.queues = make([]chan job, concurrency)
+		dest.join = &sync.WaitGroup{}
+		dest.join.Add(concurrency)
+
+		for j := range dest.queues {
+			dest.queues[j] = make(chan job, size)
+			go work(*dest, dest.queues[j])
+		}
+
+		go distribute(*dest)
+	}
+}
+
+func stopDestinations(dests []destination) {
+	for _, dest := range dests {
+		dest.backlog.close()
+	}
+
+	for _, dest := range dests {
+		dest.join.Wait()
+
+		if err := lib.FlushDestination(dest.Destination); err != nil {
+			log.WithFields(log.Fields{
+				"destination": dest.name,
+				"error":       err,
+			}).Error("failed to flush destination")
+		}
+	}
+}
+
+// distribute hands the batches of the backlog to the workers of dest, waiting
+// when their queue is full, and closes the queues once the backlog is closed
+// and empty.
+func distribute(dest destination) {
+	for {
+		jobs, closed := dest.backlog.take()
+
+		for _, j := range jobs {
+			dest.queues[shard(j.group, j.stream, len(dest.queues))] <- j
+		}
+
+		if closed && len(jobs) == 0 {
+			break
+		}
+	}
+
+	for _, queue := range dest.queues {
+		close(queue)
+	}
+}
+
+func work(dest destination, queue <-chan job) {
+	defer dest.join.Done()
+
+	for j := range queue {
+		write(dest, j.group, j.stream, j.batch, j.dlv)
+	}
+}
+
+// enqueue submits a message batch to the backlog of the destination, it never
+// blocks.
+func enqueue(dest destination, j job) {
+	dest.backlog.push(j)
+}
 
+// shard returns the index of the worker writing the batches of a stream.
+func shard(group string, stream string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(group))
+	h.Write([]byte{0})
+	h.Write([]byte(stream))
+	return int(h.Sum32() % uint32(n))
+}
+
+func write(dest destination, group, stream string, batch lib.MessageBatch, dlv *delivery) {
 	var writer lib.Writer
 	var err error
 
-	if writer, err = dest.Open(group, stream); err != nil {
-		logDropBatch(dest.name, group, stream, err, batch)
-		return
+	if writer, err = dest.Open(group, stream); err == nil {
+		err = writer.WriteMessageBatch(batch)
+		writer.Close()
 	}
-	defer writer.Close()
 
-	if err = writer.WriteMessageBatch(batch); err != nil {
+	if err != nil {
 		logDropBatch(dest.name, group, stream, err, batch)
-		return
+	}
+
+	dlv.done()
+}
+
+// admit buffers the messages of batch within the limits of the memory budget.
+// When the overflow policy is to block, the messages that didn't fit are
+// returned so they can be admitted once memory was released.
+func admit(dests []destination, router *route.Router, store *lib.Store, budget *lib.Budget, batch lib.MessageBatch, limits lib.StreamLimits, now time.Time) lib.MessageBatch {
+	for i, msg := range batch {
+		if !budget.Fits(msg) {
+			if budget.Policy() == lib.Block {
+				forced := limits
+				forced.Force = true
+				flushAll(dests, router, store, budget, forced, now)
+				return batch[i:]
+			}
+
+			if !reclaim(store, budget, msg) {
+				drop(budget, lib.MessageBatch{msg})
+				continue
+			}
+		}
+
+		add(dests, router, store, budget, msg, limits, now)
+	}
+
+	return nil
+}
+
+func add(dests []destination, router *route.Router, store *lib.Store, budget *lib.Budget, msg lib.Message, limits lib.StreamLimits, now time.Time) {
+	_, stream := store.Add(msg, now)
+	budget.Acquire(msg)
+	flush(dests, router, stream, budget, limits, now)
+}
+
+// reclaim drops the oldest buffered messages until msg fits in the memory
+// budget, it returns false if that wasn't possible. When the limit of the
+// stream of msg is exceeded the messages are dropped from that stream,
+// otherwise they are dropped from all streams, oldest first.
+func reclaim(store *lib.Store, budget *lib.Budget, msg lib.Message) bool {
+	if budget.Policy() != lib.DropOldest {
+		return false
+	}
+
+	for !budget.Fits(msg) {
+		var stream *lib.Stream
+		var batch lib.MessageBatch
+
+		if budget.FitsStream(msg) {
+			if stream = store.Oldest(); stream != nil {
+				batch = stream.DropOldest(1)
+			}
+		} else {
+			if stream = store.Stream(msg.Group, msg.Stream); stream != nil {
+				batch = stream.DropOldest(msg.ContentLength())
+			}
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		budget.Release(batch)
+		drop(budget, batch)
+	}
+
+	return budget.Fits(msg)
+}
+
+func drop(budget *lib.Budget, batch lib.MessageBatch) {
+	budget.Drop(batch)
+	ack(batch)
+
+	for _, msg := range batch {
+		log.WithFields(log.Fields{
+			"group":  msg.Group,
+			"stream": msg.Stream,
+			"event":  msg.Event,
+		}).Debug("dropped")
 	}
 }
 
-func flush(dests []destination, stream *lib.Stream, limits lib.StreamLimits, now time.Time, join *sync.WaitGroup) {
+func flush(dests []destination, router *route.Router, stream *lib.Stream, budget *lib.Budget, limits lib.StreamLimits, now time.Time) {
 	for {
 		batch, reason := stream.Flush(limits, now)
 
@@ -352,26 +907,73 @@ func flush(dests []destination, stream *lib.Stream, limits lib.StreamLimits, now
 			"reason": reason,
 		}).Info("flushing message batch")
 
-		for _, dest := range dests {
-			join.Add(1)
-			go write(dest, stream.Group(), stream.Name(), batch, join)
+		dispatch(dests, router, stream.Group(), stream.Name(), batch, budget)
+	}
+}
+
+// dispatch submits batch to the destinations selected by the router, or to
+// all destinations if there is no router.
+func dispatch(dests []destination, router *route.Router, group, stream string, batch lib.MessageBatch, budget *lib.Budget) {
+	batches := make([]lib.MessageBatch, len(dests))
+	count := 0
+
+	if router == nil {
+		for i := range batches {
+			batches[i] = batch
+		}
+	} else {
+		batches = router.Split(batch)
+	}
+
+	for _, b := range batches {
+		if len(b) != 0 {
+			count++
+		}
+	}
+
+	if count == 0 {
+		log.WithFields(log.Fields{
+			"group":  group,
+			"stream": stream,
+			"count":  len(batch),
+		}).Debug("no routes matched the message batch")
+		budget.Release(batch)
+		ack(batch)
+		return
+	}
+
+	// The delivery holds the whole batch so messages that were not routed to
+	// any destination are released and acknowledged with the others.
+	dlv := newDelivery(batch, budget, count)
+
+	for i, dest := range dests {
+		if len(batches[i]) != 0 {
+			enqueue(dest, job{
+				group:  group,
+				stream: stream,
+				batch:  batches[i],
+				dlv:    dlv,
+			})
 		}
 	}
 }
 
-func flushAll(dests []destination, store *lib.Store, limits lib.StreamLimits, now time.Time, join *sync.WaitGroup) {
+func flushAll(dests []destination, router *route.Router, store *lib.Store, budget *lib.Budget, limits lib.StreamLimits, now time.Time) {
 	store.ForEach(func(group *lib.Group) {
 		group.ForEach(func(stream *lib.Stream) {
-			flush(dests, stream, limits, now, join)
+			flush(dests, router, stream, budget, limits, now)
 		})
 	})
 }
 
-func flushQueue(dests []destination, store *lib.Store, queue *lib.MessageQueue, limits lib.StreamLimits, now time.Time, join *sync.WaitGroup) {
+func flushQueue(dests []destination, router *route.Router, store *lib.Store, budget *lib.Budget, queue *lib.MessageQueue, limits lib.StreamLimits, now time.Time) {
 	streams := make(map[string]*lib.Stream)
 
+	// Messages logged by ecs-logs are never dropped, but still count against
+	// the memory budget.
 	for _, msg := range queue.Flush() {
 		_, stream := store.Add(msg, now)
+		budget.Acquire(msg)
 		key := stream.Group() + ":" + stream.Name()
 
 		if streams[key] == nil {
@@ -380,15 +982,18 @@ func flushQueue(dests []destination, store *lib.Store, queue *lib.MessageQueue,
 	}
 
 	for _, stream := range streams {
-		flush(dests, stream, limits, now, join)
+		flush(dests, router, stream, budget, limits, now)
 	}
 }
 
-func removeExpired(dests []destination, store *lib.Store, cacheTimeout time.Duration, now time.Time) {
+func removeExpired(dests []destination, filters *filter.Filter, store *lib.Store, cacheTimeout time.Duration, now time.Time) {
 	for _, stream := range store.RemoveExpired(cacheTimeout, now) {
 		for _, dest := range dests {
 			dest.Close(stream.Group(), stream.Name())
 		}
+		if filters != nil {
+			filters.Forget(stream.Group(), stream.Name())
+		}
 		log.WithFields(log.Fields{
 			"group":  stream.Group(),
 			"stream": stream.Name(),
@@ -396,6 +1001,33 @@ func removeExpired(dests []destination, store *lib.Store, cacheTimeout time.Dura
 	}
 }
 
+// logFiltered logs the number of messages discarded by each filter rule since
+// the last call, it returns the current statistics.
+func logFiltered(filters *filter.Filter, last map[string]int64) map[string]int64 {
+	stats := filters.Stats()
+
+	for name, count := range stats {
+		if n := count - last[name]; n != 0 {
+			log.WithFields(log.Fields{
+				"filter": name,
+				"count":  n,
+				"total":  count,
+			}).Info("messages were filtered")
+		}
+	}
+
+	return stats
+}
+
+func ack(batch lib.MessageBatch) {
+	if err := lib.AckBatch(batch); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"count": len(batch),
+		}).Error("failed to acknowledge message batch")
+	}
+}
+
 func logDropBatch(dest string, group string, stream string, err error, batch lib.MessageBatch) {
 	log.WithFields(log.Fields{
 		"group":       group,
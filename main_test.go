@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/ecs-logs/lib"
+)
+
+// testDestination records the messages written to each stream, writes block
+// while gate is not closed and fail with err when it's set.
+type testDestination struct {
+	mutex   sync.Mutex
+	streams map[string][]string
+	gate    chan struct{}
+	err     error
+}
+
+func newTestDestination() *testDestination {
+	gate := make(chan struct{})
+	close(gate)
+	return &testDestination{streams: make(map[string][]string), gate: gate}
+}
+
+func (d *testDestination) Open(group string, stream string) (lib.Writer, error) {
+	return testWriter{d}, nil
+}
+
+func (d *testDestination) Close(group string, stream string) {}
+
+type testWriter struct {
+	dest *testDestination
+}
+
+func (w testWriter) Close() error { return nil }
+
+func (w testWriter) WriteMessage(msg lib.Message) error {
+	return w.WriteMessageBatch(lib.MessageBatch{msg})
+}
+
+func (w testWriter) WriteMessageBatch(batch lib.MessageBatch) error {
+	<-w.dest.gate
+
+	if w.dest.err != nil {
+		return w.dest.err
+	}
+
+	w.dest.mutex.Lock()
+	defer w.dest.mutex.Unlock()
+
+	for _, msg := range batch {
+		key := msg.Group + ":" + msg.Stream
+		w.dest.streams[key] = append(w.dest.streams[key], msg.Event.Message)
+	}
+
+	return nil
+}
+
+func testJob(group string, stream string, message string, pos *lib.Position) job {
+	batch := lib.MessageBatch{{
+		Group:    group,
+		Stream:   stream,
+		Event:    ecslogs.Event{Level: ecslogs.INFO, Message: message},
+		Position: pos,
+	}}
+	return job{
+		group:  group,
+		stream: stream,
+		batch:  batch,
+		dlv:    newDelivery(batch, lib.NewBudget(lib.BudgetLimits{}), 1),
+	}
+}
+
+func TestEnqueueOrder(t *testing.T) {
+	d := newTestDestination()
+	dests := []destination{{Destination: d, name: "test"}}
+	startDestinations(dests, 4, 4)
+
+	for i := 0; i != 100; i++ {
+		for s := 0; s != 10; s++ {
+			enqueue(dests[0], testJob("G", strconv.Itoa(s), strconv.Itoa(i), nil))
+		}
+	}
+
+	stopDestinations(dests)
+
+	for s := 0; s != 10; s++ {
+		messages := d.streams["G:"+strconv.Itoa(s)]
+
+		if len(messages) != 100 {
+			t.Errorf("stream %d: invalid number of messages: %d", s, len(messages))
+			continue
+		}
+
+		for i, m := range messages {
+			if m != strconv.Itoa(i) {
+				t.Errorf("stream %d: messages were written out of order: %v", s, messages)
+				break
+			}
+		}
+	}
+}
+
+func TestEnqueueStalledDestination(t *testing.T) {
+	stalled, d := newTestDestination(), newTestDestination()
+	stalled.gate = make(chan struct{})
+	dests := []destination{{Destination: stalled, name: "stalled"}, {Destination: d, name: "test"}}
+	startDestinations(dests, 1, 1)
+
+	// The batches of the stalled destination wait in its backlog, enqueue
+	// doesn't block and the other destination keeps writing.
+	for i := 0; i != 10; i++ {
+		for _, dest := range dests {
+			enqueue(dest, testJob("G", "S", strconv.Itoa(i), nil))
+		}
+	}
+
+	for i := 0; i != 100; i++ {
+		d.mutex.Lock()
+		n := len(d.streams["G:S"])
+		d.mutex.Unlock()
+
+		if n == 10 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	d.mutex.Lock()
+	n := len(d.streams["G:S"])
+	d.mutex.Unlock()
+
+	if n != 10 {
+		t.Errorf("the destination should not have been blocked by the stalled one: %d batches written", n)
+	}
+
+	close(stalled.gate)
+	stopDestinations(dests)
+
+	if messages := stalled.streams["G:S"]; fmt.Sprint(messages) != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Errorf("no batches should have been dropped: %v", messages)
+	}
+}
+
+func TestWriteAcknowledgements(t *testing.T) {
+	log.SetHandler(discard.New())
+
+	// Batches are acknowledged once their writes completed, even when they
+	// were dropped, so the checkpoints don't get stuck on them.
+	tests := []struct {
+		err error
+	}{
+		{nil},
+		{errors.New("unavailable")},
+		{lib.PermanentError(errors.New("rejected"))},
+	}
+
+	for _, test := range tests {
+		var acked bool
+
+		c := lib.NewCheckpointInterval(func(string) error {
+			acked = true
+			return nil
+		}, 0)
+
+		d := newTestDestination()
+		d.err = test.err
+		j := testJob("G", "S", "Hello", c.Track("A"))
+		write(destination{Destination: d, name: "test"}, j.group, j.stream, j.batch, j.dlv)
+
+		if !acked {
+			t.Errorf("%v: the batch should have been acknowledged", test.err)
+		}
+	}
+}